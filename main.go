@@ -9,6 +9,7 @@ import (
 	"simple-one-api/pkg/apis"
 	"simple-one-api/pkg/embedding"
 	"simple-one-api/pkg/initializer"
+	"simple-one-api/pkg/mycommon"
 	"simple-one-api/pkg/mylog"
 	"simple-one-api/pkg/mywebui"
 	"simple-one-api/pkg/translation"
@@ -41,6 +42,9 @@ func main() {
 	r := gin.New()
 	r.Use(gin.Recovery())
 
+	// 在路由前解析来源IP并检查全局的ip_filter
+	r.Use(mycommon.IPFilterMiddleware)
+
 	// 配置 CORS 中间件
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"}, // 允许所有来源，如果需要限制来源，可以将 "*" 替换为具体的 URL
@@ -84,6 +88,19 @@ func main() {
 	//r.POST("/v1/chat/completions", handler.OpenAIHandler)
 	r.GET("/v1/models", apis.ModelsHandler)
 	r.GET("/v1/models/:model", apis.RetrieveModelHandler)
+	r.GET("/v1/quota", apis.QuotaHandler)
+
+	// API key管理和监控接口，需要配置admin_key
+	admin := r.Group("/admin", apis.AdminAuthMiddleware)
+	{
+		admin.POST("/keys", apis.CreateKeyHandler)
+		admin.GET("/keys", apis.ListKeysHandler)
+		admin.GET("/keys/:id", apis.GetKeyHandler)
+		admin.PATCH("/keys/:id", apis.UpdateKeyHandler)
+		admin.POST("/keys/:id/disable", apis.DisableKeyHandler)
+		admin.DELETE("/keys/:id", apis.DeleteKeyHandler)
+		admin.GET("/limiter/queues", apis.LimiterQueuesHandler)
+	}
 
 	r.POST("/v2/translate", translation.TranslateV2Handler)
 	r.POST("/translate", translation.TranslateV1Handler)
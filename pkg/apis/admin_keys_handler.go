@@ -0,0 +1,138 @@
+package apis
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mycommon"
+	"simple-one-api/pkg/mykeys"
+	"simple-one-api/pkg/mylog"
+	"simple-one-api/pkg/utils"
+)
+
+// AdminAuthMiddleware 校验管理接口的admin_key，未配置admin_key时管理接口不可用
+func AdminAuthMiddleware(c *gin.Context) {
+	if !mykeys.IsEnabled() {
+		mycommon.SendOpenAIErrorResponse(c, http.StatusForbidden, "admin api is disabled, admin_key is not configured", "invalid_request_error", "admin_disabled")
+		c.Abort()
+		return
+	}
+
+	token, _ := utils.GetAPIKeyFromHeader(c)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(config.GSOAConf.AdminKey)) != 1 {
+		mycommon.SendOpenAIErrorResponse(c, http.StatusUnauthorized, "Incorrect admin key provided.", "invalid_request_error", "invalid_api_key")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+func sendAdminKeyError(c *gin.Context, err error) {
+	if errors.Is(err, mykeys.ErrKeyNotFound) {
+		mycommon.SendOpenAIErrorResponse(c, http.StatusNotFound, err.Error(), "invalid_request_error", "not_found")
+		return
+	}
+	mylog.Logger.Error("admin keys error", zap.Error(err))
+	mycommon.SendOpenAIErrorResponse(c, http.StatusInternalServerError, err.Error(), "server_error", "")
+}
+
+func bindKeyUpdate(c *gin.Context) (*mykeys.KeyUpdate, bool) {
+	var u mykeys.KeyUpdate
+	if err := c.ShouldBindJSON(&u); err != nil {
+		mycommon.SendOpenAIErrorResponse(c, http.StatusBadRequest, err.Error(), "invalid_request_error", "")
+		return nil, false
+	}
+	return &u, true
+}
+
+// CreateKeyHandler 创建API key，明文key只在本次响应中返回
+func CreateKeyHandler(c *gin.Context) {
+	u, ok := bindKeyUpdate(c)
+	if !ok {
+		return
+	}
+
+	secret, key, err := mykeys.CreateKey(u)
+	if err != nil {
+		sendAdminKeyError(c, err)
+		return
+	}
+
+	mylog.Logger.Info("api key created", zap.String("id", key.ID), zap.String("key_prefix", key.KeyPrefix))
+	c.JSON(http.StatusCreated, gin.H{
+		"key":  secret,
+		"data": key,
+	})
+}
+
+// ListKeysHandler 列出所有API key，不包含明文key
+func ListKeysHandler(c *gin.Context) {
+	keys, err := mykeys.ListKeys()
+	if err != nil {
+		sendAdminKeyError(c, err)
+		return
+	}
+	if keys == nil {
+		keys = []*mykeys.ManagedKey{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   keys,
+	})
+}
+
+// GetKeyHandler 查询单个API key
+func GetKeyHandler(c *gin.Context) {
+	key, err := mykeys.GetKey(c.Param("id"))
+	if err != nil {
+		sendAdminKeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+// UpdateKeyHandler 修改API key，只修改请求中出现的字段
+func UpdateKeyHandler(c *gin.Context) {
+	u, ok := bindKeyUpdate(c)
+	if !ok {
+		return
+	}
+
+	key, err := mykeys.UpdateKey(c.Param("id"), u)
+	if err != nil {
+		sendAdminKeyError(c, err)
+		return
+	}
+	mylog.Logger.Info("api key updated", zap.String("id", key.ID))
+	c.JSON(http.StatusOK, key)
+}
+
+// DisableKeyHandler 禁用API key，禁用后立即生效
+func DisableKeyHandler(c *gin.Context) {
+	disabled := true
+	key, err := mykeys.UpdateKey(c.Param("id"), &mykeys.KeyUpdate{Disabled: &disabled})
+	if err != nil {
+		sendAdminKeyError(c, err)
+		return
+	}
+	mylog.Logger.Info("api key disabled", zap.String("id", key.ID))
+	c.JSON(http.StatusOK, key)
+}
+
+// DeleteKeyHandler 删除API key
+func DeleteKeyHandler(c *gin.Context) {
+	id := c.Param("id")
+	if err := mykeys.DeleteKey(id); err != nil {
+		sendAdminKeyError(c, err)
+		return
+	}
+	mylog.Logger.Info("api key deleted", zap.String("id", id))
+	c.JSON(http.StatusOK, gin.H{
+		"id":      id,
+		"deleted": true,
+	})
+}
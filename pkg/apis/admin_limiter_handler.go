@@ -0,0 +1,15 @@
+package apis
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"simple-one-api/pkg/mylimiter"
+)
+
+// LimiterQueuesHandler 返回各限流器等待队列的深度和等待时间，用于监控
+func LimiterQueuesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   mylimiter.GetQueueStats(),
+	})
+}
@@ -4,7 +4,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"simple-one-api/pkg/config"
-	"sort"
+	"simple-one-api/pkg/myauth"
+	"simple-one-api/pkg/utils"
 	"time"
 )
 
@@ -15,14 +16,16 @@ type Model struct {
 	OwnedBy string `json:"owned_by"`
 }
 
+// getRequestNamespace 返回请求中API key所属的namespace
+func getRequestNamespace(c *gin.Context) string {
+	apikey, _ := utils.GetAPIKeyFromHeader(c)
+	return myauth.LookupNamespace(apikey)
+}
+
+// ModelsHandler 只列出调用方所属namespace可以使用的模型
 func ModelsHandler(c *gin.Context) {
 	var models []Model
-	keys := make([]string, 0, len(config.ModelToService))
-
-	for k := range config.SupportModels {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys) // 对keys进行排序
+	keys := config.GetNamespaceModels(getRequestNamespace(c))
 
 	t := time.Now()
 	for _, k := range keys {
@@ -56,8 +59,11 @@ func ModelsHandler(c *gin.Context) {
 // RetrieveModelHandler RetrieveModelHandler用于根据模型ID检索模型信息
 func RetrieveModelHandler(c *gin.Context) {
 	modelID := c.Param("model") // 从路径中获取模型ID
+	namespace := getRequestNamespace(c)
 
-	if _, found := config.ModelToService[modelID]; found {
+	services, _ := config.GetModelServices(modelID, namespace)
+	redirectServices, _ := config.GetModelServices(config.GetNamespaceModelRedirect(namespace, modelID), namespace)
+	if config.HasNamespaceService(services, namespace) || config.HasNamespaceService(redirectServices, namespace) {
 		model := Model{
 			ID:      "gpt-3.5-turbo-instruct",
 			Object:  "model",
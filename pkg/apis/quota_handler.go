@@ -0,0 +1,38 @@
+package apis
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"simple-one-api/pkg/myauth"
+	"simple-one-api/pkg/mycommon"
+	"simple-one-api/pkg/mylog"
+	"simple-one-api/pkg/myquota"
+	"simple-one-api/pkg/utils"
+)
+
+// QuotaHandler 查询请求中API key的token预算在当前周期的用量和剩余额度
+func QuotaHandler(c *gin.Context) {
+	apikey, err := utils.GetAPIKeyFromHeader(c)
+	if err != nil {
+		mylog.Logger.Error(err.Error())
+	}
+
+	keyConfig, exists := myauth.LookupKeyConfig(apikey)
+	if !exists {
+		mycommon.SendOpenAIErrorResponse(c, http.StatusUnauthorized, "Incorrect API key provided.", "invalid_request_error", "invalid_api_key")
+		return
+	}
+
+	statuses, err := myquota.GetQuotaStatus(keyConfig)
+	if err != nil {
+		mylog.Logger.Error("GetQuotaStatus error", zap.Error(err))
+		mycommon.SendOpenAIErrorResponse(c, http.StatusInternalServerError, err.Error(), "server_error", "")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   statuses,
+	})
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	CAPABILITY_VISION      = "vision"
+	CAPABILITY_TOOLS       = "tools"
+	CAPABILITY_JSON_SCHEMA = "json_schema"
+	CAPABILITY_REASONING   = "reasoning"
+)
+
+// ErrCapabilityUnsupported 模型对应的服务都不支持请求中用到的能力
+var ErrCapabilityUnsupported = errors.New("capability not supported")
+
+// CapabilitiesConf 服务支持的能力，未配置的能力保持原有行为：
+// vision按multi_content_models判断，tools、json_schema、reasoning视为支持，max_context为0时不限制(见GetMaxContextTokens)
+type CapabilitiesConf struct {
+	Vision     *bool `json:"vision,omitempty" yaml:"vision,omitempty"`
+	Tools      *bool `json:"tools,omitempty" yaml:"tools,omitempty"`
+	JSONSchema *bool `json:"json_schema,omitempty" yaml:"json_schema,omitempty" mapstructure:"json_schema"`
+	Reasoning  *bool `json:"reasoning,omitempty" yaml:"reasoning,omitempty"`
+	MaxContext int   `json:"max_context" yaml:"max_context" mapstructure:"max_context"`
+}
+
+// RequestFeatures 请求中用到的需要服务支持的能力
+type RequestFeatures struct {
+	Vision        bool
+	Tools         bool
+	JSONSchema    bool
+	Reasoning     bool
+	ContextTokens int
+}
+
+// SupportsVision 判断服务的模型是否支持图片输入，未配置vision时按模型名及其重定向、映射后的名称判断
+func SupportsVision(s *ModelDetails, model string) bool {
+	if s.Capabilities.Vision != nil {
+		return *s.Capabilities.Vision
+	}
+	if IsSupportMultiContent(model) {
+		return true
+	}
+	if redirectModel, exists := MatchModelMap(s.ModelRedirect, model); exists {
+		model = redirectModel
+	}
+	if mappedModel, exists := MatchModelMap(s.ModelMap, model); exists {
+		model = mappedModel
+	}
+	return IsSupportMultiContent(model)
+}
+
+// GetUnsupportedCapabilities 返回服务不支持的请求能力，全部支持时返回空
+func GetUnsupportedCapabilities(s *ModelDetails, model string, features *RequestFeatures) []string {
+	if features == nil {
+		return nil
+	}
+
+	caps := &s.Capabilities
+	var unsupported []string
+	if features.Vision && !SupportsVision(s, model) {
+		unsupported = append(unsupported, CAPABILITY_VISION)
+	}
+	if features.Tools && caps.Tools != nil && !*caps.Tools {
+		unsupported = append(unsupported, CAPABILITY_TOOLS)
+	}
+	if features.JSONSchema && caps.JSONSchema != nil && !*caps.JSONSchema {
+		unsupported = append(unsupported, CAPABILITY_JSON_SCHEMA)
+	}
+	if features.Reasoning && caps.Reasoning != nil && !*caps.Reasoning {
+		unsupported = append(unsupported, CAPABILITY_REASONING)
+	}
+	return unsupported
+}
+
+// joinCapabilities 拼接不支持的能力，用于错误信息
+func joinCapabilities(capabilities map[string]bool) string {
+	var list []string
+	for _, c := range []string{CAPABILITY_VISION, CAPABILITY_TOOLS, CAPABILITY_JSON_SCHEMA, CAPABILITY_REASONING} {
+		if capabilities[c] {
+			list = append(list, c)
+		}
+	}
+	return strings.Join(list, ", ")
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"simple-one-api/pkg/mylog"
+	"testing"
+)
+
+func TestGetModelServiceWithCapabilities(t *testing.T) {
+	mylog.InitLog("prod")
+
+	yes, no := true, false
+	ModelToService = map[string][]ModelDetails{
+		"vmodel": {
+			{ServiceName: "text", ServiceID: "text", ServiceModel: ServiceModel{Enabled: true, Models: []string{"vmodel"},
+				Capabilities: CapabilitiesConf{Vision: &no, MaxContext: 8000}}},
+			{ServiceName: "vision", ServiceID: "vision", ServiceModel: ServiceModel{Enabled: true, Models: []string{"vmodel"},
+				Capabilities: CapabilitiesConf{Vision: &yes, Tools: &no}}},
+		},
+	}
+	defer func() { ModelToService = nil }()
+
+	s, err := GetModelServiceWithOptions("vmodel", "", &RouteOptions{Features: &RequestFeatures{Vision: true}})
+	if err != nil || s.ServiceID != "vision" {
+		t.Fatalf("expected vision service, got %v, %v", s, err)
+	}
+
+	s, err = GetModelServiceWithOptions("vmodel", "", &RouteOptions{Features: &RequestFeatures{Tools: true, ContextTokens: 1000}})
+	if err != nil || s.ServiceID != "text" {
+		t.Fatalf("expected text service, got %v, %v", s, err)
+	}
+
+	_, err = GetModelServiceWithOptions("vmodel", "", &RouteOptions{Features: &RequestFeatures{Vision: true, Tools: true}})
+	if !errors.Is(err, ErrCapabilityUnsupported) {
+		t.Fatalf("expected ErrCapabilityUnsupported, got %v", err)
+	}
+
+	_, err = GetModelServiceWithOptions("vmodel", "", &RouteOptions{Features: &RequestFeatures{Tools: true, ContextTokens: 9000}})
+	var ctxErr *ContextLengthError
+	if !errors.As(err, &ctxErr) || ctxErr.MaxContextTokens != 8000 {
+		t.Fatalf("expected ContextLengthError with max 8000, got %v", err)
+	}
+}
+
+func TestRandomModelWithCapabilities(t *testing.T) {
+	mylog.InitLog("prod")
+
+	yes, no := true, false
+	ModelToService = map[string][]ModelDetails{
+		"text-model": {
+			{ServiceName: "text", ServiceID: "text", ServiceModel: ServiceModel{Enabled: true, Models: []string{"text-model"},
+				Capabilities: CapabilitiesConf{Vision: &no}}},
+		},
+		"vision-model": {
+			{ServiceName: "vision", ServiceID: "vision", ServiceModel: ServiceModel{Enabled: true, Models: []string{"vision-model"},
+				Capabilities: CapabilitiesConf{Vision: &yes, MaxContext: 8000}}},
+		},
+	}
+	defer func() { ModelToService = nil }()
+
+	// random只会选到支持请求中用到的能力的模型
+	for i := 0; i < 20; i++ {
+		s, model, err := GetRandomEnabledModelDetailsWithOptions("", &RouteOptions{Features: &RequestFeatures{Vision: true}})
+		if err != nil || s.ServiceID != "vision" || model != "vision-model" {
+			t.Fatalf("expected vision-model, got %v, %s, %v", s, model, err)
+		}
+	}
+
+	_, _, err := GetRandomEnabledModelDetailsWithOptions("", &RouteOptions{Features: &RequestFeatures{Vision: true, ContextTokens: 9000}})
+	if err == nil {
+		t.Fatal("expected no model for a request exceeding the context window")
+	}
+}
@@ -0,0 +1,270 @@
+package config
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"simple-one-api/pkg/mylog"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+const (
+	defaultCircuitMinRequests = 10
+	defaultCircuitWindow      = 60
+	defaultCircuitCooldown    = 30
+)
+
+// DefaultCircuitFailOn 未配置fail_on时计为失败的错误类型
+var DefaultCircuitFailOn = []string{RETRY_ON_CONNECTION, RETRY_ON_TIMEOUT, "401", "403", "429", RETRY_ON_5XX}
+
+// ErrCircuitOpen 模型对应的服务/凭证全部处于熔断状态
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// circuitBreaker 单个服务或凭证的熔断器状态
+type circuitBreaker struct {
+	mu                  sync.Mutex
+	state               string
+	consecutiveFailures int
+	windowStart         time.Time
+	requests            int
+	failures            int
+	openedAt            time.Time
+	// 半开状态下只允许一个探测请求，probeStarted用于在探测请求没有结果时超时后重新探测
+	probeInFlight bool
+	probeStarted  time.Time
+}
+
+var (
+	circuitBreakers     = make(map[string]*circuitBreaker)
+	circuitBreakersLock = &sync.RWMutex{}
+)
+
+// IsCircuitBreakerEnabled 服务配置了连续失败次数或失败比例时才启用熔断
+func IsCircuitBreakerEnabled(s *ModelDetails) bool {
+	return s.CircuitBreaker.ConsecutiveFailures > 0 || s.CircuitBreaker.FailureRate > 0
+}
+
+// GetCircuitBreakerFailOn 返回服务配置的计为失败的错误类型，未配置时使用默认值
+func GetCircuitBreakerFailOn(s *ModelDetails) []string {
+	if len(s.CircuitBreaker.FailOn) > 0 {
+		return s.CircuitBreaker.FailOn
+	}
+	return DefaultCircuitFailOn
+}
+
+func getCircuitBreaker(key string, create bool) *circuitBreaker {
+	circuitBreakersLock.RLock()
+	cb, exists := circuitBreakers[key]
+	circuitBreakersLock.RUnlock()
+	if exists || !create {
+		return cb
+	}
+
+	circuitBreakersLock.Lock()
+	defer circuitBreakersLock.Unlock()
+	if cb, exists = circuitBreakers[key]; !exists {
+		cb = &circuitBreaker{state: CircuitClosed, windowStart: time.Now()}
+		circuitBreakers[key] = cb
+	}
+	return cb
+}
+
+// setState 切换熔断器状态并记录日志，调用方需持有cb.mu
+func (cb *circuitBreaker) setState(key string, state string, reason string) {
+	if cb.state == state {
+		return
+	}
+	mylog.Logger.Warn("Circuit breaker state changed",
+		zap.String("key", key),
+		zap.String("from", cb.state),
+		zap.String("to", state),
+		zap.String("reason", reason),
+		zap.Int("consecutive_failures", cb.consecutiveFailures),
+		zap.Int("window_requests", cb.requests),
+		zap.Int("window_failures", cb.failures))
+
+	cb.state = state
+	switch state {
+	case CircuitOpen:
+		cb.openedAt = time.Now()
+	case CircuitClosed:
+		cb.consecutiveFailures = 0
+		cb.requests = 0
+		cb.failures = 0
+		cb.windowStart = time.Now()
+	}
+}
+
+func getCircuitCooldown(conf *CircuitBreakerConf) time.Duration {
+	cooldown := conf.Cooldown
+	if cooldown <= 0 {
+		cooldown = defaultCircuitCooldown
+	}
+	return time.Duration(cooldown) * time.Second
+}
+
+// availableLocked 判断熔断器是否允许请求通过，不改变状态。冷却时间过后或者半开状态下，
+// 没有探测请求在途时允许一个请求通过。调用方需持有cb.mu
+func (cb *circuitBreaker) availableLocked(conf *CircuitBreakerConf) bool {
+	cooldown := getCircuitCooldown(conf)
+	switch cb.state {
+	case CircuitOpen:
+		return time.Since(cb.openedAt) >= cooldown
+	case CircuitHalfOpen:
+		return !cb.probeInFlight || time.Since(cb.probeStarted) >= cooldown
+	}
+	return true
+}
+
+// available 判断熔断器是否允许请求通过，没有副作用，用于选择服务和凭证
+func (cb *circuitBreaker) available(conf *CircuitBreakerConf) bool {
+	if cb == nil {
+		return true
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.availableLocked(conf)
+}
+
+// acquire 请求真正发出前调用，冷却时间过后进入半开状态，半开状态下只有第一个请求作为探测请求通过
+func (cb *circuitBreaker) acquire(key string, conf *CircuitBreakerConf) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if !cb.availableLocked(conf) {
+		return false
+	}
+	if cb.state == CircuitOpen {
+		cb.setState(key, CircuitHalfOpen, "cooldown elapsed")
+	}
+	if cb.state == CircuitHalfOpen {
+		cb.probeInFlight = true
+		cb.probeStarted = time.Now()
+	}
+	return true
+}
+
+// release 请求没有得到结果(被取消或本地限流)，释放探测请求，允许下一个请求探测
+func (cb *circuitBreaker) release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.probeInFlight = false
+}
+
+// record 记录一次调用结果，根据连续失败次数或失败比例决定是否熔断
+func (cb *circuitBreaker) record(key string, conf *CircuitBreakerConf, failed bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	// 半开状态下由探测请求的结果决定恢复还是继续熔断
+	if cb.state == CircuitHalfOpen {
+		cb.probeInFlight = false
+		if failed {
+			cb.setState(key, CircuitOpen, "half-open probe failed")
+		} else {
+			cb.setState(key, CircuitClosed, "half-open probe succeeded")
+		}
+		return
+	}
+
+	window := conf.Window
+	if window <= 0 {
+		window = defaultCircuitWindow
+	}
+	if time.Since(cb.windowStart) > time.Duration(window)*time.Second {
+		cb.windowStart = time.Now()
+		cb.requests = 0
+		cb.failures = 0
+	}
+
+	cb.requests++
+	if !failed {
+		cb.consecutiveFailures = 0
+		return
+	}
+	cb.failures++
+	cb.consecutiveFailures++
+
+	if cb.state == CircuitOpen {
+		return
+	}
+
+	if conf.ConsecutiveFailures > 0 && cb.consecutiveFailures >= conf.ConsecutiveFailures {
+		cb.setState(key, CircuitOpen, "consecutive failures reached threshold")
+		return
+	}
+
+	minRequests := conf.MinRequests
+	if minRequests <= 0 {
+		minRequests = defaultCircuitMinRequests
+	}
+	if conf.FailureRate > 0 && cb.requests >= minRequests &&
+		float64(cb.failures)*100/float64(cb.requests) >= conf.FailureRate {
+		cb.setState(key, CircuitOpen, "failure rate reached threshold")
+	}
+}
+
+// IsCircuitAvailable 判断服务(credID为空时)或服务下的某个凭证是否允许请求通过，不改变熔断器状态
+func IsCircuitAvailable(s *ModelDetails, credID string) bool {
+	if !IsCircuitBreakerEnabled(s) {
+		return true
+	}
+	return getCircuitBreaker(GetExcludeKey(s, credID), false).available(&s.CircuitBreaker)
+}
+
+// isServiceCircuitAvailable 配置了credential_list的服务至少有一个凭证未熔断，否则服务本身未熔断
+func isServiceCircuitAvailable(s *ModelDetails) bool {
+	if len(s.CredentialList) == 0 {
+		return IsCircuitAvailable(s, "")
+	}
+	for i := range s.CredentialList {
+		if IsCircuitAvailable(s, GetCredentialID(s, i)) {
+			return true
+		}
+	}
+	return false
+}
+
+// AcquireCircuit 向选定的服务和凭证发出请求前调用，半开状态下只允许一个探测请求通过，
+// 返回false时不能发出请求。返回true后必须调用RecordCircuitResult或ReleaseCircuit
+func AcquireCircuit(s *ModelDetails, credID string) bool {
+	if !IsCircuitBreakerEnabled(s) {
+		return true
+	}
+	key := GetExcludeKey(s, credID)
+	cb := getCircuitBreaker(key, false)
+	return cb == nil || cb.acquire(key, &s.CircuitBreaker)
+}
+
+// ReleaseCircuit 请求被取消或者没有发出时调用，不计入熔断统计，只释放半开状态的探测请求
+func ReleaseCircuit(s *ModelDetails, credID string) {
+	if !IsCircuitBreakerEnabled(s) {
+		return
+	}
+	if cb := getCircuitBreaker(GetExcludeKey(s, credID), false); cb != nil {
+		cb.release()
+	}
+}
+
+// RecordCircuitResult 记录一次上游调用的结果。使用凭证时只记录到凭证的熔断器，
+// 单个凭证失败不影响服务的其他凭证；没有使用凭证时记录到服务的熔断器
+func RecordCircuitResult(s *ModelDetails, credID string, failed bool) {
+	if !IsCircuitBreakerEnabled(s) {
+		return
+	}
+	key := GetExcludeKey(s, credID)
+	getCircuitBreaker(key, true).record(key, &s.CircuitBreaker, failed)
+}
+
+// resetCircuitBreakers 配置重新加载后ServiceID会变化，清空所有熔断器
+func resetCircuitBreakers() {
+	circuitBreakersLock.Lock()
+	circuitBreakers = make(map[string]*circuitBreaker)
+	circuitBreakersLock.Unlock()
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"simple-one-api/pkg/mylog"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerConsecutiveFailures(t *testing.T) {
+	mylog.InitLog("prod")
+	resetCircuitBreakers()
+
+	s := &ModelDetails{
+		ServiceID: "svc",
+		ServiceModel: ServiceModel{
+			CircuitBreaker: CircuitBreakerConf{ConsecutiveFailures: 3, Cooldown: 1},
+		},
+	}
+
+	RecordCircuitResult(s, "", true)
+	RecordCircuitResult(s, "", true)
+	if !IsCircuitAvailable(s, "") {
+		t.Fatal("expected circuit to be closed before reaching threshold")
+	}
+
+	RecordCircuitResult(s, "", true)
+	if IsCircuitAvailable(s, "") {
+		t.Fatal("expected circuit to be open after 3 consecutive failures")
+	}
+
+	// 冷却后进入半开状态，只允许一个探测请求通过，检查可用性不改变状态
+	getCircuitBreaker("svc", false).openedAt = time.Now().Add(-2 * time.Second)
+	if !IsCircuitAvailable(s, "") || !IsCircuitAvailable(s, "") {
+		t.Fatal("expected circuit to be available for a probe after cooldown")
+	}
+	if st := getCircuitBreaker("svc", false).state; st != CircuitOpen {
+		t.Fatalf("expected availability check without side effects, got %s", st)
+	}
+	if !AcquireCircuit(s, "") {
+		t.Fatal("expected first request to be admitted as probe")
+	}
+	if AcquireCircuit(s, "") || IsCircuitAvailable(s, "") {
+		t.Fatal("expected only one probe in half-open state")
+	}
+
+	// 探测请求被取消后允许下一个请求探测
+	ReleaseCircuit(s, "")
+	if !AcquireCircuit(s, "") {
+		t.Fatal("expected a new probe after release")
+	}
+	RecordCircuitResult(s, "", false)
+	if st := getCircuitBreaker("svc", false).state; st != CircuitClosed {
+		t.Errorf("expected circuit to be closed after successful probe, got %s", st)
+	}
+}
+
+func TestCircuitBreakerFailureRate(t *testing.T) {
+	mylog.InitLog("prod")
+	resetCircuitBreakers()
+
+	s := &ModelDetails{
+		ServiceID: "svc",
+		ServiceModel: ServiceModel{
+			CredentialList: []map[string]interface{}{{}, {}},
+			CircuitBreaker: CircuitBreakerConf{FailureRate: 50, MinRequests: 4},
+		},
+	}
+	cred0 := GetCredentialID(s, 0)
+	cred1 := GetCredentialID(s, 1)
+
+	for i := 0; i < 4; i++ {
+		RecordCircuitResult(s, cred1, false)
+	}
+	for _, failed := range []bool{false, true, false, true} {
+		RecordCircuitResult(s, cred0, failed)
+	}
+	if IsCircuitAvailable(s, cred0) {
+		t.Fatal("expected credential circuit to be open at 50% failure rate")
+	}
+	if !isServiceCircuitAvailable(s) {
+		t.Error("expected service to stay available while another credential is closed")
+	}
+}
+
+func TestCircuitBreakerCredentialFailures(t *testing.T) {
+	mylog.InitLog("prod")
+	resetCircuitBreakers()
+
+	s := &ModelDetails{
+		ServiceID: "svc",
+		ServiceModel: ServiceModel{
+			CredentialList: []map[string]interface{}{{}, {}},
+			CircuitBreaker: CircuitBreakerConf{ConsecutiveFailures: 2},
+		},
+	}
+	cred0 := GetCredentialID(s, 0)
+	cred1 := GetCredentialID(s, 1)
+
+	// 一个凭证连续失败只熔断该凭证，不影响服务
+	RecordCircuitResult(s, cred0, true)
+	RecordCircuitResult(s, cred0, true)
+	if IsCircuitAvailable(s, cred0) || !IsCircuitAvailable(s, cred1) {
+		t.Fatal("expected only the failing credential to be open")
+	}
+	if !IsCircuitAvailable(s, "") || !isServiceCircuitAvailable(s) {
+		t.Fatal("expected service to stay available")
+	}
+
+	// 所有凭证都熔断后服务不可用
+	RecordCircuitResult(s, cred1, true)
+	RecordCircuitResult(s, cred1, true)
+	if isServiceCircuitAvailable(s) {
+		t.Fatal("expected service unavailable when all credentials are open")
+	}
+}
@@ -6,3 +6,11 @@ var PROXY_STRATEGY_FORCEALL = "force_all"
 var PROXY_STRATEGY_ALL = "all"
 var PROXY_STRATEGY_DEFAULT = "default"
 var PROXY_STRATEGY_DISABLED = "disabled"
+
+var RETRY_ON_CONNECTION = "connection"
+var RETRY_ON_TIMEOUT = "timeout"
+var RETRY_ON_4XX = "4xx"
+var RETRY_ON_5XX = "5xx"
+
+// DefaultRetryOn 未配置retry_on时可重试的错误类型：连接错误、超时、429和5xx
+var DefaultRetryOn = []string{RETRY_ON_CONNECTION, RETRY_ON_TIMEOUT, "429", RETRY_ON_5XX}
@@ -12,6 +12,7 @@ import (
 	"simple-one-api/pkg/mylog"
 	"simple-one-api/pkg/utils"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -25,6 +26,7 @@ var Debug bool
 var LogLevel string
 var SupportModels map[string]string
 var GlobalModelRedirect map[string]string
+var ModelFallbacks map[string][]string
 var SupportMultiContentModels = []string{"gpt-4o", "gpt-4-turbo", "glm-4v", "gemini-*", "yi-vision", "gpt-4o*"}
 
 // var SupportReasoningModels = []string{"deepseek-reasoner", "gpt-4-turbo"}
@@ -38,6 +40,8 @@ type Limit struct {
 	QPM         float64 `json:"qpm" yaml:"qpm"`
 	RPM         float64 `json:"rpm" yaml:"rpm"`
 	Concurrency float64 `json:"concurrency" yaml:"concurrency"`
+	TPM         float64 `json:"tpm" yaml:"tpm"`
+	TPD         float64 `json:"tpd" yaml:"tpd"`
 	Timeout     int     `json:"timeout" yaml:"timeout"`
 }
 
@@ -54,21 +58,74 @@ type ModelParams struct {
 
 // ServiceModel 定义相关结构体
 type ServiceModel struct {
-	Provider          string                   `json:"provider" yaml:"provider"`
-	EmbeddingModels   []string                 `json:"embedding_models" yaml:"embedding_models"`
-	EmbeddingLimit    Limit                    `json:"embedding_limit" yaml:"embedding_limit"`
-	Models            []string                 `json:"models" yaml:"models"`
-	ReasoningModels   map[string]string        `json:"reasoning_models" yaml:"reasoning_models"`
-	Enabled           bool                     `json:"enabled" yaml:"enabled"`
-	Credentials       map[string]interface{}   `json:"credentials" yaml:"credentials"`
-	CredentialList    []map[string]interface{} `json:"credential_list" yaml:"credential_list"`
-	ServerURL         string                   `json:"server_url" yaml:"server_url" mapstructure:"server_url"`
-	ModelMap          map[string]string        `json:"model_map" yaml:"model_map"`
-	ModelRedirect     map[string]string        `json:"model_redirect" yaml:"model_redirect"`
-	Limit             Limit                    `json:"limit" yaml:"limit"`
-	UseProxy          *bool                    `json:"use_proxy,omitempty" yaml:"use_proxy,omitempty"`
-	Timeout           int                      `json:"timeout" yaml:"timeout"`
-	ProviderNamespace string                   `json:"provider_namespace" yaml:"provider_namespace" mapstructure:"provider_namespace"`
+	Provider            string                   `json:"provider" yaml:"provider"`
+	EmbeddingModels     []string                 `json:"embedding_models" yaml:"embedding_models"`
+	EmbeddingLimit      Limit                    `json:"embedding_limit" yaml:"embedding_limit"`
+	Models              []string                 `json:"models" yaml:"models"`
+	ReasoningModels     map[string]string        `json:"reasoning_models" yaml:"reasoning_models"`
+	Enabled             bool                     `json:"enabled" yaml:"enabled"`
+	Credentials         map[string]interface{}   `json:"credentials" yaml:"credentials"`
+	CredentialList      []map[string]interface{} `json:"credential_list" yaml:"credential_list"`
+	ServerURL           string                   `json:"server_url" yaml:"server_url" mapstructure:"server_url"`
+	ModelMap            map[string]string        `json:"model_map" yaml:"model_map"`
+	ModelRedirect       map[string]string        `json:"model_redirect" yaml:"model_redirect"`
+	Limit               Limit                    `json:"limit" yaml:"limit"`
+	UseProxy            *bool                    `json:"use_proxy,omitempty" yaml:"use_proxy,omitempty"`
+	Timeout             int                      `json:"timeout" yaml:"timeout"`
+	ProviderNamespace   string                   `json:"provider_namespace" yaml:"provider_namespace" mapstructure:"provider_namespace"`
+	Retry               RetryConf                `json:"retry" yaml:"retry"`
+	Weight              int                      `json:"weight" yaml:"weight"`
+	CircuitBreaker      CircuitBreakerConf       `json:"circuit_breaker" yaml:"circuit_breaker" mapstructure:"circuit_breaker"`
+	Hedge               HedgeConf                `json:"hedge" yaml:"hedge"`
+	AdaptiveConcurrency AdaptiveConcurrencyConf  `json:"adaptive_concurrency" yaml:"adaptive_concurrency" mapstructure:"adaptive_concurrency"`
+	Capabilities        CapabilitiesConf         `json:"capabilities" yaml:"capabilities"`
+	MaxContextTokens    map[string]int           `json:"max_context_tokens" yaml:"max_context_tokens" mapstructure:"max_context_tokens"`
+}
+
+// RetryConf 定义上游调用失败时切换到下一个服务/凭证重试的策略
+type RetryConf struct {
+	MaxRetries int      `json:"max_retries" yaml:"max_retries" mapstructure:"max_retries"`
+	RetryOn    []string `json:"retry_on" yaml:"retry_on" mapstructure:"retry_on"`
+}
+
+// AdaptiveConcurrencyConf 根据上游的429等限流错误自动调整服务/凭证的并发数(AIMD)，在min和max之间，
+// 并按Retry-After、x-ratelimit-reset-*暂停，最长max_pause秒
+type AdaptiveConcurrencyConf struct {
+	Enabled        bool    `json:"enabled" yaml:"enabled"`
+	Min            int     `json:"min" yaml:"min"`
+	Max            int     `json:"max" yaml:"max"`
+	Initial        int     `json:"initial" yaml:"initial"`
+	DecreaseFactor float64 `json:"decrease_factor" yaml:"decrease_factor" mapstructure:"decrease_factor"`
+	MaxPause       int     `json:"max_pause" yaml:"max_pause" mapstructure:"max_pause"`
+}
+
+// CircuitBreakerConf 定义服务/凭证的熔断策略，连续失败次数或失败比例(百分比)达到阈值后熔断，冷却后进入半开状态
+type CircuitBreakerConf struct {
+	ConsecutiveFailures int      `json:"consecutive_failures" yaml:"consecutive_failures" mapstructure:"consecutive_failures"`
+	FailureRate         float64  `json:"failure_rate" yaml:"failure_rate" mapstructure:"failure_rate"`
+	MinRequests         int      `json:"min_requests" yaml:"min_requests" mapstructure:"min_requests"`
+	Window              int      `json:"window" yaml:"window"`
+	Cooldown            int      `json:"cooldown" yaml:"cooldown"`
+	FailOn              []string `json:"fail_on" yaml:"fail_on" mapstructure:"fail_on"`
+}
+
+// HedgeConf 定义对冲请求策略，首token在DelayMs毫秒内没有返回时，向另一个服务/凭证发送相同的请求，使用先返回的结果
+type HedgeConf struct {
+	DelayMs int `json:"delay_ms" yaml:"delay_ms" mapstructure:"delay_ms"`
+}
+
+// HedgeSupportedServices 支持对冲请求的服务，这些服务的调用在落败后可以通过请求的上下文取消；
+// 其他服务无法取消落败的请求，对冲会同时占用两份限流和额度，配置的hedge不生效
+var HedgeSupportedServices = []string{"openai", "deepseek", "zhipu", "groq"}
+
+// IsHedgeSupported 判断服务是否支持对冲请求
+func IsHedgeSupported(serviceName string) bool {
+	for _, name := range HedgeSupportedServices {
+		if strings.EqualFold(name, serviceName) {
+			return true
+		}
+	}
+	return false
 }
 
 type ProxyConf struct {
@@ -83,46 +140,160 @@ type ProxyConf struct {
 type Translation struct {
 	Enable         bool   `json:"enable" yaml:"enable"`
 	PromptTemplate string `json:"promptTemplate" yaml:"prompt_template"`
+	Model          string `json:"model" yaml:"model"`
 	Retry          int    `json:"retry" yaml:"retry"`
 	Concurrency    int    `json:"concurrency" yaml:"concurrency"`
 }
 
 type APIKeyConfig struct {
-	APIKey          string              `json:"api_key" yaml:"api_key"`
-	SupportedModels map[string][]string `json:"supported_models" yaml:"supported_models"`
+	APIKey          string               `json:"api_key" yaml:"api_key"`
+	SupportedModels map[string][]string  `json:"supported_models" yaml:"supported_models"`
+	Namespace       string               `json:"namespace" yaml:"namespace"`
+	Limit           Limit                `json:"limit" yaml:"limit"`
+	ModelLimits     map[string]Limit     `json:"model_limits" yaml:"model_limits" mapstructure:"model_limits"`
+	Quota           QuotaConf            `json:"quota" yaml:"quota"`
+	ModelQuotas     map[string]QuotaConf `json:"model_quotas" yaml:"model_quotas" mapstructure:"model_quotas"`
+	IPFilter        IPFilterConf         `json:"ip_filter" yaml:"ip_filter" mapstructure:"ip_filter"`
+	Priority        string               `json:"priority" yaml:"priority"`
+	QueueWeight     float64              `json:"queue_weight" yaml:"queue_weight" mapstructure:"queue_weight"`
+}
+
+// IPFilterConf IP或CIDR的允许和拒绝列表，Deny优先；Allow不为空时只允许列表中的地址
+type IPFilterConf struct {
+	Allow []string `json:"allow" yaml:"allow"`
+	Deny  []string `json:"deny" yaml:"deny"`
+}
+
+// RateLimitStoreConf 限流状态的存储，Type为memory(默认)或redis；多实例部署时使用redis在实例之间共享限流
+type RateLimitStoreConf struct {
+	Type         string `json:"type" yaml:"type"`
+	Addr         string `json:"addr" yaml:"addr"`
+	Password     string `json:"password" yaml:"password"`
+	DB           int    `json:"db" yaml:"db"`
+	Prefix       string `json:"prefix" yaml:"prefix"`
+	LeaseSeconds int    `json:"lease_seconds" yaml:"lease_seconds" mapstructure:"lease_seconds"`
+}
+
+// QuotaConf API key在一个周期内的token预算，Period为daily或monthly，各项为0时不限制
+type QuotaConf struct {
+	Period           string `json:"period" yaml:"period"`
+	PromptTokens     int64  `json:"prompt_tokens" yaml:"prompt_tokens" mapstructure:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens" yaml:"completion_tokens" mapstructure:"completion_tokens"`
+	TotalTokens      int64  `json:"total_tokens" yaml:"total_tokens" mapstructure:"total_tokens"`
+}
+
+// AuthConf 定义API key鉴权方式，Type为config(默认，使用api_keys)、http(回调外部服务)、file(从文件读取api_keys)或jwt(校验JWT)
+type AuthConf struct {
+	Type     string  `json:"type" yaml:"type"`
+	URL      string  `json:"url" yaml:"url"`
+	Timeout  int     `json:"timeout" yaml:"timeout"`
+	CacheTTL int     `json:"cache_ttl" yaml:"cache_ttl" mapstructure:"cache_ttl"`
+	File     string  `json:"file" yaml:"file"`
+	JWT      JWTConf `json:"jwt" yaml:"jwt"`
+}
+
+// JWTConf JWT鉴权配置，公钥从JWKS文件或URL读取并定期刷新，Issuer、Audience为空时不校验
+type JWTConf struct {
+	JWKSFile        string `json:"jwks_file" yaml:"jwks_file" mapstructure:"jwks_file"`
+	JWKSURL         string `json:"jwks_url" yaml:"jwks_url" mapstructure:"jwks_url"`
+	HMACSecret      string `json:"hmac_secret" yaml:"hmac_secret" mapstructure:"hmac_secret"`
+	Issuer          string `json:"issuer" yaml:"issuer"`
+	Audience        string `json:"audience" yaml:"audience"`
+	RefreshInterval int    `json:"refresh_interval" yaml:"refresh_interval" mapstructure:"refresh_interval"`
+	Leeway          int    `json:"leeway" yaml:"leeway"`
+	NamespaceClaim  string `json:"namespace_claim" yaml:"namespace_claim" mapstructure:"namespace_claim"`
+	ModelsClaim     string `json:"models_claim" yaml:"models_claim" mapstructure:"models_claim"`
 }
 
 type Configuration struct {
-	ServerPort         string                    `json:"server_port" yaml:"server_port"`
-	Debug              bool                      `json:"debug" yaml:"debug"`
-	LogLevel           string                    `json:"log_level" yaml:"log_level"`
-	Proxy              ProxyConf                 `json:"proxy" yaml:"proxy"`
-	APIKey             string                    `json:"api_key" yaml:"api_key"`
-	LoadBalancing      string                    `json:"load_balancing" yaml:"load_balancing"`
-	MultiContentModels []string                  `json:"multi_content_models" yaml:"multi_content_models"`
-	ModelRedirect      map[string]string         `json:"model_redirect" yaml:"model_redirect"`
-	ParamsRange        map[string]ModelParams    `json:"params_range" yaml:"params_range"`
-	Services           map[string][]ServiceModel `json:"services" yaml:"services"`
-	Translation        Translation               `json:"translation" yaml:"translation"`
-	EnableWeb          bool                      `json:"enable_web" yaml:"enable_web"`
-	APIKeys            []APIKeyConfig            `json:"api_keys" yaml:"api_keys"`
+	ServerPort         string                      `json:"server_port" yaml:"server_port"`
+	Debug              bool                        `json:"debug" yaml:"debug"`
+	LogLevel           string                      `json:"log_level" yaml:"log_level"`
+	Proxy              ProxyConf                   `json:"proxy" yaml:"proxy"`
+	APIKey             string                      `json:"api_key" yaml:"api_key"`
+	LoadBalancing      string                      `json:"load_balancing" yaml:"load_balancing"`
+	LBExplorationRate  float64                     `json:"lb_exploration_rate" yaml:"lb_exploration_rate" mapstructure:"lb_exploration_rate"`
+	HashKey            string                      `json:"hash_key" yaml:"hash_key" mapstructure:"hash_key"`
+	MultiContentModels []string                    `json:"multi_content_models" yaml:"multi_content_models"`
+	ModelRedirect      map[string]string           `json:"model_redirect" yaml:"model_redirect"`
+	ModelFallbacks     map[string][]string         `json:"model_fallbacks" yaml:"model_fallbacks" mapstructure:"model_fallbacks"`
+	TrafficSplit       map[string]TrafficSplitConf `json:"traffic_split" yaml:"traffic_split" mapstructure:"traffic_split"`
+	ParamsRange        map[string]ModelParams      `json:"params_range" yaml:"params_range"`
+	Services           map[string][]ServiceModel   `json:"services" yaml:"services"`
+	Translation        Translation                 `json:"translation" yaml:"translation"`
+	EnableWeb          bool                        `json:"enable_web" yaml:"enable_web"`
+	WSAllowedOrigins   []string                    `json:"ws_allowed_origins" yaml:"ws_allowed_origins" mapstructure:"ws_allowed_origins"`
+	IPFilter           IPFilterConf                `json:"ip_filter" yaml:"ip_filter" mapstructure:"ip_filter"`
+	TrustedProxies     []string                    `json:"trusted_proxies" yaml:"trusted_proxies" mapstructure:"trusted_proxies"`
+	APIKeys            []APIKeyConfig              `json:"api_keys" yaml:"api_keys"`
+	Auth               AuthConf                    `json:"auth" yaml:"auth"`
+	StorePath          string                      `json:"store_path" yaml:"store_path" mapstructure:"store_path"`
+	RateLimitStore     RateLimitStoreConf          `json:"rate_limit_store" yaml:"rate_limit_store" mapstructure:"rate_limit_store"`
+	AdminKey           string                      `json:"admin_key" yaml:"admin_key" mapstructure:"admin_key"`
+	Namespaces         map[string]NamespaceConf    `json:"namespaces" yaml:"namespaces"`
 }
 
 // ModelDetails 结构用于返回模型相关的服务信息
 type ModelDetails struct {
-	ServiceName  string `json:"service_name" yaml:"service_name"`
-	ServiceModel `json:",inline" yaml:",inline"`
-	ServiceID    string `json:"service_id" yaml:"service_id"`
-	Namespace    string `json:"-" yaml:"-"`
+	ServiceName         string `json:"service_name" yaml:"service_name"`
+	ServiceModel        `json:",inline" yaml:",inline"`
+	ServiceID           string   `json:"service_id" yaml:"service_id"`
+	Namespace           string   `json:"-" yaml:"-"`
+	HashNode            string   `json:"-" yaml:"-"`
+	CredentialHashNodes []string `json:"-" yaml:"-"`
+	LimiterKey          string   `json:"-" yaml:"-"`
+}
+
+// RouteOptions 选择服务和凭证时的附加信息
+type RouteOptions struct {
+	Excluded map[string]bool  // 已经尝试过的服务/凭证，见GetExcludeKey
+	HashKey  string           // consistent_hash策略使用的粘性key
+	Features *RequestFeatures // 请求中用到的能力，只选择支持这些能力的服务
 }
 
-// 创建模型到服务的映射
+// 创建模型到服务的映射，namespaces中的服务按provider_namespace为命名空间名称处理
 func createModelToServiceMap(config Configuration) map[string][]ModelDetails {
 	modelToService := make(map[string][]ModelDetails)
-	SupportModels = make(map[string]string)
-	for serviceName, serviceModels := range config.Services {
-		for _, model := range serviceModels {
+	namespaceSupportModels = make(map[string]map[string]string)
+	hashNodes := make(map[string]bool)
+	addModelServices(modelToService, hashNodes, config.Services, "")
+	for name, ns := range config.Namespaces {
+		if name == "" {
+			log.Println("ignore namespace with empty name")
+			continue
+		}
+		addModelServices(modelToService, hashNodes, ns.Services, name)
+	}
+
+	SupportModels = namespaceSupportModels[""]
+	if SupportModels == nil {
+		SupportModels = make(map[string]string)
+	}
+	return modelToService
+}
+
+// addModelServices 将服务加入模型到服务的映射，namespace不为空时覆盖服务的provider_namespace
+func addModelServices(modelToService map[string][]ModelDetails, hashNodes map[string]bool, services map[string][]ServiceModel, namespace string) {
+	for serviceName, serviceModels := range services {
+		for i, model := range serviceModels {
 			if model.Enabled {
+				if namespace != "" {
+					model.ProviderNamespace = namespace
+				}
+				supportModels := namespaceSupportModels[model.ProviderNamespace]
+				if supportModels == nil {
+					supportModels = make(map[string]string)
+					namespaceSupportModels[model.ProviderNamespace] = supportModels
+				}
+
+				// 服务在一致性哈希环上的标识，完全相同的配置用下标区分
+				hashNode := getServiceHashNode(serviceName, model)
+				if hashNodes[hashNode] {
+					hashNode += "#" + strconv.Itoa(i)
+				}
+				hashNodes[hashNode] = true
+				credHashNodes := getCredentialHashNodes(hashNode, model.CredentialList)
+
 				log.Printf("Models: %v, service Timeout:%v,Limit Timeout: %v, QPS: %v, QPM: %v, RPM: %v,Concurrency: %v\n",
 					model.Models, model.Timeout, model.Limit.Timeout, model.Limit.QPS, model.Limit.QPM, model.Limit.RPM, model.Limit.Concurrency)
 
@@ -140,39 +311,54 @@ func createModelToServiceMap(config Configuration) map[string][]ModelDetails {
 					model.Timeout = ServiceTimeOut
 				}
 
+				if model.Hedge.DelayMs > 0 && !IsHedgeSupported(serviceName) {
+					log.Printf("hedge is not supported by service %s, ignored\n", serviceName)
+					model.Hedge.DelayMs = 0
+				}
+
 				for _, modelName := range model.Models {
 					detail := ModelDetails{
-						ServiceName:  serviceName,
-						ServiceModel: model,
-						ServiceID:    uuid.New().String(),
-						Namespace:    model.ProviderNamespace,
+						ServiceName:         serviceName,
+						ServiceModel:        model,
+						ServiceID:           uuid.New().String(),
+						Namespace:           model.ProviderNamespace,
+						HashNode:            hashNode,
+						CredentialHashNodes: credHashNodes,
+						LimiterKey:          hashNode + "/" + modelName,
 					}
 
 					//modelNameLower := strings.ToLower(modelName)
 					modelToService[modelName] = append(modelToService[modelName], detail)
 
 					//存储支持的模型名称列表
-					SupportModels[modelName] = modelName
+					supportModels[modelName] = modelName
 					for k, v := range detail.ModelRedirect {
+						modelToService[k] = append(modelToService[k], detail)
+						// 模式不作为模型名称列出
+						if IsModelPattern(k) {
+							continue
+						}
+
 						//support models
-						SupportModels[k] = v
+						supportModels[k] = v
 
-						_, exists := SupportModels[v]
+						_, exists := supportModels[v]
 						if exists {
-							delete(SupportModels, v)
+							delete(supportModels, v)
 						}
-
-						//
-						modelToService[k] = append(modelToService[k], detail)
 						//delete(modelToService, modelName)
 					}
 				}
 
 				for _, modelName := range model.EmbeddingModels {
 					detail := ModelDetails{
-						ServiceName:  serviceName,
-						ServiceModel: model,
-						ServiceID:    uuid.New().String(),
+						ServiceName:         serviceName,
+						ServiceModel:        model,
+						ServiceID:           uuid.New().String(),
+						Namespace:           model.ProviderNamespace,
+						HashNode:            hashNode,
+						CredentialHashNodes: credHashNodes,
+						LimiterKey:          hashNode + "/embedding/" + modelName,
 					}
 
 					//modelNameLower := strings.ToLower(modelName)
@@ -184,7 +370,6 @@ func createModelToServiceMap(config Configuration) map[string][]ModelDetails {
 			}
 		}
 	}
-	return modelToService
 }
 
 // InitConfig 初始化配置
@@ -254,6 +439,7 @@ func InitConfig(configName string) error {
 	} else {
 		LoadBalancingStrategy = conf.LoadBalancing
 	}
+	applyLBSettings(&conf)
 
 	GSOAConf = &conf
 
@@ -284,8 +470,12 @@ func InitConfig(configName string) error {
 
 	// 创建映射
 	ModelToService = createModelToServiceMap(conf)
+	ResetLBState()
+	resetCircuitBreakers()
 
 	GlobalModelRedirect = conf.ModelRedirect
+	ModelFallbacks = conf.ModelFallbacks
+	TrafficSplit = conf.TrafficSplit
 
 	GTranslation = &conf.Translation
 
@@ -314,51 +504,224 @@ func GetAllModelService(modelName string) ([]ModelDetails, error) {
 
 // GetModelService 根据模型名称获取启用的服务和凭证信息
 func GetModelService(modelName string, namespace string) (*ModelDetails, error) {
-	if serviceDetails, found := ModelToService[modelName]; found {
-		var enabledServices []ModelDetails
-		for _, sd := range serviceDetails {
-			if sd.Enabled && sd.ProviderNamespace == namespace {
-				enabledServices = append(enabledServices, sd)
-			}
-		}
+	return GetModelServiceWithOptions(modelName, namespace, nil)
+}
 
-		if len(enabledServices) == 0 {
-			return nil, fmt.Errorf("no enabled model %s found in the configuration", modelName)
+// GetModelServiceWithOptions 根据模型名称获取启用的服务，跳过所有凭证都已尝试过的服务(用于失败后的重试)，
+// consistent_hash策略下使用opts.HashKey保持会话粘性
+func GetModelServiceWithOptions(modelName string, namespace string, opts *RouteOptions) (*ModelDetails, error) {
+	if opts == nil {
+		opts = &RouteOptions{}
+	}
+	if serviceDetails, found := GetModelServices(modelName, namespace); found {
+		enabledServices, err := filterModelServices(modelName, serviceDetails, namespace, opts)
+		if err != nil {
+			return nil, err
 		}
 
-		index := GetLBIndex(LoadBalancingStrategy, modelName, len(enabledServices))
+		index := GetLBIndexWithCandidates(GetLoadBalancingStrategy(namespace), modelName, opts.HashKey, getServiceCandidates(enabledServices))
 
 		return &enabledServices[index], nil
 	}
 	return nil, fmt.Errorf("model %s not found in the configuration", modelName)
 }
 
-func GetRandomEnabledModelDetails() (*ModelDetails, error) {
+// filterModelServices 返回模型在命名空间中可以处理请求的服务：跳过未启用、所有凭证都已尝试过、不支持请求中用到的能力、
+// 上下文窗口放不下请求以及处于熔断状态的服务；没有可用服务时按原因返回错误
+func filterModelServices(modelName string, serviceDetails []ModelDetails, namespace string, opts *RouteOptions) ([]ModelDetails, error) {
+	var enabledServices []ModelDetails
+	circuitOpen := false
+	unsupported := make(map[string]bool)
+	maxContext := 0
+	contextExceeded := false
+	for _, sd := range serviceDetails {
+		if sd.Enabled && sd.ProviderNamespace == namespace && !isServiceExhausted(&sd, opts.Excluded) {
+			// 跳过不支持请求中用到的能力的服务
+			if caps := GetUnsupportedCapabilities(&sd, modelName, opts.Features); len(caps) > 0 {
+				for _, c := range caps {
+					unsupported[c] = true
+				}
+				continue
+			}
+			// 跳过上下文窗口放不下请求的服务
+			if opts.Features != nil && opts.Features.ContextTokens > 0 {
+				if n := GetMaxContextTokens(&sd, modelName); n > 0 && opts.Features.ContextTokens > n {
+					contextExceeded = true
+					if n > maxContext {
+						maxContext = n
+					}
+					continue
+				}
+			}
+			// 跳过处于熔断状态的服务
+			if !isServiceCircuitAvailable(&sd) {
+				circuitOpen = true
+				continue
+			}
+			enabledServices = append(enabledServices, sd)
+		}
+	}
 
-	index := GetLBIndex(LoadBalancingStrategy, KEYNAME_RANDOM, len(ModelToService))
+	if len(enabledServices) == 0 {
+		if circuitOpen {
+			return nil, fmt.Errorf("all services of model %s are unavailable: %w", modelName, ErrCircuitOpen)
+		}
+		if contextExceeded {
+			return nil, &ContextLengthError{Model: modelName, MaxContextTokens: maxContext, RequestedTokens: opts.Features.ContextTokens}
+		}
+		if len(unsupported) > 0 {
+			return nil, fmt.Errorf("no service of model %s supports the requested features (%s): %w",
+				modelName, joinCapabilities(unsupported), ErrCapabilityUnsupported)
+		}
+		return nil, fmt.Errorf("no enabled model %s found in the configuration", modelName)
+	}
+	return enabledServices, nil
+}
+
+// getServiceCandidates 将服务列表转换为负载均衡候选，使用服务配置的weight
+func getServiceCandidates(services []ModelDetails) []LBCandidate {
+	candidates := make([]LBCandidate, len(services))
+	for i, sd := range services {
+		candidates[i] = LBCandidate{ID: sd.ServiceID, Weight: sd.Weight, Node: sd.HashNode}
+	}
+	return candidates
+}
+
+// GetCredentialID 返回服务中第index个凭证的ID，限流器等以此区分同一服务下的不同凭证
+func GetCredentialID(s *ModelDetails, index int) string {
+	return s.ServiceID + "_credentials_" + strconv.Itoa(index)
+}
 
+// GetLimiterKey 返回服务或凭证(id为ServiceID或GetCredentialID的结果)的限流键；限流键由配置内容生成，
+// 使用相同配置的多个实例得到相同的键，可以通过rate_limit_store共享限流
+func GetLimiterKey(s *ModelDetails, id string) string {
+	if s.LimiterKey == "" {
+		return id
+	}
+	return s.LimiterKey + strings.TrimPrefix(id, s.ServiceID)
+}
+
+// GetExcludeKey 返回一次调用尝试对应的排除键，没有凭证列表的服务使用ServiceID
+func GetExcludeKey(s *ModelDetails, credID string) string {
+	if credID == "" {
+		return s.ServiceID
+	}
+	return credID
+}
+
+// isServiceExhausted 判断服务的所有凭证是否都已经尝试过
+func isServiceExhausted(s *ModelDetails, excluded map[string]bool) bool {
+	if len(excluded) == 0 {
+		return false
+	}
+	if len(s.CredentialList) == 0 {
+		return excluded[s.ServiceID]
+	}
+	for i := range s.CredentialList {
+		if !excluded[GetCredentialID(s, i)] {
+			return false
+		}
+	}
+	return true
+}
+
+// GetRetryOn 返回服务配置的可重试错误类型，未配置时使用默认值
+func GetRetryOn(s *ModelDetails) []string {
+	if len(s.Retry.RetryOn) > 0 {
+		return s.Retry.RetryOn
+	}
+	return DefaultRetryOn
+}
+
+// GetRandomEnabledModelDetails 在命名空间可以使用的模型中随机选择一个服务
+func GetRandomEnabledModelDetails(namespace string) (*ModelDetails, error) {
 	keys := make([]string, 0, len(ModelToService))
 
-	// 遍历 ModelToService 映射，收集所有 Enabled 为 true 的 ModelDetails
-	for modelName := range ModelToService {
-		keys = append(keys, modelName)
+	// 遍历 ModelToService 映射，收集命名空间中有可用服务的模型
+	for modelName, services := range ModelToService {
+		if HasNamespaceService(services, namespace) {
+			keys = append(keys, modelName)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no enabled model found in namespace %q", namespace)
 	}
 
 	sort.Strings(keys)
 
+	strategy := GetLoadBalancingStrategy(namespace)
+	index := GetLBIndex(strategy, KEYNAME_RANDOM, len(keys))
+
 	model := keys[index]
 
-	modelDetails := ModelToService[model]
+	var modelDetails []ModelDetails
+	for _, sd := range ModelToService[model] {
+		if sd.Enabled && sd.ProviderNamespace == namespace {
+			modelDetails = append(modelDetails, sd)
+		}
+	}
 
-	index2 := GetLBIndex(LoadBalancingStrategy, model, len(modelDetails))
+	index2 := GetLBIndexWithCandidates(strategy, model, "", getServiceCandidates(modelDetails))
 
 	randomModel := modelDetails[index2]
 
 	return &randomModel, nil
 }
 
-func GetRandomEnabledModelDetailsV1() (*ModelDetails, string, error) {
-	md, err := GetRandomEnabledModelDetails()
+// GetRandomEnabledModel 在命名空间可以使用的模型中随机选择一个具体的模型名(不包括通配模式)，
+// 用于需要在路由前确定模型的场景，如未配置translation.model时翻译接口的鉴权
+func GetRandomEnabledModel(namespace string) (string, error) {
+	keys := make([]string, 0, len(ModelToService))
+	for modelName, services := range ModelToService {
+		if !IsModelPattern(modelName) && HasNamespaceService(services, namespace) {
+			keys = append(keys, modelName)
+		}
+	}
+	if len(keys) == 0 {
+		return "", fmt.Errorf("no enabled model found in namespace %q", namespace)
+	}
+
+	sort.Strings(keys)
+	return keys[getRandomIndex(len(keys))], nil
+}
+
+// GetRandomEnabledModelDetailsWithOptions 在命名空间可以使用的具体模型(不包括通配模式)中随机选择一个，返回选中的服务和模型名；
+// 与GetModelServiceWithOptions使用相同的过滤条件，选中的模型没有可以处理请求的服务时依次检查下一个模型
+func GetRandomEnabledModelDetailsWithOptions(namespace string, opts *RouteOptions) (*ModelDetails, string, error) {
+	if opts == nil {
+		opts = &RouteOptions{}
+	}
+	keys := make([]string, 0, len(ModelToService))
+	for modelName, services := range ModelToService {
+		if !IsModelPattern(modelName) && HasNamespaceService(services, namespace) {
+			keys = append(keys, modelName)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, "", fmt.Errorf("no enabled model found in namespace %q", namespace)
+	}
+	sort.Strings(keys)
+
+	strategy := GetLoadBalancingStrategy(namespace)
+	start := GetLBIndex(strategy, KEYNAME_RANDOM, len(keys))
+	var firstErr error
+	for i := range keys {
+		model := keys[(start+i)%len(keys)]
+		services, err := filterModelServices(model, ModelToService[model], namespace, opts)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		index := GetLBIndexWithCandidates(strategy, model, opts.HashKey, getServiceCandidates(services))
+		return &services[index], model, nil
+	}
+	return nil, "", firstErr
+}
+
+func GetRandomEnabledModelDetailsV1(namespace string) (*ModelDetails, string, error) {
+	md, err := GetRandomEnabledModelDetails(namespace)
 	if err != nil {
 		return nil, "", err
 	}
@@ -373,7 +736,7 @@ func GetRandomEnabledModelDetailsV1() (*ModelDetails, string, error) {
 
 // GetModelMapping 函数，根据model在ModelMap中查找对应的映射，如果找不到则返回原始model
 func GetModelMapping(s *ModelDetails, model string) string {
-	if mappedModel, exists := s.ModelMap[model]; exists {
+	if mappedModel, exists := MatchModelMap(s.ModelMap, model); exists {
 		mylog.Logger.Info("model map found", zap.String("model", model), zap.String("mappedModel", mappedModel))
 		return mappedModel
 	}
@@ -383,7 +746,7 @@ func GetModelMapping(s *ModelDetails, model string) string {
 
 // GetModelRedirect 函数，根据model在ModelMap中查找对应的映射，如果找不到则返回原始model
 func GetModelRedirect(s *ModelDetails, model string) string {
-	if redirectModel, exists := s.ModelRedirect[model]; exists {
+	if redirectModel, exists := MatchModelMap(s.ModelRedirect, model); exists {
 		mylog.Logger.Info("ModelRedirect model found", zap.String("model", model), zap.String("redirectModel", redirectModel))
 		return redirectModel
 	}
@@ -393,15 +756,7 @@ func GetModelRedirect(s *ModelDetails, model string) string {
 
 // GetGlobalModelRedirect 函数，根据model在ModelMap中查找对应的映射，如果找不到则返回原始model
 func GetGlobalModelRedirect(model string) string {
-	if redirectModel, exists := GlobalModelRedirect[KEYNAME_ALL]; exists {
-		if redirectModel == KEYNAME_ALL {
-			redirectModel = KEYNAME_RANDOM
-		}
-		mylog.Logger.Info("GlobalModelRedirect model all found", zap.String("model", model), zap.String("redirectModel", redirectModel))
-		return redirectModel
-	}
-
-	if redirectModel, exists := GlobalModelRedirect[model]; exists {
+	if redirectModel, exists := findModelRedirect(GlobalModelRedirect, model); exists {
 		mylog.Logger.Info("GlobalModelRedirect model found", zap.String("model", model), zap.String("redirectModel", redirectModel))
 		return redirectModel
 	}
@@ -410,6 +765,19 @@ func GetGlobalModelRedirect(model string) string {
 	return model
 }
 
+// GetModelFallbacks 返回模型的降级链，优先按客户端传入的模型名查找，其次按全局重定向后的模型名查找
+func GetModelFallbacks(clientModel string, redirectModel string) []string {
+	if fallbacks, exists := ModelFallbacks[clientModel]; exists {
+		mylog.Logger.Debug("ModelFallbacks found", zap.String("model", clientModel), zap.Strings("fallbacks", fallbacks))
+		return fallbacks
+	}
+	if fallbacks, exists := ModelFallbacks[redirectModel]; exists {
+		mylog.Logger.Debug("ModelFallbacks found", zap.String("model", redirectModel), zap.Strings("fallbacks", fallbacks))
+		return fallbacks
+	}
+	return nil
+}
+
 func ShowSupportModels() {
 	keys := make([]string, 0, len(ModelToService))
 
@@ -465,6 +833,17 @@ func initAPIKeyMap() {
 	for _, keyConfig := range GSOAConf.APIKeys {
 		apiKeyMap[keyConfig.APIKey] = keyConfig
 	}
+	// 命名空间中的api_keys只能使用该命名空间的服务
+	for name, ns := range GSOAConf.Namespaces {
+		for _, keyConfig := range ns.APIKeys {
+			if _, exists := apiKeyMap[keyConfig.APIKey]; exists {
+				log.Println("duplicate api key in namespace", name, "ignored")
+				continue
+			}
+			keyConfig.Namespace = name
+			apiKeyMap[keyConfig.APIKey] = keyConfig
+		}
+	}
 }
 
 func ValidateAPIKeyAndModel(apikey string, model string) (bool, string) {
@@ -479,15 +858,41 @@ func ValidateAPIKeyAndModel(apikey string, model string) (bool, string) {
 
 	mylog.Logger.Debug("ValidateAPIKeyAndModel", zap.String("model", model))
 
-	// 检查所有服务和通配符的配置
+	if IsModelSupportedByKey(&keyConfig, model) {
+		return true, ""
+	}
+	return false, "Forbidden: model not supported"
+}
+
+// GetAPIKeyConfig 返回api_keys中API key的配置
+func GetAPIKeyConfig(apikey string) (*APIKeyConfig, bool) {
+	keyConfig, exists := apiKeyMap[apikey]
+	if !exists {
+		return nil, false
+	}
+	return &keyConfig, true
+}
+
+// IsModelSupportedByKey 检查API key的supported_models中所有服务和通配符的配置
+func IsModelSupportedByKey(keyConfig *APIKeyConfig, model string) bool {
 	for service, models := range keyConfig.SupportedModels {
-		mylog.Logger.Info(service, zap.Any("SupportedModels", models))
+		mylog.Logger.Debug(service, zap.Any("SupportedModels", models))
 		for _, m := range models {
-			if m == "*" || m == model {
-				mylog.Logger.Debug("ValidateAPIKeyAndModel", zap.String("model", model), zap.String("m", m))
-				return true, ""
+			if m == "*" || MatchModelName(m, model) {
+				mylog.Logger.Debug("IsModelSupportedByKey", zap.String("model", model), zap.String("m", m))
+				return true
 			}
 		}
 	}
-	return false, "Forbidden: model not supported"
+	return false
+}
+
+// GetAPIKeyLimit 返回API key调用模型时的限流配置和限流器的键，model_limits中配置了该模型时替代key级别的limit；
+// 限流器的键会出现在日志和监控中，使用API key的哈希值而不是明文
+func GetAPIKeyLimit(keyConfig *APIKeyConfig, model string) (string, Limit) {
+	hashedKey := utils.HashAPIKey(keyConfig.APIKey)
+	if limit, exists := keyConfig.ModelLimits[model]; exists {
+		return "apikey_" + hashedKey + "_model_" + model, limit
+	}
+	return "apikey_" + hashedKey, keyConfig.Limit
 }
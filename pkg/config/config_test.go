@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -174,3 +175,20 @@ func TestApplyConfiguration(t *testing.T) {
 		t.Errorf("Expected service name to be test_service, got %s", modelDetails[0].ServiceName)
 	}
 }
+
+func TestGetAPIKeyLimit(t *testing.T) {
+	keyConfig := &APIKeyConfig{
+		APIKey:      "sk-secret",
+		Limit:       Limit{RPM: 10},
+		ModelLimits: map[string]Limit{"gpt-4o": {RPM: 2}},
+	}
+
+	key, limit := GetAPIKeyLimit(keyConfig, "glm-4")
+	if limit.RPM != 10 || strings.Contains(key, "sk-secret") {
+		t.Fatalf("unexpected key limit %s %+v", key, limit)
+	}
+	modelKey, limit := GetAPIKeyLimit(keyConfig, "gpt-4o")
+	if limit.RPM != 2 || modelKey == key || strings.Contains(modelKey, "sk-secret") {
+		t.Fatalf("unexpected model limit %s %+v", modelKey, limit)
+	}
+}
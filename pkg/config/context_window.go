@@ -0,0 +1,45 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrContextLengthExceeded 请求的上下文长度超过了模型所有服务的上下文窗口
+var ErrContextLengthExceeded = errors.New("context length exceeded")
+
+// ContextLengthError 上下文长度超限的详细信息，MaxContextTokens为可用服务中最大的上下文窗口
+type ContextLengthError struct {
+	Model            string
+	MaxContextTokens int
+	RequestedTokens  int
+}
+
+func (e *ContextLengthError) Error() string {
+	return fmt.Sprintf("This model's maximum context length is %d tokens. However, you requested about %d tokens. Please reduce the length of the messages or completion.",
+		e.MaxContextTokens, e.RequestedTokens)
+}
+
+func (e *ContextLengthError) Unwrap() error {
+	return ErrContextLengthExceeded
+}
+
+// GetMaxContextTokens 返回服务中模型的上下文窗口大小，依次按模型名、重定向后的名称、映射后的名称查找max_context_tokens，
+// 找不到时使用capabilities.max_context，返回0表示不限制
+func GetMaxContextTokens(s *ModelDetails, model string) int {
+	if n, exists := s.MaxContextTokens[model]; exists {
+		return n
+	}
+	if redirectModel, exists := MatchModelMap(s.ModelRedirect, model); exists {
+		model = redirectModel
+		if n, exists := s.MaxContextTokens[model]; exists {
+			return n
+		}
+	}
+	if mappedModel, exists := MatchModelMap(s.ModelMap, model); exists {
+		if n, exists := s.MaxContextTokens[mappedModel]; exists {
+			return n
+		}
+	}
+	return s.Capabilities.MaxContext
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// hashRingReplicas 每个权重单位对应的虚拟节点数
+const hashRingReplicas = 100
+
+const (
+	HASH_KEY_USER    = "user"
+	HASH_KEY_API_KEY = "api_key"
+	HASH_KEY_HEADER  = "header:"
+)
+
+// DefaultHashKeySource 未配置hash_key时使用OpenAI请求中的user字段作为粘性key
+var DefaultHashKeySource = HASH_KEY_USER
+
+var HashKeySource = DefaultHashKeySource
+
+// hashRing 一致性哈希环
+type hashRing struct {
+	hashes []uint32
+	owners map[uint32]int // 虚拟节点hash -> 候选下标
+}
+
+var (
+	hashRings     = make(map[string]*hashRing)
+	hashRingsLock = &sync.RWMutex{}
+)
+
+func hash32(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func newHashRing(candidates []LBCandidate) *hashRing {
+	ring := &hashRing{owners: make(map[uint32]int)}
+	for i, c := range candidates {
+		weight := c.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		for r := 0; r < weight*hashRingReplicas; r++ {
+			h := hash32(c.node() + "#" + strconv.Itoa(r))
+			if _, exists := ring.owners[h]; exists {
+				continue
+			}
+			ring.owners[h] = i
+			ring.hashes = append(ring.hashes, h)
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool { return ring.hashes[i] < ring.hashes[j] })
+	return ring
+}
+
+// getHashRing 按候选集合缓存哈希环，候选集合变化(如重试排除、熔断)时会使用新的环
+func getHashRing(candidates []LBCandidate) *hashRing {
+	nodes := make([]string, len(candidates))
+	for i, c := range candidates {
+		nodes[i] = c.node() + "*" + strconv.Itoa(c.Weight)
+	}
+	ringKey := strings.Join(nodes, "|")
+
+	hashRingsLock.RLock()
+	ring, exists := hashRings[ringKey]
+	hashRingsLock.RUnlock()
+	if exists {
+		return ring
+	}
+
+	ring = newHashRing(candidates)
+	hashRingsLock.Lock()
+	hashRings[ringKey] = ring
+	hashRingsLock.Unlock()
+	return ring
+}
+
+// getConsistentHashIndex 在一致性哈希环上查找hashKey对应的候选，增删服务时只有少量key会迁移
+func getConsistentHashIndex(hashKey string, candidates []LBCandidate) int {
+	if hashKey == "" {
+		// 没有粘性key时无法保持会话，随机选择
+		return getRandomIndex(len(candidates))
+	}
+
+	ring := getHashRing(candidates)
+	h := hash32(hashKey)
+	i := sort.Search(len(ring.hashes), func(i int) bool { return ring.hashes[i] >= h })
+	if i == len(ring.hashes) {
+		i = 0
+	}
+	return ring.owners[ring.hashes[i]]
+}
+
+func resetHashRings() {
+	hashRingsLock.Lock()
+	hashRings = make(map[string]*hashRing)
+	hashRingsLock.Unlock()
+}
+
+// getServiceHashNode 根据服务名、地址和凭证生成服务在哈希环上的稳定标识，配置重新加载后保持不变
+func getServiceHashNode(serviceName string, model ServiceModel) string {
+	creds, _ := json.Marshal(model.Credentials)
+	credList, _ := json.Marshal(model.CredentialList)
+	return serviceName + "/" + strconv.FormatUint(uint64(hash32(model.ServerURL+string(creds)+string(credList))), 16)
+}
+
+// getCredentialHashNodes 生成凭证列表中每个凭证在哈希环上的稳定标识
+func getCredentialHashNodes(serviceNode string, credentialList []map[string]interface{}) []string {
+	nodes := make([]string, len(credentialList))
+	for i, cred := range credentialList {
+		data, _ := json.Marshal(cred)
+		nodes[i] = serviceNode + "/" + strconv.FormatUint(uint64(hash32(string(data))), 16)
+	}
+	return nodes
+}
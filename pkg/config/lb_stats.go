@@ -0,0 +1,208 @@
+package config
+
+import (
+	"go.uber.org/zap"
+	"log"
+	"math/rand"
+	"simple-one-api/pkg/mycomdef"
+	"simple-one-api/pkg/mylog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// lbEWMAAlpha 新样本在指数加权移动平均中的权重
+const lbEWMAAlpha = 0.3
+
+// lbFailurePenalty 失败的请求按至少这么长的耗时计入统计，避免快速失败的服务看起来最快
+const lbFailurePenalty = 10 * time.Second
+
+// DefaultLBExplorationRate 自适应策略随机探索的默认比例，保证恢复的服务能重新获得流量
+const DefaultLBExplorationRate = 0.05
+
+var LBExplorationRate = DefaultLBExplorationRate
+
+// lbStat 记录一个服务或凭证的延迟和正在处理的请求数
+type lbStat struct {
+	mu          sync.Mutex
+	ttftEWMA    float64 // 首token耗时，毫秒
+	latencyEWMA float64 // 总耗时，毫秒
+	samples     int64
+	inflight    int64
+}
+
+var (
+	lbStats     = make(map[string]*lbStat)
+	lbStatsLock = &sync.RWMutex{}
+)
+
+func getLBStat(id string, create bool) *lbStat {
+	lbStatsLock.RLock()
+	st, exists := lbStats[id]
+	lbStatsLock.RUnlock()
+	if exists || !create {
+		return st
+	}
+
+	lbStatsLock.Lock()
+	defer lbStatsLock.Unlock()
+	if st, exists = lbStats[id]; !exists {
+		st = &lbStat{}
+		lbStats[id] = st
+	}
+	return st
+}
+
+// LBRequestStart 在向上游发起请求前调用，增加服务/凭证的在途请求数
+func LBRequestStart(ids ...string) {
+	for _, id := range ids {
+		atomic.AddInt64(&getLBStat(id, true).inflight, 1)
+	}
+}
+
+// LBRequestAbort 请求被取消(对冲落败或客户端断开)时调用，只减少在途请求数，不计入耗时和失败统计
+func LBRequestAbort(ids ...string) {
+	for _, id := range ids {
+		if st := getLBStat(id, false); st != nil {
+			atomic.AddInt64(&st.inflight, -1)
+		}
+	}
+}
+
+// LBRequestDone 在请求结束后调用，减少在途请求数，并更新首token耗时和总耗时的EWMA
+func LBRequestDone(ttft time.Duration, total time.Duration, success bool, ids ...string) {
+	if !success {
+		if ttft < lbFailurePenalty {
+			ttft = lbFailurePenalty
+		}
+		if total < lbFailurePenalty {
+			total = lbFailurePenalty
+		}
+	}
+
+	for _, id := range ids {
+		// 配置重载后旧ID的统计已被清空，不再记录
+		st := getLBStat(id, false)
+		if st == nil {
+			continue
+		}
+		atomic.AddInt64(&st.inflight, -1)
+
+		ttftMs := float64(ttft) / float64(time.Millisecond)
+		totalMs := float64(total) / float64(time.Millisecond)
+
+		st.mu.Lock()
+		if st.samples == 0 {
+			st.ttftEWMA = ttftMs
+			st.latencyEWMA = totalMs
+		} else {
+			st.ttftEWMA = lbEWMAAlpha*ttftMs + (1-lbEWMAAlpha)*st.ttftEWMA
+			st.latencyEWMA = lbEWMAAlpha*totalMs + (1-lbEWMAAlpha)*st.latencyEWMA
+		}
+		st.samples++
+		st.mu.Unlock()
+	}
+}
+
+// snapshot 返回统计的快照
+func (st *lbStat) snapshot() (ttft float64, latency float64, samples int64, inflight int64) {
+	if st == nil {
+		return 0, 0, 0, 0
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.ttftEWMA, st.latencyEWMA, st.samples, atomic.LoadInt64(&st.inflight)
+}
+
+// shouldExplore 按探索比例决定是否随机选择
+func shouldExplore() bool {
+	if LBExplorationRate <= 0 {
+		return false
+	}
+	randLock.Lock()
+	defer randLock.Unlock()
+	return rand.Float64() < LBExplorationRate
+}
+
+// getLeastLatencyIndex 选择首token耗时EWMA最小的候选，没有样本的候选优先被选中
+func getLeastLatencyIndex(key string, candidates []LBCandidate) int {
+	if shouldExplore() {
+		index := getRandomIndex(len(candidates))
+		logLBSelection(mycomdef.KEYNAME_LEAST_LATENCY, key, candidates[index].ID, true)
+		return index
+	}
+
+	best := 0
+	var bestTTFT, bestLatency float64
+	for i, c := range candidates {
+		ttft, latency, _, _ := getLBStat(c.ID, false).snapshot()
+		if i == 0 || ttft < bestTTFT || (ttft == bestTTFT && latency < bestLatency) {
+			best, bestTTFT, bestLatency = i, ttft, latency
+		}
+	}
+
+	logLBSelection(mycomdef.KEYNAME_LEAST_LATENCY, key, candidates[best].ID, false)
+	return best
+}
+
+// getLeastInflightIndex 选择在途请求数最少的候选，数量相同时随机选择
+func getLeastInflightIndex(key string, candidates []LBCandidate) int {
+	if shouldExplore() {
+		index := getRandomIndex(len(candidates))
+		logLBSelection(mycomdef.KEYNAME_LEAST_INFLIGHT, key, candidates[index].ID, true)
+		return index
+	}
+
+	var ties []int
+	var minInflight int64
+	for i, c := range candidates {
+		_, _, _, inflight := getLBStat(c.ID, false).snapshot()
+		if len(ties) == 0 || inflight < minInflight {
+			ties = []int{i}
+			minInflight = inflight
+		} else if inflight == minInflight {
+			ties = append(ties, i)
+		}
+	}
+
+	best := ties[getRandomIndex(len(ties))]
+	logLBSelection(mycomdef.KEYNAME_LEAST_INFLIGHT, key, candidates[best].ID, false)
+	return best
+}
+
+func logLBSelection(strategy string, key string, id string, explored bool) {
+	if mylog.Logger == nil {
+		return
+	}
+	ttft, latency, samples, inflight := getLBStat(id, false).snapshot()
+	mylog.Logger.Info("LB selection",
+		zap.String("strategy", strategy),
+		zap.String("key", key),
+		zap.String("id", id),
+		zap.Bool("explored", explored),
+		zap.Float64("ttft_ewma_ms", ttft),
+		zap.Float64("latency_ewma_ms", latency),
+		zap.Int64("samples", samples),
+		zap.Int64("inflight", inflight))
+}
+
+// LogLBStats 输出所有服务/凭证的延迟和在途请求统计
+func LogLBStats() {
+	lbStatsLock.RLock()
+	defer lbStatsLock.RUnlock()
+
+	for id, st := range lbStats {
+		ttft, latency, samples, inflight := st.snapshot()
+		log.Printf("LB stats id: %s, ttft_ewma_ms: %.1f, latency_ewma_ms: %.1f, samples: %d, inflight: %d\n",
+			id, ttft, latency, samples, inflight)
+	}
+}
+
+// resetLBStats 清空延迟和在途请求统计
+func resetLBStats() {
+	LogLBStats()
+
+	lbStatsLock.Lock()
+	lbStats = make(map[string]*lbStat)
+	lbStatsLock.Unlock()
+}
@@ -14,8 +14,26 @@ var (
 	rrIndices = make(map[string]*uint32)
 	randLock  = &sync.Mutex{}
 	modelLock = &sync.RWMutex{}
+
+	// swrrWeights 记录平滑加权轮询中每个key下各候选的当前权重
+	swrrWeights = make(map[string]map[string]int)
+	swrrLock    = &sync.Mutex{}
 )
 
+// LBCandidate 负载均衡的候选项，ID用于区分候选，Weight用于加权策略，Node是一致性哈希使用的稳定标识
+type LBCandidate struct {
+	ID     string
+	Weight int
+	Node   string
+}
+
+func (c LBCandidate) node() string {
+	if c.Node != "" {
+		return c.Node
+	}
+	return c.ID
+}
+
 func getRandomIndex(n int) int {
 	randLock.Lock()
 	defer randLock.Unlock()
@@ -50,6 +68,66 @@ func getHashIndex(key string, n int) int {
 	return int(h.Sum32()) % n
 }
 
+// getSmoothWeightedIndex 平滑加权轮询（与nginx相同的算法），低QPS下也能按权重比例均匀分配
+func getSmoothWeightedIndex(key string, candidates []LBCandidate) int {
+	swrrLock.Lock()
+	defer swrrLock.Unlock()
+
+	current, exists := swrrWeights[key]
+	if !exists {
+		current = make(map[string]int)
+		swrrWeights[key] = current
+	}
+
+	total := 0
+	best := -1
+	for i, c := range candidates {
+		w := c.Weight
+		if w <= 0 {
+			w = 1 // 未配置权重时默认为1
+		}
+		current[c.ID] += w
+		total += w
+		if best == -1 || current[c.ID] > current[candidates[best].ID] {
+			best = i
+		}
+	}
+	current[candidates[best].ID] -= total
+
+	return best
+}
+
+// ResetLBState 清空负载均衡的运行时状态，配置重新加载后ServiceID会变化，旧状态不再有意义
+func ResetLBState() {
+	modelLock.Lock()
+	rrIndices = make(map[string]*uint32)
+	modelLock.Unlock()
+
+	swrrLock.Lock()
+	swrrWeights = make(map[string]map[string]int)
+	swrrLock.Unlock()
+
+	resetLBStats()
+	resetHashRings()
+}
+
+// GetLBIndexWithCandidates 根据负载均衡策略从候选中选择一个，weighted和自适应策略会使用候选的ID和权重，
+// consistent_hash策略使用hashKey在哈希环上选择
+func GetLBIndexWithCandidates(lbStrategy string, key string, hashKey string, candidates []LBCandidate) int {
+	switch strings.ToLower(lbStrategy) {
+	case mycomdef.KEYNAME_CONSISTENT_HASH:
+		return getConsistentHashIndex(hashKey, candidates)
+	case mycomdef.KEYNAME_WEIGHTED:
+		return getSmoothWeightedIndex(key, candidates)
+	case mycomdef.KEYNAME_LEAST_LATENCY:
+		return getLeastLatencyIndex(key, candidates)
+	case mycomdef.KEYNAME_LEAST_INFLIGHT:
+		return getLeastInflightIndex(key, candidates)
+	default:
+		return GetLBIndex(lbStrategy, key, len(candidates))
+	}
+}
+
 func GetLBIndex(lbStrategy string, key string, length int) int {
 	lbs := strings.ToLower(lbStrategy)
 	switch lbs {
@@ -61,6 +139,9 @@ func GetLBIndex(lbStrategy string, key string, length int) int {
 		return getRoundRobinIndex(key, length)
 	case mycomdef.KEYNAME_HASH:
 		return getHashIndex(key, length)
+	case mycomdef.KEYNAME_WEIGHTED:
+		// 没有权重信息时等同于轮询
+		return getRoundRobinIndex(key, length)
 	default:
 		return getRandomIndex(length)
 	}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"simple-one-api/pkg/mylog"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSmoothWeightedIndex(t *testing.T) {
+	ResetLBState()
+
+	candidates := []LBCandidate{
+		{ID: "a", Weight: 5},
+		{ID: "b", Weight: 1},
+		{ID: "c", Weight: 1},
+	}
+
+	// 平滑加权轮询一个周期内的选择顺序是确定的
+	expected := []string{"a", "a", "b", "a", "c", "a", "a"}
+	for i, want := range expected {
+		got := candidates[GetLBIndexWithCandidates("weighted", "test-model", "", candidates)].ID
+		if got != want {
+			t.Errorf("round %d: expected %s, got %s", i, want, got)
+		}
+	}
+}
+
+func TestSmoothWeightedIndexRatio(t *testing.T) {
+	ResetLBState()
+
+	candidates := []LBCandidate{
+		{ID: "big", Weight: 3},
+		{ID: "small", Weight: 1},
+		{ID: "default"},
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 500; i++ {
+		counts[candidates[GetLBIndexWithCandidates("weighted", "ratio", "", candidates)].ID]++
+	}
+
+	if counts["big"] != 300 || counts["small"] != 100 || counts["default"] != 100 {
+		t.Errorf("unexpected distribution: %v", counts)
+	}
+}
+
+func TestAdaptiveStrategies(t *testing.T) {
+	ResetLBState()
+	LBExplorationRate = -1
+	defer func() { LBExplorationRate = DefaultLBExplorationRate }()
+
+	candidates := []LBCandidate{{ID: "slow"}, {ID: "fast"}}
+
+	LBRequestStart("slow", "fast")
+	LBRequestDone(800*time.Millisecond, 2*time.Second, true, "slow")
+	LBRequestDone(100*time.Millisecond, 1*time.Second, true, "fast")
+
+	if got := candidates[GetLBIndexWithCandidates("least_latency", "m", "", candidates)].ID; got != "fast" {
+		t.Errorf("least_latency: expected fast, got %s", got)
+	}
+
+	// 失败的请求会按惩罚耗时计入统计
+	LBRequestStart("fast")
+	LBRequestDone(10*time.Millisecond, 10*time.Millisecond, false, "fast")
+	if got := candidates[GetLBIndexWithCandidates("least_latency", "m", "", candidates)].ID; got != "slow" {
+		t.Errorf("least_latency after failure: expected slow, got %s", got)
+	}
+
+	LBRequestStart("fast", "fast", "slow")
+	if got := candidates[GetLBIndexWithCandidates("least_inflight", "m", "", candidates)].ID; got != "slow" {
+		t.Errorf("least_inflight: expected slow, got %s", got)
+	}
+
+	// 配置重载后统计被清空
+	ResetLBState()
+	_, _, samples, inflight := getLBStat("fast", false).snapshot()
+	if samples != 0 || inflight != 0 {
+		t.Errorf("expected stats to be reset, got samples=%d inflight=%d", samples, inflight)
+	}
+}
+
+func TestConsistentHashIndex(t *testing.T) {
+	ResetLBState()
+
+	candidates := []LBCandidate{
+		{ID: "id-a", Node: "a"},
+		{ID: "id-b", Node: "b"},
+		{ID: "id-c", Node: "c"},
+		{ID: "id-d", Node: "d"},
+	}
+
+	before := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := "user-" + strconv.Itoa(i)
+		node := candidates[GetLBIndexWithCandidates("consistent_hash", "m", key, candidates)].Node
+		if again := candidates[GetLBIndexWithCandidates("consistent_hash", "m", key, candidates)].Node; again != node {
+			t.Fatalf("key %s is not sticky: %s then %s", key, node, again)
+		}
+		before[key] = node
+	}
+
+	// 去掉一个节点后，只有原本落在该节点上的key会迁移
+	remaining := candidates[:3]
+	moved := 0
+	for key, node := range before {
+		after := remaining[GetLBIndexWithCandidates("consistent_hash", "m", key, remaining)].Node
+		if after != node {
+			if node != "d" {
+				t.Errorf("key %s moved from %s to %s although its node was not removed", key, node, after)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > 400 {
+		t.Errorf("unexpected number of moved keys: %d", moved)
+	}
+}
+
+func TestInitConfigLBSettings(t *testing.T) {
+	mylog.InitLog("prod")
+	defer func() {
+		GSOAConf = nil
+		ModelToService = nil
+		LBExplorationRate = DefaultLBExplorationRate
+		HashKeySource = DefaultHashKeySource
+	}()
+
+	// 首次加载与热加载一样应用lb_exploration_rate和hash_key
+	confFile := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(confFile, []byte(`{"load_balancing": "consistent_hash", "lb_exploration_rate": 0.2, "hash_key": "api_key"}`), 0644)
+	if err := InitConfig(confFile); err != nil {
+		t.Fatalf("InitConfig error: %v", err)
+	}
+	if LBExplorationRate != 0.2 || HashKeySource != HASH_KEY_API_KEY {
+		t.Fatalf("unexpected lb settings %v %s", LBExplorationRate, HashKeySource)
+	}
+
+	os.WriteFile(confFile, []byte(`{"load_balancing": "consistent_hash"}`), 0644)
+	if err := InitConfig(confFile); err != nil {
+		t.Fatalf("InitConfig error: %v", err)
+	}
+	if LBExplorationRate != DefaultLBExplorationRate || HashKeySource != DefaultHashKeySource {
+		t.Fatalf("expected default lb settings, got %v %s", LBExplorationRate, HashKeySource)
+	}
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"go.uber.org/zap"
+	"regexp"
+	"simple-one-api/pkg/mylog"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// MODEL_PATTERN_REGEX_PREFIX 以re:开头的模型名按正则表达式匹配，正则会自动加上^和$
+const MODEL_PATTERN_REGEX_PREFIX = "re:"
+
+// modelPattern 编译后的模型名模式，glob中的*和?会作为捕获组，可以在重定向的目标中用$1、$2引用
+type modelPattern struct {
+	raw     string
+	re      *regexp.Regexp
+	literal int // 模式中的字面字符数，越多越具体
+	isRegex bool
+}
+
+// modelPatternCache 编译后的模式，编译失败的模式缓存为nil
+var modelPatternCache sync.Map
+
+// IsModelPattern 判断模型名是否为glob或正则模式
+func IsModelPattern(name string) bool {
+	return strings.HasPrefix(name, MODEL_PATTERN_REGEX_PREFIX) || strings.ContainsAny(name, "*?")
+}
+
+func getModelPattern(name string) *modelPattern {
+	if v, ok := modelPatternCache.Load(name); ok {
+		return v.(*modelPattern)
+	}
+
+	p, err := compileModelPattern(name)
+	if err != nil {
+		mylog.Logger.Error("invalid model pattern", zap.String("pattern", name), zap.Error(err))
+		p = nil
+	}
+	modelPatternCache.Store(name, p)
+	return p
+}
+
+func compileModelPattern(name string) (*modelPattern, error) {
+	if expr, ok := strings.CutPrefix(name, MODEL_PATTERN_REGEX_PREFIX); ok {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, err
+		}
+		return &modelPattern{raw: name, re: re, literal: countRegexLiterals(expr), isRegex: true}, nil
+	}
+
+	var sb strings.Builder
+	literal := 0
+	sb.WriteString("^")
+	for _, r := range name {
+		switch r {
+		case '*':
+			sb.WriteString("(.*)")
+		case '?':
+			sb.WriteString("(.)")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+			literal++
+		}
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, err
+	}
+	return &modelPattern{raw: name, re: re, literal: literal}, nil
+}
+
+// countRegexLiterals 统计正则中的字面字符数：元字符不计；\d、\w、\p{L}等转义的字符类不计，\.等转义的符号算一个字面字符
+func countRegexLiterals(expr string) int {
+	literal := 0
+	runes := []rune(expr)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '\\' && i+1 < len(runes) {
+			i++
+			next := runes[i]
+			if (next == 'p' || next == 'P') && i+1 < len(runes) && runes[i+1] == '{' {
+				for i < len(runes) && runes[i] != '}' {
+					i++
+				}
+				continue
+			}
+			if !unicode.IsLetter(next) && !unicode.IsDigit(next) {
+				literal++
+			}
+			continue
+		}
+		if !strings.ContainsRune(`\.+*?()|[]{}^$`, r) {
+			literal++
+		}
+	}
+	return literal
+}
+
+// moreSpecific 多个模式同时匹配时的优先级：字面字符多的优先，相同时glob优先于正则，再按模式字符串排序
+func (p *modelPattern) moreSpecific(other *modelPattern) bool {
+	if p.literal != other.literal {
+		return p.literal > other.literal
+	}
+	if p.isRegex != other.isRegex {
+		return !p.isRegex
+	}
+	return p.raw < other.raw
+}
+
+// MatchModelName 判断模型名是否与name匹配，name可以是精确的模型名、glob或re:开头的正则
+func MatchModelName(name string, model string) bool {
+	if name == model {
+		return true
+	}
+	if !IsModelPattern(name) {
+		return false
+	}
+	p := getModelPattern(name)
+	return p != nil && p.re.MatchString(model)
+}
+
+// findBestPattern 在候选名称中找到与模型匹配的最具体的模式
+func findBestPattern(names []string, model string) (*modelPattern, []int) {
+	var best *modelPattern
+	var bestMatch []int
+	for _, name := range names {
+		if !IsModelPattern(name) {
+			continue
+		}
+		p := getModelPattern(name)
+		if p == nil {
+			continue
+		}
+		if match := p.re.FindStringSubmatchIndex(model); match != nil && (best == nil || p.moreSpecific(best)) {
+			best = p
+			bestMatch = match
+		}
+	}
+	return best, bestMatch
+}
+
+// MatchModelMap 在model_redirect、model_map等映射中查找模型：精确匹配优先，其次使用最具体的匹配模式，
+// 目标中的$1、${name}等会替换为模式中对应的捕获组
+func MatchModelMap(m map[string]string, model string) (string, bool) {
+	if target, exists := m[model]; exists {
+		return target, true
+	}
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	p, match := findBestPattern(names, model)
+	if p == nil {
+		return "", false
+	}
+	return string(p.re.ExpandString(nil, m[p.raw], model, match)), true
+}
+
+// GetModelServices 返回模型在namespace中对应的服务列表：精确匹配的服务在namespace中可用时优先，
+// 否则使用最具体的有可用服务的匹配模式(来自服务的model_redirect)；都不可用时仍返回精确匹配或最具体的模式，由调用方按原因报错
+func GetModelServices(model string, namespace string) ([]ModelDetails, bool) {
+	exact, found := ModelToService[model]
+	if found && HasNamespaceService(exact, namespace) {
+		return exact, true
+	}
+
+	var names, usable []string
+	for name, services := range ModelToService {
+		if IsModelPattern(name) {
+			names = append(names, name)
+			if HasNamespaceService(services, namespace) {
+				usable = append(usable, name)
+			}
+		}
+	}
+	if p, _ := findBestPattern(usable, model); p != nil {
+		return ModelToService[p.raw], true
+	}
+	if found {
+		return exact, true
+	}
+	if p, _ := findBestPattern(names, model); p != nil {
+		return ModelToService[p.raw], true
+	}
+	return nil, false
+}
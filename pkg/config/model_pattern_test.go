@@ -0,0 +1,90 @@
+package config
+
+import (
+	"simple-one-api/pkg/mylog"
+	"testing"
+)
+
+func TestMatchModelMap(t *testing.T) {
+	mylog.InitLog("prod")
+
+	redirects := map[string]string{
+		"gpt-4":                     "glm-4",
+		"qwen-*":                    "qwen-plus",
+		"qwen-max-*":                "qwen-max",
+		"@cf/meta/*":                "@cf/meta/$1",
+		"re:ERNIE-(\\d+\\.\\d+)-.*": "ERNIE-${1}",
+		"re:ERNIE-(?P<v>\\d+)-8K":   "ERNIE-Speed-$v",
+	}
+
+	cases := []struct {
+		model  string
+		target string
+		found  bool
+	}{
+		{"gpt-4", "glm-4", true},
+		{"gpt-4o", "", false},
+		{"qwen-turbo", "qwen-plus", true},
+		{"qwen-max-0428", "qwen-max", true},
+		{"@cf/meta/llama-3-8b-instruct", "@cf/meta/llama-3-8b-instruct", true},
+		{"ERNIE-4.0-8K", "ERNIE-4.0", true},
+		{"ERNIE-3-8K", "ERNIE-Speed-3", true},
+		{"xERNIE-4.0-8K", "", false},
+	}
+	for _, tc := range cases {
+		target, found := MatchModelMap(redirects, tc.model)
+		if target != tc.target || found != tc.found {
+			t.Fatalf("%s: expected %q %v, got %q %v", tc.model, tc.target, tc.found, target, found)
+		}
+	}
+
+	ModelToService = map[string][]ModelDetails{
+		"qwen-*":     {{ServiceName: "qwen"}},
+		"qwen-max-*": {{ServiceName: "qwen-max"}},
+	}
+	defer func() { ModelToService = nil }()
+	if services, found := GetModelServices("qwen-max-0428", ""); !found || services[0].ServiceName != "qwen-max" {
+		t.Fatalf("expected qwen-max services, got %v", services)
+	}
+
+	keyConfig := &APIKeyConfig{SupportedModels: map[string][]string{"cloudflare": {"@cf/*"}, "qianfan": {"re:ERNIE-[0-9.]+-8K"}}}
+	for model, expected := range map[string]bool{"@cf/qwen/qwen1.5-7b": true, "ERNIE-4.0-8K": true, "ERNIE-Speed-8K": false, "gpt-4o": false} {
+		if IsModelSupportedByKey(keyConfig, model) != expected {
+			t.Fatalf("%s: expected supported=%v", model, expected)
+		}
+	}
+}
+
+func TestModelPatternSpecificity(t *testing.T) {
+	// \d只是字符类，不算字面字符，不能比字面字符更多的glob更具体
+	redirects := map[string]string{
+		`re:glm-4-\d\d\d\d`: "regex",
+		"glm-4-0*":          "glob",
+	}
+	if target, _ := MatchModelMap(redirects, "glm-4-0520"); target != "glob" {
+		t.Fatalf("expected glob to be more specific, got %q", target)
+	}
+	if n := countRegexLiterals(`ERNIE-\d+\.\d+-\p{L}8K`); n != 10 {
+		t.Fatalf("expected 10 literals, got %d", n)
+	}
+}
+
+func TestGetModelServicesNamespaceFallback(t *testing.T) {
+	ModelToService = map[string][]ModelDetails{
+		"qwen-max": {{ServiceName: "other", ServiceModel: ServiceModel{Enabled: true, ProviderNamespace: "team-a"}}},
+		"qwen-*":   {{ServiceName: "qwen", ServiceModel: ServiceModel{Enabled: true}}},
+	}
+	defer func() { ModelToService = nil }()
+
+	// 精确匹配的服务不在调用方的namespace中时使用匹配的模式
+	if services, found := GetModelServices("qwen-max", ""); !found || services[0].ServiceName != "qwen" {
+		t.Fatalf("expected pattern services, got %v", services)
+	}
+	if services, found := GetModelServices("qwen-max", "team-a"); !found || services[0].ServiceName != "other" {
+		t.Fatalf("expected exact services, got %v", services)
+	}
+	// 都不可用时仍返回精确匹配的服务
+	if services, found := GetModelServices("qwen-max", "team-b"); !found || services[0].ServiceName != "other" {
+		t.Fatalf("expected exact services, got %v", services)
+	}
+}
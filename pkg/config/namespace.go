@@ -0,0 +1,94 @@
+package config
+
+import (
+	"go.uber.org/zap"
+	"simple-one-api/pkg/mylog"
+	"sort"
+)
+
+// NamespaceConf 命名空间配置，命名空间中的services等同于provider_namespace为命名空间名称的服务，
+// model_redirect、load_balancing和api_keys只对该命名空间生效，未配置时使用全局配置
+type NamespaceConf struct {
+	LoadBalancing string                    `json:"load_balancing" yaml:"load_balancing" mapstructure:"load_balancing"`
+	ModelRedirect map[string]string         `json:"model_redirect" yaml:"model_redirect" mapstructure:"model_redirect"`
+	Services      map[string][]ServiceModel `json:"services" yaml:"services"`
+	APIKeys       []APIKeyConfig            `json:"api_keys" yaml:"api_keys" mapstructure:"api_keys"`
+}
+
+// namespaceSupportModels 每个命名空间支持的模型名称列表，""为默认命名空间
+var namespaceSupportModels map[string]map[string]string
+
+// getNamespaceConf 返回命名空间的配置，默认命名空间或未在namespaces中声明时返回nil
+func getNamespaceConf(namespace string) *NamespaceConf {
+	if namespace == "" || GSOAConf == nil {
+		return nil
+	}
+	if ns, exists := GSOAConf.Namespaces[namespace]; exists {
+		return &ns
+	}
+	return nil
+}
+
+// GetLoadBalancingStrategy 返回命名空间的负载均衡策略，命名空间未配置时使用全局的load_balancing
+func GetLoadBalancingStrategy(namespace string) string {
+	if ns := getNamespaceConf(namespace); ns != nil && ns.LoadBalancing != "" {
+		return ns.LoadBalancing
+	}
+	return LoadBalancingStrategy
+}
+
+// findModelRedirect 在重定向配置中查找模型，"*"表示所有模型都重定向(优先于其他配置)，重定向到"*"表示random；
+// 其他的键可以是glob或正则模式，见MatchModelMap
+func findModelRedirect(redirects map[string]string, model string) (string, bool) {
+	if redirectModel, exists := redirects[KEYNAME_ALL]; exists {
+		if redirectModel == KEYNAME_ALL {
+			redirectModel = KEYNAME_RANDOM
+		}
+		return redirectModel, true
+	}
+	return MatchModelMap(redirects, model)
+}
+
+// GetNamespaceModelRedirect 优先使用命名空间的model_redirect，找不到时使用全局的model_redirect
+func GetNamespaceModelRedirect(namespace string, model string) string {
+	if ns := getNamespaceConf(namespace); ns != nil {
+		if redirectModel, exists := findModelRedirect(ns.ModelRedirect, model); exists {
+			mylog.Logger.Info("NamespaceModelRedirect model found", zap.String("namespace", namespace),
+				zap.String("model", model), zap.String("redirectModel", redirectModel))
+			return redirectModel
+		}
+	}
+	return GetGlobalModelRedirect(model)
+}
+
+// HasNamespaceService 判断服务列表中是否有命名空间可以使用的服务
+func HasNamespaceService(services []ModelDetails, namespace string) bool {
+	for _, sd := range services {
+		if sd.Enabled && sd.ProviderNamespace == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// GetNamespaceModels 返回命名空间可以调用的模型名称，包括命名空间model_redirect中目标模型可用的别名，按名称排序
+func GetNamespaceModels(namespace string) []string {
+	models := make(map[string]bool)
+	for model := range namespaceSupportModels[namespace] {
+		models[model] = true
+	}
+	if ns := getNamespaceConf(namespace); ns != nil {
+		for alias, target := range ns.ModelRedirect {
+			if !IsModelPattern(alias) && HasNamespaceService(ModelToService[target], namespace) {
+				models[alias] = true
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(models))
+	for model := range models {
+		keys = append(keys, model)
+	}
+	sort.Strings(keys)
+	return keys
+}
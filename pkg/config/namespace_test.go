@@ -0,0 +1,63 @@
+package config
+
+import (
+	"reflect"
+	"simple-one-api/pkg/mylog"
+	"testing"
+)
+
+func TestNamespaces(t *testing.T) {
+	mylog.InitLog("prod")
+
+	GSOAConf = &Configuration{
+		Services: map[string][]ServiceModel{
+			"openai": {{Enabled: true, Models: []string{"gpt-4o"}}},
+		},
+		APIKeys: []APIKeyConfig{{APIKey: "sk-default", SupportedModels: map[string][]string{"openai": {"*"}}}},
+		Namespaces: map[string]NamespaceConf{
+			"team-a": {
+				LoadBalancing: "round_robin",
+				ModelRedirect: map[string]string{"fast": "glm-4-flash"},
+				Services: map[string][]ServiceModel{
+					"zhipu": {{Enabled: true, Models: []string{"glm-4", "glm-4-flash"}}},
+				},
+				APIKeys: []APIKeyConfig{{APIKey: "sk-team-a", SupportedModels: map[string][]string{"zhipu": {"*"}}}},
+			},
+		},
+	}
+	LoadBalancingStrategy = "random"
+	ModelToService = createModelToServiceMap(*GSOAConf)
+	initAPIKeyMap()
+	defer func() { GSOAConf, ModelToService = nil, nil }()
+
+	if s, err := GetModelService("glm-4", "team-a"); err != nil || s.ServiceName != "zhipu" {
+		t.Fatalf("expected zhipu service in team-a, got %v, %v", s, err)
+	}
+	if _, err := GetModelService("glm-4", ""); err == nil {
+		t.Fatalf("team-a services should not be visible in the default namespace")
+	}
+	if _, err := GetModelService("gpt-4o", "team-a"); err == nil {
+		t.Fatalf("default services should not be visible in team-a")
+	}
+
+	if models := GetNamespaceModels("team-a"); !reflect.DeepEqual(models, []string{"fast", "glm-4", "glm-4-flash"}) {
+		t.Fatalf("unexpected team-a models: %v", models)
+	}
+	if models := GetNamespaceModels(""); !reflect.DeepEqual(models, []string{"gpt-4o"}) {
+		t.Fatalf("unexpected default models: %v", models)
+	}
+
+	if m := GetNamespaceModelRedirect("team-a", "fast"); m != "glm-4-flash" {
+		t.Fatalf("expected namespace redirect, got %s", m)
+	}
+	if m := GetNamespaceModelRedirect("", "fast"); m != "fast" {
+		t.Fatalf("namespace redirect should not apply to the default namespace, got %s", m)
+	}
+	if GetLoadBalancingStrategy("team-a") != "round_robin" || GetLoadBalancingStrategy("") != "random" {
+		t.Fatalf("unexpected load balancing strategy")
+	}
+
+	if keyConfig, exists := GetAPIKeyConfig("sk-team-a"); !exists || keyConfig.Namespace != "team-a" {
+		t.Fatalf("expected sk-team-a in team-a, got %v", keyConfig)
+	}
+}
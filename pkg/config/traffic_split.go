@@ -0,0 +1,78 @@
+package config
+
+import (
+	"go.uber.org/zap"
+	"math/rand"
+	"simple-one-api/pkg/mylog"
+)
+
+// trafficSplitBuckets 分流的桶数，百分比精确到0.01%
+const trafficSplitBuckets = 10000
+
+// TrafficSplitConf 将客户端模型按百分比分流到多个目标模型，百分比之和不足100时剩余流量仍使用原模型，
+// 超过100时按比例缩放；Sticky配置粘性key的来源(user、api_key、header:<请求头名称>)，为空时每次请求随机分流
+type TrafficSplitConf struct {
+	Targets []TrafficSplitTarget `json:"targets" yaml:"targets"`
+	Sticky  string               `json:"sticky" yaml:"sticky"`
+}
+
+type TrafficSplitTarget struct {
+	Model   string  `json:"model" yaml:"model"`
+	Percent float64 `json:"percent" yaml:"percent"`
+}
+
+var TrafficSplit map[string]TrafficSplitConf
+
+// GetTrafficSplitConf 返回模型的分流配置，优先按客户端传入的模型名查找，其次按全局重定向后的模型名查找
+func GetTrafficSplitConf(clientModel string, redirectModel string) (string, *TrafficSplitConf) {
+	if conf, exists := TrafficSplit[clientModel]; exists && len(conf.Targets) > 0 {
+		return clientModel, &conf
+	}
+	if conf, exists := TrafficSplit[redirectModel]; exists && len(conf.Targets) > 0 {
+		return redirectModel, &conf
+	}
+	return "", nil
+}
+
+// GetTrafficSplitModel 按分流配置选择目标模型，stickyKey不为空时同一个key总是落在同一个桶里
+func GetTrafficSplitModel(model string, conf *TrafficSplitConf, stickyKey string) string {
+	var bucket int
+	if stickyKey != "" {
+		bucket = int(hash32(model+"/"+stickyKey) % trafficSplitBuckets)
+	} else {
+		randLock.Lock()
+		bucket = rand.Intn(trafficSplitBuckets)
+		randLock.Unlock()
+	}
+
+	total := 0.0
+	for _, t := range conf.Targets {
+		if t.Percent > 0 {
+			total += t.Percent
+		}
+	}
+	scale := 1.0
+	if total > 100 {
+		scale = 100 / total
+	}
+
+	target := model
+	upper := 0.0
+	for _, t := range conf.Targets {
+		if t.Percent <= 0 {
+			continue
+		}
+		upper += t.Percent * scale * trafficSplitBuckets / 100
+		if float64(bucket) < upper {
+			target = t.Model
+			break
+		}
+	}
+
+	mylog.Logger.Info("Traffic split",
+		zap.String("model", model),
+		zap.String("target_model", target),
+		zap.Bool("sticky", stickyKey != ""),
+		zap.Int("bucket", bucket))
+	return target
+}
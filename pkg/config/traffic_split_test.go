@@ -0,0 +1,47 @@
+package config
+
+import (
+	"simple-one-api/pkg/mylog"
+	"strconv"
+	"testing"
+)
+
+func TestGetTrafficSplitModel(t *testing.T) {
+	mylog.InitLog("prod")
+
+	conf := &TrafficSplitConf{Targets: []TrafficSplitTarget{
+		{Model: "deepseek-chat", Percent: 90},
+		{Model: "deepseek-v3-new", Percent: 10},
+	}}
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[GetTrafficSplitModel("deepseek", conf, "user-"+strconv.Itoa(i))]++
+	}
+	if counts["deepseek-v3-new"] < 800 || counts["deepseek-v3-new"] > 1200 {
+		t.Fatalf("unexpected split: %v", counts)
+	}
+
+	// 粘性key总是落在同一个目标模型
+	first := GetTrafficSplitModel("deepseek", conf, "user-1")
+	for i := 0; i < 10; i++ {
+		if got := GetTrafficSplitModel("deepseek", conf, "user-1"); got != first {
+			t.Fatalf("sticky split changed from %s to %s", first, got)
+		}
+	}
+
+	// 百分比之和不足100时剩余流量使用原模型
+	partial := &TrafficSplitConf{Targets: []TrafficSplitTarget{
+		{Model: "deepseek-chat", Percent: 30},
+		{Model: "deepseek-v3-new", Percent: 30},
+	}}
+	counts = make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[GetTrafficSplitModel("deepseek", partial, "user-"+strconv.Itoa(i))]++
+	}
+	if counts["deepseek"] < 3600 || counts["deepseek"] > 4400 ||
+		counts["deepseek-chat"] < 2600 || counts["deepseek-chat"] > 3400 ||
+		counts["deepseek-v3-new"] < 2600 || counts["deepseek-v3-new"] > 3400 {
+		t.Fatalf("unexpected partial split: %v", counts)
+	}
+}
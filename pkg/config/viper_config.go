@@ -143,6 +143,21 @@ func onConfigChange() {
 	log.Println("Configuration reloaded successfully")
 }
 
+// applyLBSettings 应用负载均衡的探索比例和粘性key来源，首次加载和热加载时都需要调用
+func applyLBSettings(conf *Configuration) {
+	// 自适应负载均衡的探索比例，未配置时使用默认值，小于0表示关闭探索
+	LBExplorationRate = conf.LBExplorationRate
+	if LBExplorationRate == 0 {
+		LBExplorationRate = DefaultLBExplorationRate
+	}
+
+	// consistent_hash策略的粘性key来源
+	HashKeySource = conf.HashKey
+	if HashKeySource == "" {
+		HashKeySource = DefaultHashKeySource
+	}
+}
+
 // applyConfiguration 应用配置到全局变量
 func applyConfiguration(conf *Configuration) {
 	// 设置负载均衡策略，默认为 "random"
@@ -152,6 +167,8 @@ func applyConfiguration(conf *Configuration) {
 		LoadBalancingStrategy = conf.LoadBalancing
 	}
 
+	applyLBSettings(conf)
+
 	GSOAConf = conf
 	GProxyConf = &(conf.Proxy)
 
@@ -173,7 +190,11 @@ func applyConfiguration(conf *Configuration) {
 
 	// 创建映射
 	ModelToService = createModelToServiceMap(*conf)
+	ResetLBState()
+	resetCircuitBreakers()
 	GlobalModelRedirect = conf.ModelRedirect
+	ModelFallbacks = conf.ModelFallbacks
+	TrafficSplit = conf.TrafficSplit
 	GTranslation = &conf.Translation
 
 	// 更新多内容模型支持列表
@@ -188,6 +209,8 @@ func applyConfiguration(conf *Configuration) {
 	log.Println("ServerPort:", ServerPort)
 	log.Println("LogLevel:", LogLevel)
 	log.Println("GlobalModelRedirect:", GlobalModelRedirect)
+	log.Println("ModelFallbacks:", ModelFallbacks)
+	log.Println("TrafficSplit:", TrafficSplit)
 	log.Println("SupportMultiContentModels:", SupportMultiContentModels)
 
 	ShowSupportModels()
@@ -25,7 +25,17 @@ func EmbeddingsHandler(c *gin.Context) {
 
 	mylog.Logger.Info("EmbeddingsHandler", zap.Any("req", oaiEmbReq))
 
-	s, serviceModelName, err := getEmbeddingModelDetails(&oaiEmbReq)
+	// 与chat completions使用相同的鉴权、模型白名单、限流(包括tpm/tpd)和预算检查
+	apikey, _ := utils.GetAPIKeyFromHeader(c)
+	authResult, grant, ok := mycommon.AuthorizeRequest(c, apikey, oaiEmbReq.Model, estimateEmbeddingTokens(oaiEmbReq.Input))
+	if !ok {
+		return
+	}
+	// 调用失败时归还全部预留
+	defer grant.Done(nil)
+	namespace := authResult.Namespace
+
+	s, serviceModelName, err := getEmbeddingModelDetails(&oaiEmbReq, namespace)
 	if err != nil {
 		mylog.Logger.Error(err.Error())
 
@@ -47,11 +57,14 @@ func EmbeddingsHandler(c *gin.Context) {
 
 	creds, _ := mycommon.GetACredentials(s, oaiEmbReq.Model)
 
-	/// 配置限流器
-	limiter, timeout := setupLimiter(s, &s.EmbeddingLimit, oaiEmbReq.Model)
-	if limiter != nil {
-		handleRateLimiting(limiter, timeout)
+	// embedding_limit配置的所有限制同时生效
+	permit, err := acquireEmbeddingLimits(c, s, &oaiEmbReq)
+	if err != nil {
+		mycommon.SetRateLimitRetryAfter(c)
+		mycommon.SendOpenAIErrorResponse(c, http.StatusTooManyRequests, "Rate limit reached for embedding service, please try again later.", "requests", "rate_limit_exceeded")
+		return
 	}
+	defer permit.Release()
 
 	var proxyTransport *http.Transport
 	if config.IsProxyEnabled(s) {
@@ -69,7 +82,7 @@ func EmbeddingsHandler(c *gin.Context) {
 	apiKey, _ := utils.GetStringFromMap(creds, config.KEYNAME_API_KEY)
 	secretKey, _ := utils.GetStringFromMap(creds, config.KEYNAME_SECRET_KEY)
 
-	var oaiResp interface{}
+	var oaiResp *oai.EmbeddingResponse
 
 	switch s.ServiceName {
 	case "qianfan":
@@ -88,52 +101,78 @@ func EmbeddingsHandler(c *gin.Context) {
 		return
 	}
 
+	// 按实际用量结算预留的token，并与chat completions一样计入API key的预算
+	tokens := estimateEmbeddingTokens(oaiEmbReq.Input)
+	if oaiResp != nil && oaiResp.Usage.TotalTokens > 0 {
+		tokens = int64(oaiResp.Usage.TotalTokens)
+	}
+	permit.Settle(tokens)
+	usage := &mycommon.ChatUsage{}
+	usage.AddTokens(tokens, 0)
+	grant.Done(usage)
+
 	c.JSON(http.StatusOK, oaiResp)
 
 	return
 
 }
 
-func setupLimiter(s *config.ModelDetails, l *config.Limit, model string) (*mylimiter.Limiter, int) {
-
-	lt, ln, timeout := mycommon.GetServiceLimiterDetailsLimit(l)
-
-	if lt != "" && ln > 0 {
-		limiterID := s.ServiceID + "_" + model
-
-		return mylimiter.GetLimiter(limiterID, lt, ln), timeout
+// acquireEmbeddingLimits 在一个等待过程中通过embedding_limit配置的所有限制，tpm/tpd按输入估算的token数预留；
+// 在timeout内等不到时返回错误，成功时返回的许可必须在调用结束后Release
+func acquireEmbeddingLimits(c *gin.Context, s *config.ModelDetails, oaiEmbReq *oai.EmbeddingRequest) (*mylimiter.Permit, error) {
+	limiterID := config.GetLimiterKey(s, s.ServiceID) + "_" + oaiEmbReq.Model
+	limiter := mylimiter.GetLimiter(limiterID, mycommon.GetLimits(s.EmbeddingLimit))
+	if limiter == nil {
+		return nil, nil
 	}
-	return nil, timeout
-}
 
-func handleRateLimiting(limiter *mylimiter.Limiter, timeout int) {
+	timeout := s.EmbeddingLimit.Timeout
 	if timeout <= 0 {
 		timeout = 30 // 默认超时时间
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(timeout)*time.Second)
 	defer cancel()
 
 	startWaitTime := time.Now()
-	if err := limiter.Wait(ctx); err != nil {
+	permit, err := mylimiter.Acquire(ctx, estimateEmbeddingTokens(oaiEmbReq.Input), limiter)
+	mycommon.SetRateLimitHeaders(c, limiter)
+	if err != nil {
 		elapsed := time.Since(startWaitTime)
 		switch {
 		case errors.Is(err, context.DeadlineExceeded):
-			mylog.Logger.Error("Failed to obtain token within specified time", zap.Error(err), zap.Int("timeout", timeout), zap.Duration("elapsed", elapsed))
+			mylog.Logger.Error("Failed to pass rate limits within specified time", zap.Error(err), zap.Int("timeout", timeout), zap.Duration("elapsed", elapsed))
 		case errors.Is(err, context.Canceled):
 			mylog.Logger.Error("Operation canceled", zap.Error(err), zap.Duration("elapsed", elapsed))
 		default:
-			mylog.Logger.Error("Unknown error occurred while waiting for token", zap.Error(err), zap.Duration("elapsed", elapsed))
+			mylog.Logger.Error("Unknown error occurred while waiting for rate limits", zap.Error(err), zap.Duration("elapsed", elapsed))
 		}
-		return
+		return nil, err
 	}
 
 	mylog.Logger.Info("Rate limiting wait duration",
 		zap.Duration("waited_for", time.Since(startWaitTime)))
+	return permit, nil
+}
+
+// estimateEmbeddingTokens 估算embedding输入的token数，input为字符串或字符串数组
+func estimateEmbeddingTokens(input any) int64 {
+	switch v := input.(type) {
+	case string:
+		return int64(mycommon.EstimateTextTokens(v))
+	case []interface{}:
+		var tokens int64
+		for _, item := range v {
+			tokens += estimateEmbeddingTokens(item)
+		}
+		return tokens
+	default:
+		return 0
+	}
 }
 
-func getEmbeddingModelDetails(oaiEmbReq *oai.EmbeddingRequest) (*config.ModelDetails, string, error) {
+func getEmbeddingModelDetails(oaiEmbReq *oai.EmbeddingRequest, namespace string) (*config.ModelDetails, string, error) {
 
-	s, err := config.GetModelService(oaiEmbReq.Model, "")
+	s, err := config.GetModelService(oaiEmbReq.Model, namespace)
 	if err != nil {
 		return nil, "", err
 	}
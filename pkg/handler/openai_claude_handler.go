@@ -78,6 +78,8 @@ func sendClaudeRequest(c *gin.Context, client *http.Client, apiKey, url string,
 		return err
 	}
 	defer resp.Body.Close()
+	// 记录响应header，自适应并发根据Retry-After暂停
+	utils.RecordUpstreamHeader(c.Request.Context(), resp)
 
 	err = mycommon.CheckStatusCode(resp)
 	if err != nil {
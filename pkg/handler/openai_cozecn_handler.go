@@ -156,6 +156,8 @@ func sendRequest(c *gin.Context, client *http.Client, token, url string, request
 		return err
 	}
 	defer resp.Body.Close()
+	// 记录响应header，自适应并发根据Retry-After暂停
+	utils.RecordUpstreamHeader(c.Request.Context(), resp)
 
 	err = mycommon.CheckStatusCode(resp)
 	if err != nil {
@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+	"net/http"
+	"net/http/httptest"
+	"simple-one-api/pkg/mycomdef"
+	"strings"
+	"testing"
+)
+
+// replyStreamThenDrop 返回一个流式数据块后断开连接，模拟输出过程中上游故障
+func replyStreamThenDrop(content string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"id":"chatcmpl-test","object":"chat.completion.chunk","model":"upstream-model","choices":[{"index":0,"delta":{"role":"assistant","content":"` + content + `"}}]}` + "\n\n"))
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}
+}
+
+// failoverTestConfig 一个服务三个凭证，按first策略依次使用a、b、c
+func failoverTestConfig(serverURL string, maxRetries string) string {
+	return `{
+  "load_balancing": "first",
+  "services": {
+    "openai": [{
+      "models": ["gpt-test"],
+      "enabled": true,
+      "server_url": "` + serverURL + `/v1",
+      "credential_list": [{"api_key": "a"}, {"api_key": "b"}, {"api_key": "c"}],
+      "retry": {"max_retries": ` + maxRetries + `}
+    }]
+  }
+}`
+}
+
+func TestFailoverNextCredential(t *testing.T) {
+	u := newFakeUpstream(t, map[string]http.HandlerFunc{
+		"a": replyStatus(http.StatusBadGateway, 0),
+		"b": replyContent("from b"),
+		"c": replyContent("from c"),
+	})
+	loadTestConfig(t, failoverTestConfig(u.URL, "2"))
+
+	rec := doChatRequest(t, "gpt-test")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "from b") {
+		t.Fatalf("expected response from b, got %d %s", rec.Code, rec.Body.String())
+	}
+	if u.Calls("a") != 1 || u.Calls("b") != 1 || u.Calls("c") != 0 {
+		t.Fatalf("unexpected calls a=%d b=%d c=%d", u.Calls("a"), u.Calls("b"), u.Calls("c"))
+	}
+}
+
+func TestFailoverMaxRetries(t *testing.T) {
+	u := newFakeUpstream(t, map[string]http.HandlerFunc{
+		"a": replyStatus(http.StatusBadGateway, 0),
+		"b": replyStatus(http.StatusBadGateway, 0),
+		"c": replyContent("from c"),
+	})
+	loadTestConfig(t, failoverTestConfig(u.URL, "1"))
+
+	// 首次调用加一次重试，不会用到c
+	rec := doChatRequest(t, "gpt-test")
+	if rec.Code == http.StatusOK {
+		t.Fatalf("expected error after retries exhausted, got %s", rec.Body.String())
+	}
+	if u.Calls("a") != 1 || u.Calls("b") != 1 || u.Calls("c") != 0 {
+		t.Fatalf("unexpected calls a=%d b=%d c=%d", u.Calls("a"), u.Calls("b"), u.Calls("c"))
+	}
+}
+
+func TestFailoverNotRetryable(t *testing.T) {
+	u := newFakeUpstream(t, map[string]http.HandlerFunc{
+		"a": replyStatus(http.StatusBadRequest, 0),
+		"b": replyContent("from b"),
+	})
+	loadTestConfig(t, failoverTestConfig(u.URL, "2"))
+
+	// 默认retry_on不包括400
+	rec := doChatRequest(t, "gpt-test")
+	if rec.Code == http.StatusOK || u.Calls("b") != 0 {
+		t.Fatalf("expected no failover for 400, got %d, b=%d", rec.Code, u.Calls("b"))
+	}
+}
+
+func TestFailoverStopsAfterWrite(t *testing.T) {
+	u := newFakeUpstream(t, map[string]http.HandlerFunc{
+		"a": replyStreamThenDrop("partial"),
+		"b": replyContent("from b"),
+	})
+	loadTestConfig(t, failoverTestConfig(u.URL, "2"))
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	HandleOpenAIRequest(c, &openai.ChatCompletionRequest{
+		Model:    "gpt-test",
+		Stream:   true,
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hello"}},
+	}, "")
+
+	// 连接错误本身可以重试，但已经向客户端写出数据，不能再切换到b
+	if !strings.Contains(rec.Body.String(), "partial") {
+		t.Fatalf("expected partial stream, got %s", rec.Body.String())
+	}
+	if n := u.Calls("b"); n != 0 {
+		t.Fatalf("expected no failover after write, got %d calls to b", n)
+	}
+}
+
+// fallbackTestConfig gpt-test依次降级到fb-1、fb-2，fb-2通过model_redirect重定向到fb-2-real
+func fallbackTestConfig(serverURL string) string {
+	service := func(model string, key string) string {
+		return `{"models": ["` + model + `"], "enabled": true, "server_url": "` + serverURL + `/v1", "credentials": {"api_key": "` + key + `"}}`
+	}
+	return `{
+  "load_balancing": "first",
+  "model_redirect": {"fb-2": "fb-2-real"},
+  "model_fallbacks": {"gpt-test": ["fb-1", "fb-2"]},
+  "services": {
+    "openai": [` + service("gpt-test", "primary") + `, ` + service("fb-1", "fb1") + `, ` + service("fb-2-real", "fb2") + `]
+  }
+}`
+}
+
+func TestFallbackOrder(t *testing.T) {
+	u := newFakeUpstream(t, map[string]http.HandlerFunc{
+		"primary": replyStatus(http.StatusBadGateway, 0),
+		"fb1":     replyStatus(http.StatusBadGateway, 0),
+		"fb2":     replyContent("from fb2"),
+	})
+	loadTestConfig(t, fallbackTestConfig(u.URL))
+
+	// 依次尝试gpt-test、fb-1，最后由重定向后的fb-2-real响应
+	rec := doChatRequest(t, "gpt-test")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "from fb2") {
+		t.Fatalf("expected response from fb2, got %d %s", rec.Code, rec.Body.String())
+	}
+	if u.Calls("primary") != 1 || u.Calls("fb1") != 1 || u.Calls("fb2") != 1 {
+		t.Fatalf("unexpected calls primary=%d fb1=%d fb2=%d", u.Calls("primary"), u.Calls("fb1"), u.Calls("fb2"))
+	}
+	if got := rec.Header().Get(mycomdef.HEADER_SERVED_MODEL); got != "fb-2" {
+		t.Fatalf("expected served model fb-2, got %q", got)
+	}
+}
+
+func TestFallbackNotNeeded(t *testing.T) {
+	u := newFakeUpstream(t, map[string]http.HandlerFunc{
+		"primary": replyContent("from primary"),
+		"fb1":     replyContent("from fb1"),
+		"fb2":     replyContent("from fb2"),
+	})
+	loadTestConfig(t, fallbackTestConfig(u.URL))
+
+	rec := doChatRequest(t, "gpt-test")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "from primary") {
+		t.Fatalf("expected response from primary, got %d %s", rec.Code, rec.Body.String())
+	}
+	if u.Calls("fb1") != 0 || u.Calls("fb2") != 0 {
+		t.Fatalf("expected no fallback, got fb1=%d fb2=%d", u.Calls("fb1"), u.Calls("fb2"))
+	}
+	if got := rec.Header().Get(mycomdef.HEADER_SERVED_MODEL); got != "gpt-test" {
+		t.Fatalf("expected served model gpt-test, got %q", got)
+	}
+}
+
+func TestTrafficSplitServedModel(t *testing.T) {
+	u := newFakeUpstream(t, map[string]http.HandlerFunc{
+		"primary": replyContent("from primary"),
+		"new":     replyContent("from new"),
+	})
+	loadTestConfig(t, `{
+  "traffic_split": {"gpt-test": {"targets": [{"model": "gpt-new", "percent": 100}]}},
+  "services": {
+    "openai": [
+      {"models": ["gpt-test"], "enabled": true, "server_url": "`+u.URL+`/v1", "credentials": {"api_key": "primary"}},
+      {"models": ["gpt-new"], "enabled": true, "server_url": "`+u.URL+`/v1", "credentials": {"api_key": "new"}}
+    ]
+  }
+}`)
+
+	// 分流到的目标模型实际响应请求
+	rec := doChatRequest(t, "gpt-test")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "from new") {
+		t.Fatalf("expected response from gpt-new, got %d %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get(mycomdef.HEADER_SERVED_MODEL); got != "gpt-new" {
+		t.Fatalf("expected served model gpt-new, got %q", got)
+	}
+	if got := rec.Header().Get(mycomdef.HEADER_TRAFFIC_SPLIT); got != "gpt-new" {
+		t.Fatalf("expected traffic split gpt-new, got %q", got)
+	}
+}
+
+func TestTrafficSplitWithRedirect(t *testing.T) {
+	u := newFakeUpstream(t, map[string]http.HandlerFunc{
+		"old": replyContent("from old"),
+		"new": replyContent("from new"),
+	})
+	loadTestConfig(t, `{
+  "model_redirect": {"gpt-test": "gpt-test-real", "gpt-new": "gpt-new-real"},
+  "traffic_split": {"gpt-test": {"targets": [{"model": "gpt-new", "percent": 50}]}},
+  "services": {
+    "openai": [
+      {"models": ["gpt-test-real"], "enabled": true, "server_url": "`+u.URL+`/v1", "credentials": {"api_key": "old"}},
+      {"models": ["gpt-new-real"], "enabled": true, "server_url": "`+u.URL+`/v1", "credentials": {"api_key": "new"}}
+    ]
+  }
+}`)
+
+	// 分到的目标模型和剩余流量都按model_redirect重定向
+	for i := 0; i < 40; i++ {
+		rec := doChatRequest(t, "gpt-test")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected success, got %d %s", rec.Code, rec.Body.String())
+		}
+		served := rec.Header().Get(mycomdef.HEADER_SERVED_MODEL)
+		if (served == "gpt-new") != strings.Contains(rec.Body.String(), "from new") {
+			t.Fatalf("served model %q does not match response %s", served, rec.Body.String())
+		}
+	}
+	if u.Calls("old") == 0 || u.Calls("new") == 0 {
+		t.Fatalf("expected both arms served, got old=%d new=%d", u.Calls("old"), u.Calls("new"))
+	}
+}
@@ -86,6 +86,8 @@ func OpenAI2GeminiHandler(c *gin.Context, oaiReqParam *OAIRequestParam) error {
 		return err
 	}
 	defer resp.Body.Close()
+	// 记录响应header，自适应并发根据Retry-After暂停
+	utils.RecordUpstreamHeader(c.Request.Context(), resp)
 
 	err = mycommon.CheckStatusCode(resp)
 	if err != nil {
@@ -3,20 +3,19 @@ package handler
 import (
 	"bytes"
 	"context"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/goccy/go-json"
 	"github.com/sashabaranov/go-openai"
 	"go.uber.org/zap"
 	"io"
 	"net/http"
 	"simple-one-api/pkg/adapter"
 	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mycomdef"
 	"simple-one-api/pkg/mycommon"
-	"simple-one-api/pkg/mylimiter"
 	"simple-one-api/pkg/mylog"
+	"simple-one-api/pkg/myquota"
 	"simple-one-api/pkg/utils"
 	"strings"
 	"time"
@@ -24,6 +23,9 @@ import (
 
 var defaultReqTimeout = 120
 
+// errLocalRateLimit 表示在本地限流器等待超时，和上游429一样可以切换服务重试
+var errLocalRateLimit = errors.New("Request rate limit exceeded")
+
 // 定义 ReasoningMode 枚举类型
 type ReasoningMode int
 
@@ -117,14 +119,6 @@ func OpenAIHandler(c *gin.Context) {
 
 	mylog.Logger.Info("OpenAIHandler", zap.String("apikey", apikey))
 
-	isValid := validateAPIKey(apikey)
-	if !isValid {
-		err = errors.New("key is not valid")
-		mylog.Logger.Error("key is not valid", zap.String("apikey", apikey))
-		sendErrorResponse(c, http.StatusUnauthorized, err.Error())
-		return
-	}
-
 	bodyData, getBodyerr := getBodyDataCopy(c)
 
 	var oaiReq openai.ChatCompletionRequest
@@ -153,52 +147,35 @@ func OpenAIHandler(c *gin.Context) {
 	mylog.Logger.Info("logOpenAIChatCompletionRequest", zap.Float32("TopP", oaiReq.TopP))
 	logOpenAIChatCompletionRequest(&oaiReq)
 
-	isValid, _ = config.ValidateAPIKeyAndModel(apikey, oaiReq.Model)
-	if !isValid {
-		err = errors.New("key not valid")
-		mylog.Logger.Error(err.Error())
-		sendErrorResponse(c, http.StatusUnauthorized, err.Error())
+	// 鉴权、模型白名单、API key限流、按估算的token数预留tpm/tpd和token预算
+	authResult, grant, ok := mycommon.AuthorizeRequest(c, apikey, oaiReq.Model, int64(mycommon.EstimateChatCompletionTokens(&oaiReq)))
+	if !ok {
 		return
 	}
+	// 没有统计实际用量时(包括panic)归还全部预留
+	defer grant.Done(nil)
+	namespace := authResult.Namespace
 
-	namespace, errStr := checkTokenModel(apikey, oaiReq.Model)
+	mycommon.LogChatCompletionRequest(oaiReq)
 
-	if namespace == "" {
-		sendErrorResponse(c, http.StatusUnauthorized, errStr)
+	if !myquota.HasQuota(authResult.KeyConfig) && !grant.HasTokenLimit() {
+		HandleOpenAIRequest(c, &oaiReq, namespace)
 		return
 	}
 
-	mycommon.LogChatCompletionRequest(oaiReq)
-
+	// 统计token用量，HandleOpenAIRequest会修改请求中的模型名，这里保留一份原始请求
+	clientReq := mycommon.DeepCopyChatCompletionRequest(oaiReq)
+	uw := newUsageResponseWriter(c.Writer, oaiReq.Stream)
+	c.Writer = uw
 	HandleOpenAIRequest(c, &oaiReq, namespace)
+	c.Writer = uw.ResponseWriter
 
-	return
-}
-
-func checkTokenModel(apiKey, model string) (string, string) {
-	resp, err := http.Get(fmt.Sprintf("http://localhost:8808/%s/%s", apiKey, hex.EncodeToString([]byte(model))))
-	if err != nil {
-		mylog.Logger.Error("CheckTokenModel error: " + err.Error())
-		return "", "check token error"
-	}
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		mylog.Logger.Error("CheckTokenModel error: " + err.Error())
-		return "", "check token error"
-	}
-	var m map[string]interface{}
-	err = json.Unmarshal(data, &m)
-	if err != nil {
-		mylog.Logger.Error("CheckTokenModel error: " + err.Error() + ",data=" + string(data))
-		return "", "check token error"
-	}
-	ns, ok := m["namespace"].(string)
-	if !ok {
-		mylog.Logger.Error("CheckTokenModel get no namespace: " + string(data))
-		msg := m["msg"].(string)
-		return "", msg
+	if c.Writer.Status() >= http.StatusBadRequest {
+		return
 	}
-	return ns, ""
+	usage := &mycommon.ChatUsage{}
+	usage.AddTokens(uw.Usage(&clientReq))
+	grant.Done(usage)
 }
 
 func HandleOpenAIRequest(c *gin.Context, oaiReq *openai.ChatCompletionRequest, namespace string) {
@@ -206,16 +183,215 @@ func HandleOpenAIRequest(c *gin.Context, oaiReq *openai.ChatCompletionRequest, n
 	clientModel := oaiReq.Model
 
 	//全局模型重定向名称
-	gRedirectModel := config.GetGlobalModelRedirect(clientModel)
+	gRedirectModel := config.GetNamespaceModelRedirect(namespace, clientModel)
 
 	oaiReq.Model = gRedirectModel
 
-	s, serviceModelName, err := getModelDetails(oaiReq, namespace)
-	if err != nil {
-		mylog.Logger.Error(err.Error())
-		sendErrorResponse(c, http.StatusBadRequest, err.Error())
+	hashKey := getHashKey(c, oaiReq)
+
+	// 按traffic_split配置将流量分到不同版本的模型
+	targetModel, targetServedModel := gRedirectModel, clientModel
+	if splitModel, splitConf := config.GetTrafficSplitConf(clientModel, gRedirectModel); splitConf != nil {
+		splitTarget := config.GetTrafficSplitModel(splitModel, splitConf, getStickyKey(c, oaiReq, splitConf.Sticky))
+		c.Header(mycomdef.HEADER_TRAFFIC_SPLIT, splitTarget)
+		// 分到目标模型时由目标模型响应请求，目标模型与降级模型一样按namespace和全局的model_redirect重定向；
+		// 剩余流量仍使用原模型重定向后的结果
+		if splitTarget != splitModel {
+			targetServedModel = splitTarget
+			targetModel = config.GetNamespaceModelRedirect(namespace, splitTarget)
+		}
+	}
+
+	// 依次尝试请求的模型以及model_fallbacks中配置的降级模型
+	models := append([]string{targetModel}, config.GetModelFallbacks(clientModel, gRedirectModel)...)
+
+	var routeErr, upstreamErr error
+	for i, model := range models {
+		req := mycommon.DeepCopyChatCompletionRequest(*oaiReq)
+		req.Model = model
+
+		servedModel, redirectModel := targetServedModel, targetModel
+		if i > 0 {
+			// 降级模型与请求的模型一样按namespace和全局的model_redirect重定向
+			servedModel = model
+			redirectModel = config.GetNamespaceModelRedirect(namespace, model)
+			req.Model = redirectModel
+			mylog.Logger.Warn("Fallback to next model",
+				zap.String("client_model", clientModel),
+				zap.String("fallback_model", model),
+				zap.String("redirect_model", redirectModel),
+				zap.Int("fallback_index", i))
+		}
+
+		err := handleModelWithFailover(c, &req, namespace, hashKey, servedModel, redirectModel)
+		if err == nil {
+			return
+		}
+
+		// 已经向客户端写出数据，不能再降级
+		if c.Writer.Written() {
+			sendUpstreamErrorResponse(c, err)
+			return
+		}
+
+		var rErr *routingError
+		if errors.As(err, &rErr) {
+			if routeErr == nil {
+				routeErr = err
+			}
+		} else {
+			upstreamErr = err
+		}
+	}
+
+	// 优先返回上游调用的错误，其次是第一个路由错误
+	if upstreamErr != nil {
+		sendUpstreamErrorResponse(c, upstreamErr)
 		return
 	}
+	sendUpstreamErrorResponse(c, routeErr)
+}
+
+// handleModelWithFailover 在模型的可用服务和凭证之间调用，失败时按retry配置切换到下一个服务/凭证重试，
+// 成功时返回nil，失败时返回错误，由调用方决定降级或返回错误
+func handleModelWithFailover(c *gin.Context, oaiReq *openai.ChatCompletionRequest, namespace string, hashKey string,
+	clientModel string, gRedirectModel string) error {
+
+	// 记录已经尝试过的服务/凭证，失败后切换到下一个
+	excluded := make(map[string]bool)
+	routeOpts := &config.RouteOptions{
+		Excluded: excluded,
+		HashKey:  hashKey,
+		Features: mycommon.GetRequestFeatures(oaiReq),
+	}
+	var lastErr, circuitErr error
+
+	for attempt := 1; ; attempt++ {
+		req := mycommon.DeepCopyChatCompletionRequest(*oaiReq)
+
+		s, serviceModelName, err := getModelDetailsWithOptions(&req, namespace, routeOpts)
+		if err != nil {
+			mylog.Logger.Error(err.Error())
+			if lastErr != nil {
+				// 所有可用的服务和凭证都已经尝试过
+				return lastErr
+			}
+			if circuitErr != nil {
+				return &routingError{err: circuitErr}
+			}
+			return &routingError{err: err}
+		}
+
+		creds, credsID := mycommon.GetACredentialsWithOptions(s, req.Model, routeOpts)
+		excluded[config.GetExcludeKey(s, credsID)] = true
+
+		// 半开状态的熔断器已经有探测请求在途，换下一个服务/凭证，不计入重试次数
+		if !config.AcquireCircuit(s, credsID) {
+			circuitErr = fmt.Errorf("half-open probe of service %s in flight: %w", s.ServiceName, config.ErrCircuitOpen)
+			attempt--
+			continue
+		}
+
+		mylog.Logger.Info("Upstream attempt",
+			zap.Int("attempt", attempt),
+			zap.String("service_name", s.ServiceName),
+			zap.String("service_id", s.ServiceID),
+			zap.String("creds_id", credsID),
+			zap.String("client_model", clientModel))
+
+		headerSnapshot := c.Writer.Header().Clone()
+		c.Header(mycomdef.HEADER_SERVED_MODEL, clientModel)
+
+		if s.Hedge.DelayMs > 0 {
+			err = handleHedgedAttempt(c, &req, s, serviceModelName, creds, credsID, clientModel, gRedirectModel, namespace, routeOpts)
+		} else {
+			err = runUpstreamAttempt(c, &req, s, serviceModelName, creds, credsID, clientModel, gRedirectModel)
+		}
+
+		if err == nil {
+			if req.Stream {
+				utils.SendOpenAIStreamEOFData(c)
+			}
+			return nil
+		}
+
+		lastErr = err
+		retryable := errors.Is(err, errLocalRateLimit) || mycommon.IsRetryableError(err, config.GetRetryOn(s))
+		written := c.Writer.Written()
+
+		mylog.Logger.Warn("Upstream attempt failed",
+			zap.Int("attempt", attempt),
+			zap.String("service_name", s.ServiceName),
+			zap.String("service_id", s.ServiceID),
+			zap.String("creds_id", credsID),
+			zap.Bool("retryable", retryable),
+			zap.Bool("written", written),
+			zap.Int("max_retries", s.Retry.MaxRetries),
+			zap.Error(err))
+
+		// 已经向客户端写出数据，或者错误不可重试，或者重试次数用尽，直接返回错误
+		if written || !retryable || attempt > s.Retry.MaxRetries {
+			return err
+		}
+
+		restoreResponseHeader(c, headerSnapshot)
+	}
+}
+
+// runUpstreamAttempt 调用一次选定的服务和凭证，并记录负载均衡统计和熔断结果，调用前需通过config.AcquireCircuit
+func runUpstreamAttempt(c *gin.Context, oaiReq *openai.ChatCompletionRequest, s *config.ModelDetails, serviceModelName string,
+	creds map[string]interface{}, credsID string, clientModel string, gRedirectModel string) error {
+
+	// 统计服务和凭证的在途请求数以及首token耗时，供自适应负载均衡使用
+	statIDs := []string{s.ServiceID}
+	if credsID != "" {
+		statIDs = append(statIDs, credsID)
+	}
+	config.LBRequestStart(statIDs...)
+	tw := newTimingResponseWriter(c.Writer)
+	c.Writer = tw
+	// 记录上游响应的header，自适应并发根据Retry-After等header暂停
+	req := c.Request
+	ctx, upstreamHeader := utils.WithUpstreamHeader(req.Context())
+	c.Request = req.WithContext(ctx)
+	startTime := time.Now()
+
+	err := handleOpenAIRequestWithService(c, oaiReq, s, serviceModelName, creds, credsID, clientModel, gRedirectModel)
+
+	c.Writer = tw.ResponseWriter
+	c.Request = req
+
+	// 对冲落败或客户端断开时请求被取消，不代表服务的延迟和故障，只减少在途请求数
+	if isCanceledAttempt(c, err) {
+		config.LBRequestAbort(statIDs...)
+		config.ReleaseCircuit(s, credsID)
+		return err
+	}
+
+	config.LBRequestDone(tw.TimeToFirstWrite(startTime), time.Since(startTime), err == nil, statIDs...)
+	recordAdaptiveResult(s, credsID, err, upstreamHeader)
+
+	// 本地限流不代表上游故障，不计入熔断统计
+	if errors.Is(err, errLocalRateLimit) {
+		config.ReleaseCircuit(s, credsID)
+	} else {
+		config.RecordCircuitResult(s, credsID, mycommon.MatchUpstreamError(err, config.GetCircuitBreakerFailOn(s)))
+	}
+
+	return err
+}
+
+// isCanceledAttempt 判断调用是否因为对冲落败或者请求的上下文被取消而失败
+func isCanceledAttempt(c *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, context.Canceled) || errors.Is(err, errHedgeLost) || errors.Is(c.Request.Context().Err(), context.Canceled)
+}
+
+// handleOpenAIRequestWithService 使用选定的服务和凭证完成一次上游调用
+func handleOpenAIRequestWithService(c *gin.Context, oaiReq *openai.ChatCompletionRequest, s *config.ModelDetails, serviceModelName string,
+	creds map[string]interface{}, credsID string, clientModel string, gRedirectModel string) error {
 
 	//模型重定向名称
 	mrModel := config.GetModelRedirect(s, serviceModelName)
@@ -232,30 +408,22 @@ func HandleOpenAIRequest(c *gin.Context, oaiReq *openai.ChatCompletionRequest, n
 		zap.String("map_model", mpModel),
 		zap.String("last_model", oaiReq.Model))
 
+	// 含图片的请求只会路由到支持vision的服务，这里只需要把纯文本的多段内容转换为普通消息
 	if mycommon.IsMultiContentMessage(oaiReq.Messages) {
-		isSupportMC := config.IsSupportMultiContent(oaiReq.Model)
-		if !isSupportMC {
-			mylog.Logger.Warn("model support vision", zap.Bool("isSupportMC", isSupportMC))
+		if !config.SupportsVision(s, oaiReq.Model) {
+			mylog.Logger.Warn("model does not support vision, converting multi content to text", zap.String("model", oaiReq.Model))
 			//convert message
 			adapter.OpenAIMultiContentRequestToOpenAIContentRequest(oaiReq)
-			mylog.Logger.Info("", zap.Any("oaiReq", oaiReq))
-		} else {
-
+			mylog.Logger.Debug("converted multi content request", zap.Any("oaiReq", oaiReq))
 		}
 	}
 
-	creds, credsID := mycommon.GetACredentials(s, oaiReq.Model)
-
-	var limiter *mylimiter.Limiter
-	lt, ln, timeout := mycommon.GetServiceModelDetailsLimit(s)
-	if lt != "" && ln > 0 {
-		limiter = mylimiter.GetLimiter(s.ServiceID, lt, ln)
-	} else {
-		lt, ln, timeout = mycommon.GetCredentialLimit(creds)
-		if lt != "" && ln > 0 {
-			limiter = mylimiter.GetLimiter(credsID, lt, ln)
-		}
+	// 服务和凭证配置的所有限制同时生效，在timeout内等不到时切换到下一个服务/凭证
+	permit, err := acquireServiceLimits(c, oaiReq, s, creds, credsID)
+	if err != nil {
+		return err
 	}
+	defer permit.Release()
 
 	oaiReqParam := &OAIRequestParam{
 		chatCompletionReq: oaiReq,
@@ -264,64 +432,6 @@ func HandleOpenAIRequest(c *gin.Context, oaiReq *openai.ChatCompletionRequest, n
 		ClientModel:       clientModel,
 	}
 
-	if limiter != nil {
-		if timeout <= 0 {
-			timeout = defaultReqTimeout
-		}
-		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
-		defer cancel()
-
-		startWaitTime := time.Now()
-
-		mylog.Logger.Info("Rate limits and timeout configuration",
-			zap.String("limit type:", lt),
-			zap.Float64("limit num:", ln),
-			zap.Int("timeout", timeout))
-
-		if lt == "qps" || lt == "qpm" {
-			err = limiter.Wait(ctx)
-			elapsed := time.Since(startWaitTime)
-
-			if err != nil {
-				if errors.Is(err, context.DeadlineExceeded) {
-					// Log a message if the request could not obtain a token within the specified timeout period.
-					// 假设 logger 是一个已经配置好的 zap.Logger 实例
-					mylog.Logger.Error("Failed to obtain token within the specified time",
-						zap.Error(err),                   // 记录错误对象
-						zap.Int("timeout", timeout),      // 假设 timeout 是 time.Duration 类型
-						zap.Duration("elapsed", elapsed)) // 假设 elapsed 是 time.Duration 类型
-
-				} else if errors.Is(err, context.Canceled) {
-					// Log a message if the operation was canceled.
-					mylog.Logger.Error("Operation canceled %v, actual waiting time: %v", zap.Error(err), zap.Duration("elapsed", elapsed))
-				} else {
-					// Log a message for any other unknown errors that occurred while waiting for a token.
-					mylog.Logger.Error("Unknown error occurred while waiting for a token: ", zap.Error(err), zap.Duration("elapsed", elapsed))
-				}
-
-				//waitDuration := time.Since(startWaitTime)
-				mylog.Logger.Info("waited for: ", zap.Duration("elapsed", elapsed))
-				sendErrorResponse(c, http.StatusTooManyRequests, "Request rate limit exceeded")
-				return
-			}
-			// 假设 logger 是一个已经配置好的 zap.Logger 实例
-			mylog.Logger.Info("Wait duration",
-				zap.Duration("waited_for", time.Since(startWaitTime)))
-
-		} else if lt == "concurrency" {
-
-			err := limiter.Acquire(ctx)
-			if err != nil {
-				mylog.Logger.Error(err.Error())
-			}
-			defer limiter.Release()
-
-			mylog.Logger.Info("Concurrency wait time",
-				zap.Duration("waited_for", time.Since(startWaitTime)))
-		}
-
-	}
-
 	if config.IsProxyEnabled(s) {
 		proxyType, proxyAddr, transport, err := config.GetConfProxyTransport()
 		if err != nil {
@@ -343,14 +453,33 @@ func HandleOpenAIRequest(c *gin.Context, oaiReq *openai.ChatCompletionRequest, n
 	//mylog.Logger.Debug("oaiReq", zap.Any("oaiReq", oaiReq))
 	oaiReq.Messages = mycommon.NormalizeMessages(oaiReq.Messages, keepAllSystem)
 
-	if err := dispatchToServiceHandler(c, oaiReqParam); err != nil {
-		mylog.Logger.Error(err.Error())
-		sendErrorResponse(c, http.StatusInternalServerError, err.Error())
-		return
+	if !permit.HasTokenLimit() {
+		return dispatchToServiceHandler(c, oaiReqParam)
 	}
 
-	if oaiReq.Stream {
-		utils.SendOpenAIStreamEOFData(c)
+	// 统计本次调用的token用量，按实际用量结算预留的token
+	uw := newUsageResponseWriter(c.Writer, oaiReq.Stream)
+	c.Writer = uw
+	err = dispatchToServiceHandler(c, oaiReqParam)
+	c.Writer = uw.ResponseWriter
+	settleTokenUsage(permit, uw, oaiReq, err)
+	return err
+}
+
+// restoreResponseHeader 将响应头恢复到调用前的状态，避免失败尝试设置的头(如event-stream)影响下一次尝试
+func restoreResponseHeader(c *gin.Context, snapshot http.Header) {
+	replaceHeader(c.Writer.Header(), snapshot)
+}
+
+// replaceHeader 使用src替换dst中的所有响应头
+func replaceHeader(dst http.Header, src http.Header) {
+	for k := range dst {
+		if _, ok := src[k]; !ok {
+			dst.Del(k)
+		}
+	}
+	for k, v := range src {
+		dst[k] = v
 	}
 }
 
@@ -372,22 +501,11 @@ func validateRequestMethod(c *gin.Context, method string) bool {
 	return true
 }
 
-func validateAPIKey(apikey string) bool {
-	if config.APIKey == "" {
-		return true
-	}
-
-	if config.APIKey != apikey {
-		return false
-	}
-	return true
-}
-
-func getModelDetails(oaiReq *openai.ChatCompletionRequest, namespace string) (*config.ModelDetails, string, error) {
+func getModelDetailsWithOptions(oaiReq *openai.ChatCompletionRequest, namespace string, opts *config.RouteOptions) (*config.ModelDetails, string, error) {
 	if oaiReq.Model == config.KEYNAME_RANDOM {
-		return config.GetRandomEnabledModelDetailsV1()
+		return config.GetRandomEnabledModelDetailsWithOptions(namespace, opts)
 	}
-	s, err := config.GetModelService(oaiReq.Model, namespace)
+	s, err := config.GetModelServiceWithOptions(oaiReq.Model, namespace, opts)
 	if err != nil {
 		return nil, "", err
 	}
@@ -395,6 +513,63 @@ func getModelDetails(oaiReq *openai.ChatCompletionRequest, namespace string) (*c
 	return s, oaiReq.Model, err
 }
 
+// getHashKey 根据hash_key配置获取consistent_hash策略使用的粘性key：OpenAI请求的user字段、指定的请求头或者API key
+func getHashKey(c *gin.Context, oaiReq *openai.ChatCompletionRequest) string {
+	return getStickyKey(c, oaiReq, config.HashKeySource)
+}
+
+// getStickyKey 按来源(user、api_key、header:<请求头名称>)获取会话粘性key
+func getStickyKey(c *gin.Context, oaiReq *openai.ChatCompletionRequest, source string) string {
+	switch {
+	case source == config.HASH_KEY_USER:
+		return oaiReq.User
+	case source == config.HASH_KEY_API_KEY:
+		apikey, _ := utils.GetAPIKeyFromHeader(c)
+		return apikey
+	case strings.HasPrefix(strings.ToLower(source), config.HASH_KEY_HEADER):
+		return c.GetHeader(source[len(config.HASH_KEY_HEADER):])
+	default:
+		return ""
+	}
+}
+
 func sendErrorResponse(c *gin.Context, code int, msg string) {
 	c.JSON(code, gin.H{"error": msg})
 }
+
+// routingError 没有找到可用服务时的错误，与上游调用失败区分开
+type routingError struct {
+	err error
+}
+
+func (e *routingError) Error() string {
+	return e.err.Error()
+}
+
+func (e *routingError) Unwrap() error {
+	return e.err
+}
+
+// sendUpstreamErrorResponse 返回调用失败的错误：路由错误返回400(上下文超长返回context_length_exceeded，全部熔断时返回503)，本地限流超时返回429，其余返回500
+func sendUpstreamErrorResponse(c *gin.Context, err error) {
+	var rErr *routingError
+	if errors.As(err, &rErr) {
+		var ctxErr *config.ContextLengthError
+		if errors.As(err, &ctxErr) {
+			mycommon.SendOpenAIErrorResponse(c, http.StatusBadRequest, ctxErr.Error(), "invalid_request_error", "context_length_exceeded")
+			return
+		}
+		if errors.Is(err, config.ErrCircuitOpen) {
+			sendErrorResponse(c, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		sendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, errLocalRateLimit) {
+		mycommon.SetRateLimitRetryAfter(c)
+		sendErrorResponse(c, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	sendErrorResponse(c, http.StatusInternalServerError, err.Error())
+}
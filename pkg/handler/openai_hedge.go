@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+	"net/http"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mycommon"
+	"simple-one-api/pkg/mylog"
+	"sync"
+	"time"
+)
+
+// errHedgeLost 对冲请求中落败的一方写出数据时返回的错误
+var errHedgeLost = errors.New("hedged request lost the race")
+
+// hedgeRace 多个对冲请求共享的状态，第一个向客户端写出数据的请求获胜
+type hedgeRace struct {
+	mu      sync.Mutex
+	real    gin.ResponseWriter
+	winner  *hedgeWriter
+	writers []*hedgeWriter
+	closed  bool
+}
+
+// hedgeWriter 对冲请求使用的ResponseWriter，获胜前响应头和状态码只记录在本地，获胜后直接写到客户端
+type hedgeWriter struct {
+	gin.ResponseWriter
+	race   *hedgeRace
+	header http.Header
+	status int
+	cancel context.CancelFunc
+}
+
+func (r *hedgeRace) newWriter(cancel context.CancelFunc) *hedgeWriter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w := &hedgeWriter{
+		ResponseWriter: r.real,
+		race:           r,
+		header:         r.real.Header().Clone(),
+		cancel:         cancel,
+	}
+	r.writers = append(r.writers, w)
+	return w
+}
+
+// finish 结束竞争并取消所有未获胜的请求，之后它们不能再向客户端写出数据
+func (r *hedgeRace) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for _, w := range r.writers {
+		if w != r.winner {
+			w.cancel()
+		}
+	}
+}
+
+func (r *hedgeRace) getWinner() *hedgeWriter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.winner
+}
+
+// claim 尝试成为获胜者，获胜后将响应头和状态码写到客户端，并取消其他请求
+func (w *hedgeWriter) claim() bool {
+	r := w.race
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.winner != nil {
+		return r.winner == w
+	}
+	if r.closed {
+		return false
+	}
+	// 错误响应不参与竞争，由调用方按失败处理
+	if w.status >= http.StatusBadRequest {
+		return false
+	}
+
+	r.winner = w
+	replaceHeader(r.real.Header(), w.header)
+	if w.status != 0 {
+		r.real.WriteHeader(w.status)
+	}
+	for _, other := range r.writers {
+		if other != w {
+			other.cancel()
+		}
+	}
+	return true
+}
+
+func (w *hedgeWriter) won() bool {
+	return w.race.getWinner() == w
+}
+
+func (w *hedgeWriter) Header() http.Header {
+	if w.won() {
+		return w.race.real.Header()
+	}
+	return w.header
+}
+
+func (w *hedgeWriter) WriteHeader(code int) {
+	if w.won() {
+		w.race.real.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *hedgeWriter) WriteHeaderNow() {
+	if w.won() {
+		w.race.real.WriteHeaderNow()
+	}
+}
+
+func (w *hedgeWriter) Write(data []byte) (int, error) {
+	if !w.claim() {
+		if w.status >= http.StatusBadRequest {
+			return len(data), nil
+		}
+		return 0, errHedgeLost
+	}
+	return w.race.real.Write(data)
+}
+
+func (w *hedgeWriter) WriteString(s string) (int, error) {
+	if !w.claim() {
+		if w.status >= http.StatusBadRequest {
+			return len(s), nil
+		}
+		return 0, errHedgeLost
+	}
+	return w.race.real.WriteString(s)
+}
+
+func (w *hedgeWriter) Flush() {
+	if w.won() {
+		w.race.real.Flush()
+	}
+}
+
+func (w *hedgeWriter) Status() int {
+	if w.won() {
+		return w.race.real.Status()
+	}
+	if w.status != 0 {
+		return w.status
+	}
+	return http.StatusOK
+}
+
+func (w *hedgeWriter) Size() int {
+	if w.won() {
+		return w.race.real.Size()
+	}
+	return -1
+}
+
+func (w *hedgeWriter) Written() bool {
+	return w.won() && w.race.real.Written()
+}
+
+// hedgeResult 单个对冲请求的结果
+type hedgeResult struct {
+	writer *hedgeWriter
+	err    error
+}
+
+// handleHedgedAttempt 先向选定的服务/凭证发送请求，在hedge.delay_ms内没有写出首token时，
+// 向另一个可用的服务/凭证发送相同的请求，使用先返回数据的结果并取消另一个请求
+func handleHedgedAttempt(c *gin.Context, oaiReq *openai.ChatCompletionRequest, s *config.ModelDetails, serviceModelName string,
+	creds map[string]interface{}, credsID string, clientModel string, gRedirectModel string, namespace string, routeOpts *config.RouteOptions) error {
+
+	// 保留一份原始请求，handleOpenAIRequestWithService会修改模型名和消息
+	hedgeReq := mycommon.DeepCopyChatCompletionRequest(*oaiReq)
+
+	race := &hedgeRace{real: c.Writer}
+	defer race.finish()
+	results := make(chan hedgeResult, 2)
+
+	startLeg := func(req *openai.ChatCompletionRequest, s *config.ModelDetails, serviceModelName string,
+		creds map[string]interface{}, credsID string) {
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		legCtx := c.Copy()
+		legCtx.Request = c.Request.WithContext(ctx)
+		w := race.newWriter(cancel)
+		legCtx.Writer = w
+
+		go func() {
+			defer cancel()
+			err := runUpstreamAttempt(legCtx, req, s, serviceModelName, creds, credsID, clientModel, gRedirectModel)
+			if err == nil && w.status >= http.StatusBadRequest {
+				err = fmt.Errorf("hedged request failed with status code: %d", w.status)
+			}
+			results <- hedgeResult{writer: w, err: err}
+		}()
+	}
+
+	startLeg(oaiReq, s, serviceModelName, creds, credsID)
+	running := 1
+
+	timer := time.NewTimer(time.Duration(s.Hedge.DelayMs) * time.Millisecond)
+	defer timer.Stop()
+	timerC := timer.C
+
+	var lastErr error
+	for {
+		select {
+		case <-timerC:
+			timerC = nil
+			if race.getWinner() != nil {
+				continue
+			}
+
+			hs, hModelName, err := getModelDetailsWithOptions(&hedgeReq, namespace, routeOpts)
+			if err != nil {
+				mylog.Logger.Info("No service available for hedged request", zap.Error(err))
+				continue
+			}
+			// 对冲的一方同样需要能够在落败后取消
+			if !config.IsHedgeSupported(hs.ServiceName) {
+				mylog.Logger.Info("Hedge not supported by selected service", zap.String("service_name", hs.ServiceName))
+				continue
+			}
+			hCreds, hCredsID := mycommon.GetACredentialsWithOptions(hs, hedgeReq.Model, routeOpts)
+			routeOpts.Excluded[config.GetExcludeKey(hs, hCredsID)] = true
+			if !config.AcquireCircuit(hs, hCredsID) {
+				mylog.Logger.Info("Circuit half-open probe in flight, skip hedged request", zap.String("service_id", hs.ServiceID))
+				continue
+			}
+
+			mylog.Logger.Info("Hedged request",
+				zap.Int("delay_ms", s.Hedge.DelayMs),
+				zap.String("primary_service_id", s.ServiceID),
+				zap.String("service_name", hs.ServiceName),
+				zap.String("service_id", hs.ServiceID),
+				zap.String("creds_id", hCredsID),
+				zap.String("client_model", clientModel))
+
+			startLeg(&hedgeReq, hs, hModelName, hCreds, hCredsID)
+			running++
+
+		case r := <-results:
+			running--
+			winner := race.getWinner()
+			if winner == r.writer {
+				return r.err
+			}
+			if winner == nil {
+				if r.err == nil {
+					// 请求成功但没有写出任何数据
+					return nil
+				}
+				lastErr = r.err
+				if running == 0 {
+					return lastErr
+				}
+			}
+			// 落败的请求结束，继续等待获胜的请求
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mylog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeUpstream 模拟OpenAI兼容的上游，按请求中的API key选择行为
+type fakeUpstream struct {
+	*httptest.Server
+	calls sync.Map // api key -> *int32
+}
+
+func newFakeUpstream(t *testing.T, handlers map[string]http.HandlerFunc) *fakeUpstream {
+	u := &fakeUpstream{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		n, _ := u.calls.LoadOrStore(key, new(int32))
+		atomic.AddInt32(n.(*int32), 1)
+		h, ok := handlers[key]
+		if !ok {
+			http.Error(w, "unknown key", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+// Calls 返回使用key的上游请求次数
+func (u *fakeUpstream) Calls(key string) int {
+	if n, ok := u.calls.Load(key); ok {
+		return int(atomic.LoadInt32(n.(*int32)))
+	}
+	return 0
+}
+
+// replyContent 返回内容为content的聊天补全响应
+func replyContent(content string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			ID:     "chatcmpl-test",
+			Object: "chat.completion",
+			Model:  "upstream-model",
+			Choices: []openai.ChatCompletionChoice{{
+				Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content},
+				FinishReason: openai.FinishReasonStop,
+			}},
+		})
+	}
+}
+
+// replyStatus 等待delay后返回状态码status
+func replyStatus(status int, delay time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		http.Error(w, `{"error":{"message":"upstream failed"}}`, status)
+	}
+}
+
+// replyHang 一直等到请求被取消，取消时把name发送到canceled
+func replyHang(canceled chan<- string, name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 读完请求体后服务端才能检测到连接断开
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+			canceled <- name
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// loadTestConfig 把conf写到临时配置文件中并加载，测试结束后恢复为空配置
+func loadTestConfig(t *testing.T, conf string) {
+	mylog.InitLog("prod")
+	gin.SetMode(gin.TestMode)
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.InitConfig(path); err != nil {
+		t.Fatalf("load config failed: %v", err)
+	}
+	t.Cleanup(func() {
+		config.ModelToService = nil
+		config.GlobalModelRedirect = nil
+		config.ModelFallbacks = nil
+		config.TrafficSplit = nil
+		config.ResetLBState()
+	})
+}
+
+// doChatRequest 通过HandleOpenAIRequest发送一个非流式的聊天请求
+func doChatRequest(t *testing.T, model string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	HandleOpenAIRequest(c, &openai.ChatCompletionRequest{
+		Model:    model,
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hello"}},
+	}, "")
+	return rec
+}
+
+// hedgeTestConfig 一个服务两个凭证，按first策略先使用primary，hedge.delay_ms后使用hedge
+func hedgeTestConfig(serverURL string) string {
+	return `{
+  "load_balancing": "first",
+  "services": {
+    "openai": [{
+      "models": ["gpt-test"],
+      "enabled": true,
+      "server_url": "` + serverURL + `/v1",
+      "credential_list": [{"api_key": "primary"}, {"api_key": "hedge"}],
+      "hedge": {"delay_ms": 50}
+    }]
+  }
+}`
+}
+
+func TestHedgeSlowPrimaryLoses(t *testing.T) {
+	canceled := make(chan string, 1)
+	u := newFakeUpstream(t, map[string]http.HandlerFunc{
+		"primary": replyHang(canceled, "primary"),
+		"hedge":   replyContent("from hedge"),
+	})
+	loadTestConfig(t, hedgeTestConfig(u.URL))
+
+	rec := doChatRequest(t, "gpt-test")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "from hedge") {
+		t.Fatalf("expected hedge response, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// 落败的请求被取消
+	select {
+	case name := <-canceled:
+		if name != "primary" {
+			t.Fatalf("expected primary canceled, got %s", name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("primary request was not canceled")
+	}
+}
+
+func TestHedgeFastPrimaryWins(t *testing.T) {
+	u := newFakeUpstream(t, map[string]http.HandlerFunc{
+		"primary": replyContent("from primary"),
+		"hedge":   replyContent("from hedge"),
+	})
+	loadTestConfig(t, hedgeTestConfig(u.URL))
+
+	rec := doChatRequest(t, "gpt-test")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "from primary") {
+		t.Fatalf("expected primary response, got %d %s", rec.Code, rec.Body.String())
+	}
+	// 首token在delay_ms内返回，不发出对冲请求
+	if n := u.Calls("hedge"); n != 0 {
+		t.Fatalf("expected no hedged request, got %d", n)
+	}
+}
+
+func TestHedgeBothLegsFail(t *testing.T) {
+	u := newFakeUpstream(t, map[string]http.HandlerFunc{
+		"primary": replyStatus(http.StatusInternalServerError, 200*time.Millisecond),
+		"hedge":   replyStatus(http.StatusInternalServerError, 0),
+	})
+	loadTestConfig(t, hedgeTestConfig(u.URL))
+
+	rec := doChatRequest(t, "gpt-test")
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d %s", rec.Code, rec.Body.String())
+	}
+	if u.Calls("primary") != 1 || u.Calls("hedge") != 1 {
+		t.Fatalf("expected one call per leg, got primary=%d hedge=%d", u.Calls("primary"), u.Calls("hedge"))
+	}
+}
+
+func TestHedgeErrorResponseDoesNotClaim(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+
+	race := &hedgeRace{real: c.Writer}
+	var loserCanceled, winnerCanceled int32
+	loser := race.newWriter(func() { atomic.StoreInt32(&loserCanceled, 1) })
+	winner := race.newWriter(func() { atomic.StoreInt32(&winnerCanceled, 1) })
+
+	// 先写出4xx的请求不能成为获胜者，写出的数据被丢弃
+	loser.WriteHeader(http.StatusBadRequest)
+	if n, err := loser.Write([]byte("bad request")); err != nil || n != len("bad request") {
+		t.Fatalf("expected error body swallowed, got %d %v", n, err)
+	}
+	if race.getWinner() != nil {
+		t.Fatal("error response claimed the race")
+	}
+
+	winner.Header().Set("X-Leg", "winner")
+	winner.WriteHeader(http.StatusOK)
+	if _, err := winner.Write([]byte("ok")); err != nil {
+		t.Fatalf("winner write failed: %v", err)
+	}
+	race.finish()
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" || rec.Header().Get("X-Leg") != "winner" {
+		t.Fatalf("unexpected response %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+	if atomic.LoadInt32(&loserCanceled) != 1 || atomic.LoadInt32(&winnerCanceled) != 0 {
+		t.Fatal("expected only the loser canceled")
+	}
+	// 落败后再写出数据返回errHedgeLost
+	loser.WriteHeader(http.StatusOK)
+	if _, err := loser.Write([]byte("late")); err != errHedgeLost {
+		t.Fatalf("expected errHedgeLost, got %v", err)
+	}
+}
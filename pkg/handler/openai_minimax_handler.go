@@ -67,6 +67,8 @@ func OpenAI2MinimaxHandler(c *gin.Context, oaiReqParam *OAIRequestParam) error {
 			return err
 		}
 		defer response.Body.Close()
+		// 记录响应header，自适应并发根据Retry-After暂停
+		utils.RecordUpstreamHeader(c.Request.Context(), response)
 
 		id := uuid.New()
 		utils.SetEventStreamHeaders(c)
@@ -140,6 +142,8 @@ func OpenAI2MinimaxHandler(c *gin.Context, oaiReqParam *OAIRequestParam) error {
 			return err
 		}
 		defer response.Body.Close()
+		// 记录响应header，自适应并发根据Retry-After暂停
+		utils.RecordUpstreamHeader(c.Request.Context(), response)
 
 		bodyData, err := io.ReadAll(response.Body)
 		if err != nil {
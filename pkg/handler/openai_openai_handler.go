@@ -112,7 +112,8 @@ func handleOpenAIOpenAIRequest(conf openai.ClientConfig, c *gin.Context, req *op
 
 	openaiClient := openai.NewClientWithConfig(conf)
 
-	ctx := context.Background()
+	// 客户端断开或对冲请求落败时取消上游调用
+	ctx := c.Request.Context()
 
 	if req.Stream {
 		return handleOpenAIOpenAIStreamRequest(c, openaiClient, ctx, req, clientModel)
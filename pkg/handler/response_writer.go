@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+// timingResponseWriter 记录第一次向客户端写出数据的时间，用于统计首token耗时
+type timingResponseWriter struct {
+	gin.ResponseWriter
+	firstWrite time.Time
+}
+
+func newTimingResponseWriter(w gin.ResponseWriter) *timingResponseWriter {
+	return &timingResponseWriter{ResponseWriter: w}
+}
+
+func (w *timingResponseWriter) markFirstWrite() {
+	if w.firstWrite.IsZero() {
+		w.firstWrite = time.Now()
+	}
+}
+
+func (w *timingResponseWriter) Write(data []byte) (int, error) {
+	w.markFirstWrite()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timingResponseWriter) WriteString(s string) (int, error) {
+	w.markFirstWrite()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// TimeToFirstWrite 返回从start到第一次写出数据的耗时，还没有写出数据时返回到当前的耗时
+func (w *timingResponseWriter) TimeToFirstWrite(start time.Time) time.Duration {
+	if w.firstWrite.IsZero() {
+		return time.Since(start)
+	}
+	return w.firstWrite.Sub(start)
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+	"net/http"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mycommon"
+	"simple-one-api/pkg/mylimiter"
+	"simple-one-api/pkg/mylog"
+	"simple-one-api/pkg/utils"
+	"time"
+)
+
+// credentialLimiterID 凭证限流使用的ID，没有凭证列表时使用服务的credentials
+func credentialLimiterID(s *config.ModelDetails, credsID string) string {
+	if credsID == "" {
+		return s.ServiceID + "_credentials"
+	}
+	return credsID
+}
+
+// getAdaptiveConcurrency 返回凭证的自适应并发，服务没有开启adaptive_concurrency时返回nil
+func getAdaptiveConcurrency(s *config.ModelDetails, credsID string) *mylimiter.AdaptiveConcurrency {
+	if !s.AdaptiveConcurrency.Enabled {
+		return nil
+	}
+	return mylimiter.GetAdaptiveConcurrency(config.GetLimiterKey(s, credsID), mycommon.GetAdaptiveConf(s.AdaptiveConcurrency))
+}
+
+// recordAdaptiveResult 根据上游调用的结果调整凭证的并发数：限流错误时减小并按响应header暂停，成功时缓慢增加
+func recordAdaptiveResult(s *config.ModelDetails, credsID string, err error, upstream *utils.UpstreamHeader) {
+	a := getAdaptiveConcurrency(s, credentialLimiterID(s, credsID))
+	if a == nil || errors.Is(err, errLocalRateLimit) {
+		return
+	}
+	if err == nil {
+		a.OnSuccess()
+		return
+	}
+
+	statusCode, header := upstream.Get()
+	if !mycommon.IsUpstreamThrottled(err) && statusCode != http.StatusTooManyRequests {
+		return
+	}
+	var retryAfter time.Duration
+	if statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable {
+		retryAfter = mylimiter.ParseRetryAfter(header, time.Now())
+	}
+	a.OnThrottled(retryAfter)
+}
+
+// acquireServiceLimits 在一个等待过程中通过服务和凭证配置的所有限制(qps、qpm/rpm、concurrency、tpm、tpd)，
+// tpm/tpd按请求估算的token数预留；在限流的timeout内等不到时返回errLocalRateLimit。
+// 成功时返回的许可必须在调用结束后Release，都没有配置时返回nil
+func acquireServiceLimits(c *gin.Context, oaiReq *openai.ChatCompletionRequest, s *config.ModelDetails,
+	creds map[string]interface{}, credsID string) (*mylimiter.Permit, error) {
+
+	var limiters []*mylimiter.Limiter
+	if lim := mylimiter.GetLimiter(config.GetLimiterKey(s, s.ServiceID), mycommon.GetLimits(s.Limit)); lim != nil {
+		limiters = append(limiters, lim)
+	}
+	timeout := s.Limit.Timeout
+	credLimit := mycommon.GetCredentialLimit(creds)
+	credsID = credentialLimiterID(s, credsID)
+	if lim := mylimiter.GetAdaptiveLimiter(config.GetLimiterKey(s, credsID), mycommon.GetLimits(credLimit), getAdaptiveConcurrency(s, credsID)); lim != nil {
+		limiters = append(limiters, lim)
+	}
+	if timeout <= 0 {
+		timeout = credLimit.Timeout
+	}
+	if len(limiters) == 0 {
+		return nil, nil
+	}
+
+	if timeout <= 0 {
+		timeout = defaultReqTimeout
+	}
+	// 使用请求的上下文，对冲请求中落败的一方或客户端断开时不再继续等待
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	tokens := int64(mycommon.EstimateChatCompletionTokens(oaiReq))
+	startWaitTime := time.Now()
+	permit, err := mylimiter.Acquire(ctx, tokens, limiters...)
+	elapsed := time.Since(startWaitTime)
+	mycommon.SetRateLimitHeaders(c, limiters...)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			mylog.Logger.Error("Failed to pass rate limits within the specified time",
+				zap.String("service_id", s.ServiceID),
+				zap.String("creds_id", credsID),
+				zap.Int64("tokens", tokens),
+				zap.Int("timeout", timeout),
+				zap.Duration("elapsed", elapsed))
+		} else {
+			mylog.Logger.Error("Waiting for rate limits canceled", zap.Error(err), zap.Duration("elapsed", elapsed))
+		}
+		return nil, errLocalRateLimit
+	}
+
+	mylog.Logger.Info("Rate limits wait duration",
+		zap.String("service_id", s.ServiceID),
+		zap.String("creds_id", credsID),
+		zap.Int64("tokens", tokens),
+		zap.Duration("waited_for", elapsed))
+	return permit, nil
+}
+
+// settleTokenUsage 按调用的实际用量结算预留的token，调用失败且没有输出时归还全部预留
+func settleTokenUsage(permit *mylimiter.Permit, uw *usageResponseWriter, oaiReq *openai.ChatCompletionRequest, err error) {
+	if err != nil && !uw.Written() {
+		permit.Settle(0)
+		return
+	}
+	promptTokens, completionTokens := uw.Usage(oaiReq)
+	permit.Settle(promptTokens + completionTokens)
+}
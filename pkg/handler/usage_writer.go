@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+	"simple-one-api/pkg/mycommon"
+	"strings"
+)
+
+// maxUsageBodySize 非流式响应最多缓存这么多字节用于解析usage
+const maxUsageBodySize = 8 << 20
+
+// usageResponse 从响应中解析usage和生成的内容，流式和非流式响应共用
+type usageResponse struct {
+	Usage   *openai.Usage `json:"usage"`
+	Choices []struct {
+		Message struct {
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content"`
+		} `json:"message"`
+		Delta struct {
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+// usageResponseWriter 在写出响应的同时解析上游返回的usage，没有usage时记录生成的内容用于估算
+type usageResponseWriter struct {
+	gin.ResponseWriter
+	stream     bool
+	buf        bytes.Buffer
+	usage      *openai.Usage
+	completion strings.Builder
+}
+
+func newUsageResponseWriter(w gin.ResponseWriter, stream bool) *usageResponseWriter {
+	return &usageResponseWriter{ResponseWriter: w, stream: stream}
+}
+
+func (w *usageResponseWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.capture(data[:n])
+	return n, err
+}
+
+func (w *usageResponseWriter) WriteString(s string) (int, error) {
+	n, err := w.ResponseWriter.WriteString(s)
+	w.capture([]byte(s[:n]))
+	return n, err
+}
+
+func (w *usageResponseWriter) capture(data []byte) {
+	if !w.stream {
+		if w.buf.Len()+len(data) <= maxUsageBodySize {
+			w.buf.Write(data)
+		}
+		return
+	}
+
+	// 流式响应按行解析 "data: {...}"
+	w.buf.Write(data)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// 不完整的行放回缓冲区等待后续数据
+			w.buf.Reset()
+			w.buf.WriteString(line)
+			return
+		}
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if payload == "" || payload == "[DONE]" {
+			continue
+		}
+		w.parse([]byte(payload))
+	}
+}
+
+func (w *usageResponseWriter) parse(data []byte) {
+	var resp usageResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return
+	}
+	if resp.Usage != nil && resp.Usage.TotalTokens > 0 {
+		w.usage = resp.Usage
+	}
+	for _, choice := range resp.Choices {
+		w.completion.WriteString(choice.Message.Content)
+		w.completion.WriteString(choice.Message.ReasoningContent)
+		w.completion.WriteString(choice.Delta.Content)
+		w.completion.WriteString(choice.Delta.ReasoningContent)
+	}
+}
+
+// Usage 返回请求的token用量，上游没有返回usage时按请求和生成的内容估算
+func (w *usageResponseWriter) Usage(oaiReq *openai.ChatCompletionRequest) (int64, int64) {
+	if !w.stream && w.buf.Len() > 0 {
+		w.parse(w.buf.Bytes())
+		w.buf.Reset()
+	}
+	if w.usage != nil {
+		return int64(w.usage.PromptTokens), int64(w.usage.CompletionTokens)
+	}
+	return int64(mycommon.EstimatePromptTokens(oaiReq)), int64(mycommon.EstimateTextTokens(w.completion.String()))
+}
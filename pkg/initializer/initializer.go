@@ -2,14 +2,25 @@
 package initializer
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
 	"log"
 	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mylimiter"
 	"simple-one-api/pkg/mylog"
 	"sync"
+	"time"
 )
 
-var once sync.Once
+// defaultRateLimitPrefix Redis中限流键的默认前缀
+const defaultRateLimitPrefix = "simple-one-api:ratelimit:"
+
+var (
+	once        sync.Once
+	redisClient *redis.Client
+)
 
 // Setup initializes the configuration and logging system.
 func Setup(configName string) error {
@@ -29,10 +40,42 @@ func Setup(configName string) error {
 
 		mylog.InitLog(config.LogLevel)
 		log.Println("config.LogLevel ok")
+
+		initRateLimitStore(&config.GSOAConf.RateLimitStore)
 	})
 	return err
 }
 
+// initRateLimitStore 按配置设置限流存储，修改rate_limit_store后需要重启才能生效
+func initRateLimitStore(conf *config.RateLimitStoreConf) {
+	if conf.Type != "redis" {
+		return
+	}
+
+	prefix := conf.Prefix
+	if prefix == "" {
+		prefix = defaultRateLimitPrefix
+	}
+	redisClient = redis.NewClient(&redis.Options{
+		Addr:     conf.Addr,
+		Password: conf.Password,
+		DB:       conf.DB,
+	})
+	mylimiter.SetStore(mylimiter.NewRedisStore(redisClient, prefix, time.Duration(conf.LeaseSeconds)*time.Second))
+
+	// 连接失败不影响启动，Redis不可用期间限流不生效
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := redisClient.Ping(ctx).Err(); err != nil {
+		mylog.Logger.Error("connect rate limit redis error", zap.String("addr", conf.Addr), zap.Error(err))
+		return
+	}
+	mylog.Logger.Info("rate limit store: redis", zap.String("addr", conf.Addr), zap.String("prefix", prefix))
+}
+
 func Cleanup() {
+	if redisClient != nil {
+		redisClient.Close()
+	}
 	mylog.Logger.Sync() // Ensure all logs are flushed properly
 }
@@ -0,0 +1,130 @@
+package myauth
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mylog"
+	"sync"
+)
+
+const (
+	AUTH_TYPE_CONFIG = "config"
+	AUTH_TYPE_HTTP   = "http"
+	AUTH_TYPE_FILE   = "file"
+	AUTH_TYPE_JWT    = "jwt"
+)
+
+// AuthResult 鉴权结果，Allowed为true时请求使用Namespace下的服务，否则Message为拒绝原因；
+// KeyConfig为API key的配置(限流等)，鉴权方式不提供时为nil
+type AuthResult struct {
+	Allowed   bool
+	Namespace string
+	Message   string
+	KeyConfig *config.APIKeyConfig
+}
+
+// Authorizer 校验API key是否可以调用模型，并返回请求使用的namespace；
+// 返回error表示鉴权服务本身出错，而不是拒绝请求
+type Authorizer interface {
+	Authorize(ctx context.Context, apiKey string, model string) (*AuthResult, error)
+}
+
+// KeyConfigProvider 可以按API key查询配置的鉴权实现，用于查询额度等不针对具体模型的接口
+type KeyConfigProvider interface {
+	GetKeyConfig(apiKey string) (*config.APIKeyConfig, bool)
+}
+
+// KeyAuthenticator 可以只校验API key、不针对具体模型的鉴权实现
+type KeyAuthenticator interface {
+	Authenticate(ctx context.Context, apiKey string) (*AuthResult, error)
+}
+
+var (
+	authorizer     Authorizer
+	authorizerConf config.AuthConf
+	authorizerLock = &sync.Mutex{}
+)
+
+// GetAuthorizer 按auth配置返回鉴权实现，配置变化(如热加载)后重新创建
+func GetAuthorizer() Authorizer {
+	var conf config.AuthConf
+	if config.GSOAConf != nil {
+		conf = config.GSOAConf.Auth
+	}
+
+	authorizerLock.Lock()
+	defer authorizerLock.Unlock()
+	if authorizer != nil && conf == authorizerConf {
+		return authorizer
+	}
+
+	authorizer = newAuthorizer(conf)
+	authorizerConf = conf
+	return authorizer
+}
+
+func newAuthorizer(conf config.AuthConf) Authorizer {
+	mylog.Logger.Info("Init authorizer", zap.String("type", conf.Type))
+	switch conf.Type {
+	case AUTH_TYPE_HTTP:
+		return NewHTTPAuthorizer(conf.URL, conf.Timeout, conf.CacheTTL)
+	case AUTH_TYPE_FILE:
+		return NewFileAuthorizer(conf.File)
+	case AUTH_TYPE_JWT:
+		return NewJWTAuthorizer(conf.JWT, conf.Timeout)
+	case AUTH_TYPE_CONFIG, "":
+		return &ConfigAuthorizer{}
+	default:
+		mylog.Logger.Error("unsupported auth type, fall back to config", zap.String("type", conf.Type))
+		return &ConfigAuthorizer{}
+	}
+}
+
+// Authorize 使用当前配置的鉴权实现校验API key和模型
+func Authorize(ctx context.Context, apiKey string, model string) (*AuthResult, error) {
+	return GetAuthorizer().Authorize(ctx, apiKey, model)
+}
+
+// ValidatesCredential 当前的鉴权实现是否自己校验请求中的凭证：jwt校验token的签名，http交给外部服务校验。
+// 这时请求中的凭证不是全局api_key，不再与全局api_key比较
+func ValidatesCredential() bool {
+	switch GetAuthorizer().(type) {
+	case *JWTAuthorizer, *HTTPAuthorizer:
+		return true
+	}
+	return false
+}
+
+// Authenticate 只校验API key本身，不检查模型白名单，用于WebSocket等建立连接时还不知道模型的入口；
+// 鉴权实现既不能只校验key也不能查询key的配置时(http)，使用空的模型名调用鉴权
+func Authenticate(ctx context.Context, apiKey string) (*AuthResult, error) {
+	a := GetAuthorizer()
+	if ka, ok := a.(KeyAuthenticator); ok {
+		return ka.Authenticate(ctx, apiKey)
+	}
+	if p, ok := a.(KeyConfigProvider); ok {
+		keyConfig, exists := p.GetKeyConfig(apiKey)
+		if !exists {
+			return &AuthResult{Message: "Forbidden: invalid API key"}, nil
+		}
+		return &AuthResult{Allowed: true, Namespace: keyConfig.Namespace, KeyConfig: keyConfig}, nil
+	}
+	return a.Authorize(ctx, apiKey, "")
+}
+
+// LookupKeyConfig 使用当前配置的鉴权实现查询API key的配置，鉴权实现不支持或key不存在时返回false
+func LookupKeyConfig(apiKey string) (*config.APIKeyConfig, bool) {
+	if p, ok := GetAuthorizer().(KeyConfigProvider); ok {
+		return p.GetKeyConfig(apiKey)
+	}
+	return nil, false
+}
+
+// LookupNamespace 查询API key所属的namespace，用于/v1/models等不针对具体模型的接口，查不到时返回默认命名空间
+func LookupNamespace(apiKey string) string {
+	if keyConfig, exists := LookupKeyConfig(apiKey); exists {
+		return keyConfig.Namespace
+	}
+	return ""
+}
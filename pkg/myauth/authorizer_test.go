@@ -0,0 +1,67 @@
+package myauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"simple-one-api/pkg/mylog"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHTTPAuthorizer(t *testing.T) {
+	mylog.InitLog("prod")
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/good/6770742d346f" {
+			w.Write([]byte(`{"namespace": "team-a"}`))
+			return
+		}
+		w.Write([]byte(`{"msg": "no permission"}`))
+	}))
+	defer srv.Close()
+
+	a := NewHTTPAuthorizer(srv.URL+"/{api_key}/{model_hex}", 1, 60)
+	for i := 0; i < 3; i++ {
+		result, err := a.Authorize(context.Background(), "good", "gpt-4o")
+		if err != nil || !result.Allowed || result.Namespace != "team-a" {
+			t.Fatalf("expected allowed in team-a, got %+v, %v", result, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected cached result, got %d calls", calls)
+	}
+
+	result, err := a.Authorize(context.Background(), "bad", "gpt-4o")
+	if err != nil || result.Allowed || result.Message != "no permission" {
+		t.Fatalf("expected denied, got %+v, %v", result, err)
+	}
+}
+
+func TestFileAuthorizer(t *testing.T) {
+	mylog.InitLog("prod")
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	data := `{"api_keys": [{"api_key": "k1", "namespace": "ns1", "supported_models": {"openai": ["gpt-4o"]}}]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewFileAuthorizer(path)
+	result, err := a.Authorize(context.Background(), "k1", "gpt-4o")
+	if err != nil || !result.Allowed || result.Namespace != "ns1" {
+		t.Fatalf("expected allowed in ns1, got %+v, %v", result, err)
+	}
+	result, _ = a.Authorize(context.Background(), "k1", "glm-4")
+	if result.Allowed {
+		t.Fatal("expected model not supported")
+	}
+	result, _ = a.Authorize(context.Background(), "k2", "gpt-4o")
+	if result.Allowed {
+		t.Fatal("expected invalid key")
+	}
+}
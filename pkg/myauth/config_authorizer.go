@@ -0,0 +1,104 @@
+package myauth
+
+import (
+	"context"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mykeys"
+	"time"
+)
+
+// ConfigAuthorizer 使用配置文件中的api_keys以及管理接口创建的API key鉴权，两者都没有时允许所有请求
+type ConfigAuthorizer struct{}
+
+func (a *ConfigAuthorizer) Authorize(ctx context.Context, apiKey string, model string) (*AuthResult, error) {
+	managed, found, err := lookupManagedKey(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return authorizeManagedKey(managed, apiKey, model), nil
+	}
+	if len(config.GSOAConf.APIKeys) == 0 && mykeys.IsEnabled() && mykeys.HasKeys() {
+		return &AuthResult{Message: "Forbidden: invalid API key"}, nil
+	}
+
+	isValid, msg := config.ValidateAPIKeyAndModel(apiKey, model)
+	if !isValid {
+		return &AuthResult{Message: msg}, nil
+	}
+
+	result := &AuthResult{Allowed: true}
+	if keyConfig, exists := config.GetAPIKeyConfig(apiKey); exists {
+		result.Namespace = keyConfig.Namespace
+		result.KeyConfig = keyConfig
+	}
+	return result, nil
+}
+
+// Authenticate 只校验API key，不检查模型；没有配置任何API key时允许所有请求
+func (a *ConfigAuthorizer) Authenticate(ctx context.Context, apiKey string) (*AuthResult, error) {
+	managed, found, err := lookupManagedKey(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if result := checkManagedKey(managed); result != nil {
+			return result, nil
+		}
+		keyConfig := managed.ToAPIKeyConfig(apiKey)
+		return &AuthResult{Allowed: true, Namespace: keyConfig.Namespace, KeyConfig: keyConfig}, nil
+	}
+	if len(config.GSOAConf.APIKeys) == 0 {
+		if mykeys.IsEnabled() && mykeys.HasKeys() {
+			return &AuthResult{Message: "Forbidden: invalid API key"}, nil
+		}
+		return &AuthResult{Allowed: true}, nil
+	}
+
+	keyConfig, exists := config.GetAPIKeyConfig(apiKey)
+	if !exists {
+		return &AuthResult{Message: "Forbidden: invalid API key"}, nil
+	}
+	return &AuthResult{Allowed: true, Namespace: keyConfig.Namespace, KeyConfig: keyConfig}, nil
+}
+
+func (a *ConfigAuthorizer) GetKeyConfig(apiKey string) (*config.APIKeyConfig, bool) {
+	if managed, found, _ := lookupManagedKey(apiKey); found {
+		if !managed.IsActive(time.Now()) {
+			return nil, false
+		}
+		return managed.ToAPIKeyConfig(apiKey), true
+	}
+	return config.GetAPIKeyConfig(apiKey)
+}
+
+// lookupManagedKey 开启API key管理时查询管理接口创建的key
+func lookupManagedKey(apiKey string) (*mykeys.ManagedKey, bool, error) {
+	if !mykeys.IsEnabled() || apiKey == "" {
+		return nil, false, nil
+	}
+	return mykeys.LookupKey(apiKey)
+}
+
+// checkManagedKey 检查管理接口创建的key是否被禁用或者已经过期，可以使用时返回nil
+func checkManagedKey(managed *mykeys.ManagedKey) *AuthResult {
+	if managed.Disabled {
+		return &AuthResult{Message: "Forbidden: API key is disabled"}
+	}
+	if !managed.IsActive(time.Now()) {
+		return &AuthResult{Message: "Forbidden: API key has expired"}
+	}
+	return nil
+}
+
+func authorizeManagedKey(managed *mykeys.ManagedKey, apiKey string, model string) *AuthResult {
+	if result := checkManagedKey(managed); result != nil {
+		return result
+	}
+
+	keyConfig := managed.ToAPIKeyConfig(apiKey)
+	if !config.IsModelSupportedByKey(keyConfig, model) {
+		return &AuthResult{Message: "Forbidden: model not supported"}
+	}
+	return &AuthResult{Allowed: true, Namespace: keyConfig.Namespace, KeyConfig: keyConfig}
+}
@@ -0,0 +1,124 @@
+package myauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mylog"
+	"simple-one-api/pkg/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKeysFile 鉴权文件的格式，与配置文件中的api_keys相同
+type apiKeysFile struct {
+	APIKeys []config.APIKeyConfig `json:"api_keys" yaml:"api_keys"`
+}
+
+// FileAuthorizer 从单独的json/yaml文件读取api_keys鉴权，文件修改后自动重新加载
+type FileAuthorizer struct {
+	path    string
+	mu      sync.RWMutex
+	modTime time.Time
+	keys    map[string]config.APIKeyConfig
+}
+
+func NewFileAuthorizer(path string) *FileAuthorizer {
+	if path != "" {
+		if absPath, err := utils.ResolveRelativePathToAbsolute(path); err == nil {
+			path = absPath
+		}
+	}
+	return &FileAuthorizer{path: path}
+}
+
+// reload 文件修改时间变化时重新读取文件
+func (a *FileAuthorizer) reload() error {
+	if a.path == "" {
+		return errors.New("auth file is not configured")
+	}
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+
+	a.mu.RLock()
+	unchanged := a.keys != nil && info.ModTime().Equal(a.modTime)
+	a.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+
+	var f apiKeysFile
+	switch strings.ToLower(filepath.Ext(a.path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &f)
+	default:
+		err = json.Unmarshal(data, &f)
+	}
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]config.APIKeyConfig, len(f.APIKeys))
+	for _, keyConfig := range f.APIKeys {
+		keys[keyConfig.APIKey] = keyConfig
+	}
+
+	a.mu.Lock()
+	a.keys = keys
+	a.modTime = info.ModTime()
+	a.mu.Unlock()
+
+	mylog.Logger.Info("FileAuthorizer loaded", zap.String("path", a.path), zap.Int("keys", len(keys)))
+	return nil
+}
+
+func (a *FileAuthorizer) Authorize(ctx context.Context, apiKey string, model string) (*AuthResult, error) {
+	if err := a.reload(); err != nil {
+		mylog.Logger.Error("FileAuthorizer reload error", zap.String("path", a.path), zap.Error(err))
+		// 已经加载过的情况下继续使用旧的内容
+		a.mu.RLock()
+		loaded := a.keys != nil
+		a.mu.RUnlock()
+		if !loaded {
+			return nil, err
+		}
+	}
+
+	a.mu.RLock()
+	keyConfig, exists := a.keys[apiKey]
+	a.mu.RUnlock()
+	if !exists {
+		return &AuthResult{Message: "Forbidden: invalid API key"}, nil
+	}
+	if !config.IsModelSupportedByKey(&keyConfig, model) {
+		return &AuthResult{Message: "Forbidden: model not supported"}, nil
+	}
+	return &AuthResult{Allowed: true, Namespace: keyConfig.Namespace, KeyConfig: &keyConfig}, nil
+}
+
+func (a *FileAuthorizer) GetKeyConfig(apiKey string) (*config.APIKeyConfig, bool) {
+	if err := a.reload(); err != nil {
+		mylog.Logger.Error("FileAuthorizer reload error", zap.String("path", a.path), zap.Error(err))
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	keyConfig, exists := a.keys[apiKey]
+	if !exists {
+		return nil, false
+	}
+	return &keyConfig, true
+}
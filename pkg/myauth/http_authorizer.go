@@ -0,0 +1,108 @@
+package myauth
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/patrickmn/go-cache"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/url"
+	"simple-one-api/pkg/mylog"
+	"strings"
+	"time"
+)
+
+// DefaultAuthURL 默认的鉴权回调地址，与原先写死的localhost:8808保持一致
+const DefaultAuthURL = "http://localhost:8808/{api_key}/{model_hex}"
+
+const defaultAuthTimeout = 5
+
+// HTTPAuthorizer 回调外部服务鉴权，URL中的{api_key}、{model}、{model_hex}会被替换，
+// 服务返回 {"namespace": "xxx"} 表示允许，返回 {"msg": "xxx"} 表示拒绝
+type HTTPAuthorizer struct {
+	url    string
+	client *http.Client
+	cache  *cache.Cache
+}
+
+func NewHTTPAuthorizer(authURL string, timeout int, cacheTTL int) *HTTPAuthorizer {
+	if authURL == "" {
+		authURL = DefaultAuthURL
+	}
+	if timeout <= 0 {
+		timeout = defaultAuthTimeout
+	}
+
+	a := &HTTPAuthorizer{
+		url:    authURL,
+		client: &http.Client{Timeout: time.Duration(timeout) * time.Second},
+	}
+	if cacheTTL > 0 {
+		a.cache = cache.New(time.Duration(cacheTTL)*time.Second, time.Duration(cacheTTL)*2*time.Second)
+	}
+	return a
+}
+
+func (a *HTTPAuthorizer) buildURL(apiKey string, model string) string {
+	r := strings.NewReplacer(
+		"{api_key}", url.PathEscape(apiKey),
+		"{model}", url.PathEscape(model),
+		"{model_hex}", hex.EncodeToString([]byte(model)),
+	)
+	return r.Replace(a.url)
+}
+
+func (a *HTTPAuthorizer) Authorize(ctx context.Context, apiKey string, model string) (*AuthResult, error) {
+	cacheKey := apiKey + "\x00" + model
+	if a.cache != nil {
+		if v, found := a.cache.Get(cacheKey); found {
+			return v.(*AuthResult), nil
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.buildURL(apiKey, model), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		mylog.Logger.Error("HTTPAuthorizer request error", zap.Error(err))
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		mylog.Logger.Error("HTTPAuthorizer read error", zap.Error(err))
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("auth service status code: %d", resp.StatusCode)
+	}
+
+	var m map[string]interface{}
+	if err = json.Unmarshal(data, &m); err != nil {
+		mylog.Logger.Error("HTTPAuthorizer unmarshal error", zap.Error(err), zap.String("data", string(data)))
+		return nil, err
+	}
+
+	result := &AuthResult{}
+	if ns, ok := m["namespace"].(string); ok && ns != "" {
+		result.Allowed = true
+		result.Namespace = ns
+	} else {
+		mylog.Logger.Error("HTTPAuthorizer get no namespace", zap.String("data", string(data)))
+		result.Message, _ = m["msg"].(string)
+		if result.Message == "" {
+			result.Message = "Forbidden: invalid API key or model"
+		}
+	}
+
+	if a.cache != nil {
+		a.cache.SetDefault(cacheKey, result)
+	}
+	return result, nil
+}
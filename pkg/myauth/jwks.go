@@ -0,0 +1,270 @@
+package myauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"simple-one-api/pkg/mylog"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJWKSRefreshInterval = 300
+	// jwksMinRefreshInterval 遇到未知kid时强制刷新的最小间隔，避免伪造的kid导致频繁请求JWKS
+	jwksMinRefreshInterval = 10 * time.Second
+)
+
+var ErrJWKSKeyNotFound = errors.New("jwks key not found")
+
+// jwk JWKS中的一个key，支持RSA、EC和oct(HMAC)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// jwksKey 解析后的key，Key为*rsa.PublicKey、*ecdsa.PublicKey或[]byte
+type jwksKey struct {
+	Kid string
+	Kty string
+	Key interface{}
+}
+
+// JWKSCache 从文件或URL读取JWKS，超过刷新间隔或遇到未知kid时重新读取，读取失败时继续使用之前的key；
+// 同一时间只有一个读取，读取时不持有锁，其他请求继续使用已有的key
+type JWKSCache struct {
+	file     string
+	url      string
+	refresh  time.Duration
+	client   *http.Client
+	mu       sync.Mutex
+	keys     []jwksKey
+	loadedAt time.Time
+	triedAt  time.Time
+	loading  chan struct{} // 正在读取时不为nil，读取完成后关闭
+}
+
+// NewJWKSCache refresh和timeout的单位为秒
+func NewJWKSCache(file string, url string, refresh int, timeout int) *JWKSCache {
+	if refresh <= 0 {
+		refresh = defaultJWKSRefreshInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultAuthTimeout
+	}
+	return &JWKSCache{
+		file:    file,
+		url:     url,
+		refresh: time.Duration(refresh) * time.Second,
+		client:  &http.Client{Timeout: time.Duration(timeout) * time.Second},
+	}
+}
+
+// GetKey 按kid和key类型查找key，kid为空时使用唯一一个该类型的key
+func (c *JWKSCache) GetKey(ctx context.Context, kid string, kty string) (interface{}, error) {
+	c.reload(ctx, false)
+	if key, ok := c.findKey(kid, kty); ok {
+		return key, nil
+	}
+
+	// 未知kid可能是身份服务轮换了key，立即刷新一次
+	c.reload(ctx, true)
+	if key, ok := c.findKey(kid, kty); ok {
+		return key, nil
+	}
+	return nil, ErrJWKSKeyNotFound
+}
+
+func (c *JWKSCache) findKey(kid string, kty string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var found interface{}
+	count := 0
+	for _, k := range c.keys {
+		if k.Kty != kty {
+			continue
+		}
+		if kid != "" && k.Kid == kid {
+			return k.Key, true
+		}
+		found = k.Key
+		count++
+	}
+	return found, kid == "" && count == 1
+}
+
+// reload 超过刷新间隔(force为true时不检查刷新间隔)且距上次读取超过jwksMinRefreshInterval时重新读取JWKS，
+// 已经有读取在进行时等待它完成；ctx结束时不再等待，读取继续在后台完成
+func (c *JWKSCache) reload(ctx context.Context, force bool) {
+	c.mu.Lock()
+	done := c.loading
+	if done == nil {
+		now := time.Now()
+		if now.Sub(c.triedAt) < jwksMinRefreshInterval || (!force && now.Sub(c.loadedAt) < c.refresh) {
+			c.mu.Unlock()
+			return
+		}
+		c.triedAt = now
+		done = make(chan struct{})
+		c.loading = done
+		go c.load(done)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// load 读取JWKS，成功时替换key。使用独立的ctx，发起读取的请求结束不影响等待同一次读取的其他请求，超时由client控制
+func (c *JWKSCache) load(done chan struct{}) {
+	data, err := c.read(context.Background())
+	var keys []jwksKey
+	if err == nil {
+		keys, err = parseJWKS(data)
+	}
+
+	if err != nil {
+		mylog.Logger.Error("load jwks error", zap.String("file", c.file), zap.String("url", c.url), zap.Error(err))
+	} else {
+		mylog.Logger.Info("jwks loaded", zap.String("file", c.file), zap.String("url", c.url), zap.Int("keys", len(keys)))
+	}
+
+	c.mu.Lock()
+	if err == nil {
+		c.keys = keys
+		c.loadedAt = time.Now()
+	}
+	c.loading = nil
+	c.mu.Unlock()
+	close(done)
+}
+
+func (c *JWKSCache) read(ctx context.Context) ([]byte, error) {
+	if c.file != "" {
+		return os.ReadFile(c.file)
+	}
+	if c.url == "" {
+		return nil, errors.New("jwks file or url is not configured")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func parseJWKS(data []byte) ([]jwksKey, error) {
+	var set jwkSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	var keys []jwksKey
+	for _, k := range set.Keys {
+		key, err := parseJWK(&k)
+		if err != nil {
+			mylog.Logger.Warn("skip invalid jwk", zap.String("kid", k.Kid), zap.String("kty", k.Kty), zap.Error(err))
+			continue
+		}
+		keys = append(keys, jwksKey{Kid: k.Kid, Kty: k.Kty, Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no valid key in jwks")
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func parseJWK(k *jwk) (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if n.Sign() == 0 || !e.IsInt64() || e.Int64() < 2 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid rsa key")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid ec key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return nil, err
+		}
+		if len(secret) == 0 {
+			return nil, errors.New("empty oct key")
+		}
+		return secret, nil
+	default:
+		return nil, fmt.Errorf("unsupported kty %s", k.Kty)
+	}
+}
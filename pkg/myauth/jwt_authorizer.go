@@ -0,0 +1,166 @@
+package myauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mylog"
+	"simple-one-api/pkg/utils"
+	"strings"
+	"time"
+)
+
+const (
+	defaultNamespaceClaim = "groups"
+	defaultModelsClaim    = "models"
+
+	// jwtKeyPrefix JWT的sub作为限流、额度等使用的key，加前缀避免与api_keys冲突
+	jwtKeyPrefix = "jwt:"
+)
+
+// jwtValidMethods 支持的签名算法
+var jwtValidMethods = []string{"RS256", "ES256", "HS256"}
+
+// JWTAuthorizer 校验请求中的JWT，sub作为调用方标识，namespace_claim(默认groups)映射为namespace，
+// models_claim(默认models)为可以调用的模型列表，token中没有该claim时不限制模型
+type JWTAuthorizer struct {
+	conf   config.JWTConf
+	jwks   *JWKSCache
+	parser *jwt.Parser
+}
+
+func NewJWTAuthorizer(conf config.JWTConf, timeout int) *JWTAuthorizer {
+	if conf.JWKSFile != "" {
+		if absPath, err := utils.ResolveRelativePathToAbsolute(conf.JWKSFile); err == nil {
+			conf.JWKSFile = absPath
+		}
+	}
+	if conf.NamespaceClaim == "" {
+		conf.NamespaceClaim = defaultNamespaceClaim
+	}
+	if conf.ModelsClaim == "" {
+		conf.ModelsClaim = defaultModelsClaim
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(jwtValidMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Duration(conf.Leeway) * time.Second),
+	}
+	if conf.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(conf.Issuer))
+	}
+	if conf.Audience != "" {
+		opts = append(opts, jwt.WithAudience(conf.Audience))
+	}
+
+	a := &JWTAuthorizer{
+		conf:   conf,
+		parser: jwt.NewParser(opts...),
+	}
+	if conf.JWKSFile != "" || conf.JWKSURL != "" {
+		a.jwks = NewJWKSCache(conf.JWKSFile, conf.JWKSURL, conf.RefreshInterval, timeout)
+	}
+	return a
+}
+
+// keyFunc 按token头中的alg和kid选择校验的key，HS256优先使用hmac_secret
+func (a *JWTAuthorizer) keyFunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		var kty string
+		switch token.Method.Alg() {
+		case "RS256":
+			kty = "RSA"
+		case "ES256":
+			kty = "EC"
+		case "HS256":
+			if a.conf.HMACSecret != "" {
+				return []byte(a.conf.HMACSecret), nil
+			}
+			kty = "oct"
+		default:
+			return nil, fmt.Errorf("unsupported alg %s", token.Method.Alg())
+		}
+
+		if a.jwks == nil {
+			return nil, errors.New("jwks is not configured")
+		}
+		kid, _ := token.Header["kid"].(string)
+		return a.jwks.GetKey(ctx, kid, kty)
+	}
+}
+
+// parseClaims 校验签名、过期时间、issuer和audience；sub作为调用方的标识，限流和额度都按sub统计，不能为空
+func (a *JWTAuthorizer) parseClaims(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(tokenString, claims, a.keyFunc(ctx)); err != nil {
+		return nil, err
+	}
+	if sub, _ := claims.GetSubject(); sub == "" {
+		return nil, errors.New("token has no sub claim")
+	}
+	return claims, nil
+}
+
+// claimStrings 读取字符串或字符串数组类型的claim，字符串按空格或逗号分隔
+func claimStrings(claims jwt.MapClaims, name string) ([]string, bool) {
+	v, exists := claims[name]
+	if !exists {
+		return nil, false
+	}
+
+	var values []string
+	switch t := v.(type) {
+	case string:
+		values = strings.FieldsFunc(t, func(r rune) bool { return r == ' ' || r == ',' })
+	case []interface{}:
+		for _, item := range t {
+			if s, ok := item.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+	}
+	return values, true
+}
+
+// toKeyConfig 把claims转换为限流、额度和模型校验使用的配置
+func (a *JWTAuthorizer) toKeyConfig(claims jwt.MapClaims) *config.APIKeyConfig {
+	sub, _ := claims.GetSubject()
+	keyConfig := &config.APIKeyConfig{
+		APIKey:          jwtKeyPrefix + sub,
+		SupportedModels: map[string][]string{"jwt": {"*"}},
+	}
+
+	if namespaces, _ := claimStrings(claims, a.conf.NamespaceClaim); len(namespaces) > 0 {
+		keyConfig.Namespace = namespaces[0]
+	}
+	if models, exists := claimStrings(claims, a.conf.ModelsClaim); exists {
+		keyConfig.SupportedModels = map[string][]string{"jwt": models}
+	}
+	return keyConfig
+}
+
+func (a *JWTAuthorizer) Authorize(ctx context.Context, apiKey string, model string) (*AuthResult, error) {
+	claims, err := a.parseClaims(ctx, apiKey)
+	if err != nil {
+		mylog.Logger.Warn("JWTAuthorizer|invalid token", zap.Error(err))
+		return &AuthResult{Message: "Forbidden: invalid token"}, nil
+	}
+
+	keyConfig := a.toKeyConfig(claims)
+	if !config.IsModelSupportedByKey(keyConfig, model) {
+		return &AuthResult{Message: "Forbidden: model not supported"}, nil
+	}
+	return &AuthResult{Allowed: true, Namespace: keyConfig.Namespace, KeyConfig: keyConfig}, nil
+}
+
+func (a *JWTAuthorizer) GetKeyConfig(apiKey string) (*config.APIKeyConfig, bool) {
+	claims, err := a.parseClaims(context.Background(), apiKey)
+	if err != nil {
+		return nil, false
+	}
+	return a.toKeyConfig(claims), true
+}
@@ -0,0 +1,151 @@
+package myauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mylog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func b64(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token error: %v", err)
+	}
+	return s
+}
+
+func TestJWTAuthorizer(t *testing.T) {
+	mylog.InitLog("prod")
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	hmacKey := []byte("local-test-secret")
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "n": b64(rsaKey.N), "e": b64(big.NewInt(int64(rsaKey.E)))},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X), "y": b64(ecKey.Y)},
+			{"kty": "oct", "kid": "hs-1", "k": base64.RawURLEncoding.EncodeToString(hmacKey)},
+		},
+	}
+	data, _ := json.Marshal(jwks)
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(jwksFile, data, 0644)
+
+	a := NewJWTAuthorizer(config.JWTConf{JWKSFile: jwksFile, Issuer: "https://idp.example.com", Audience: "simple-one-api"}, 1)
+	exp := time.Now().Add(time.Hour).Unix()
+	claims := func(extra jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{"iss": "https://idp.example.com", "aud": "simple-one-api", "sub": "alice", "exp": exp}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	cases := []struct {
+		name      string
+		token     string
+		model     string
+		allowed   bool
+		namespace string
+	}{
+		{"rs256", signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(jwt.MapClaims{"groups": []string{"team-a", "team-b"}})), "gpt-4o", true, "team-a"},
+		{"es256 models", signToken(t, jwt.SigningMethodES256, "ec-1", ecKey, claims(jwt.MapClaims{"models": []string{"glm-4"}})), "glm-4", true, ""},
+		{"model not allowed", signToken(t, jwt.SigningMethodES256, "ec-1", ecKey, claims(jwt.MapClaims{"models": "glm-4"})), "gpt-4o", false, ""},
+		{"hs256", signToken(t, jwt.SigningMethodHS256, "hs-1", hmacKey, claims(nil)), "gpt-4o", true, ""},
+		{"wrong audience", signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(jwt.MapClaims{"aud": "other"})), "gpt-4o", false, ""},
+		{"expired", signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})), "gpt-4o", false, ""},
+		{"wrong key", signToken(t, jwt.SigningMethodHS256, "hs-1", []byte("other"), claims(nil)), "gpt-4o", false, ""},
+		{"empty sub", signToken(t, jwt.SigningMethodHS256, "hs-1", hmacKey, claims(jwt.MapClaims{"sub": ""})), "gpt-4o", false, ""},
+		{"missing sub", signToken(t, jwt.SigningMethodHS256, "hs-1", hmacKey, jwt.MapClaims{"iss": "https://idp.example.com", "aud": "simple-one-api", "exp": exp}), "gpt-4o", false, ""},
+		{"not a jwt", "sk-plain-api-key", "gpt-4o", false, ""},
+	}
+	for _, tc := range cases {
+		result, err := a.Authorize(context.Background(), tc.token, tc.model)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if result.Allowed != tc.allowed || result.Namespace != tc.namespace {
+			t.Fatalf("%s: unexpected result: %+v", tc.name, result)
+		}
+		if result.Allowed && result.KeyConfig.APIKey != "jwt:alice" {
+			t.Fatalf("%s: unexpected key: %s", tc.name, result.KeyConfig.APIKey)
+		}
+	}
+
+	// 从URL读取JWKS
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	a = NewJWTAuthorizer(config.JWTConf{JWKSURL: srv.URL}, 1)
+	token := signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, jwt.MapClaims{"sub": "bob", "exp": exp})
+	if result, _ := a.Authorize(context.Background(), token, "gpt-4o"); !result.Allowed {
+		t.Fatalf("expected allowed with jwks url, got %+v", result)
+	}
+}
+
+func TestJWKSCacheSingleFetch(t *testing.T) {
+	mylog.InitLog("prod")
+
+	hmacKey := []byte("local-test-secret")
+	data, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{"kty": "oct", "kid": "hs-1", "k": base64.RawURLEncoding.EncodeToString(hmacKey)}},
+	})
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(200 * time.Millisecond)
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	cache := NewJWKSCache("", srv.URL, 0, 5)
+
+	// 第一个请求在读取完成前结束，不影响读取本身
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := cache.GetKey(ctx, "hs-1", "oct"); err != ErrJWKSKeyNotFound {
+		t.Fatalf("expected key not found before fetch completes, got %v", err)
+	}
+
+	// 并发的请求等待同一次读取
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.GetKey(context.Background(), "hs-1", "oct"); err != nil {
+				t.Errorf("expected key, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("expected a single jwks fetch, got %d", n)
+	}
+}
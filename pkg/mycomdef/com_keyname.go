@@ -7,6 +7,8 @@ const KEYNAME_QPS = "qps"
 const KEYNAME_QPM = "qpm"
 const KEYNAME_RPM = "rpm"
 const KEYNAME_CONCURRENCY = "concurrency"
+const KEYNAME_TPM = "tpm"
+const KEYNAME_TPD = "tpd"
 
 const KEYNAME_FIRST = "first"
 const KEYNAME_RANDOM = "random"
@@ -14,3 +16,23 @@ const KEYNAME_RAND = "rand"
 const KEYNAME_ROUND_ROBIN = "round-robin"
 const KEYNAME_RR = "rr"
 const KEYNAME_HASH = "hash"
+const KEYNAME_WEIGHTED = "weighted"
+const KEYNAME_LEAST_LATENCY = "least_latency"
+const KEYNAME_LEAST_INFLIGHT = "least_inflight"
+const KEYNAME_CONSISTENT_HASH = "consistent_hash"
+
+// HEADER_SERVED_MODEL 响应头，表示实际响应请求的模型（发生降级时为降级后的模型）
+const HEADER_SERVED_MODEL = "X-SOA-Served-Model"
+
+// HEADER_TRAFFIC_SPLIT 响应头，表示按traffic_split分流后选中的模型
+const HEADER_TRAFFIC_SPLIT = "X-SOA-Traffic-Split"
+
+// OpenAI格式的限流响应头，客户端据此自行控制请求速度
+const (
+	HEADER_RATELIMIT_LIMIT_REQUESTS     = "X-Ratelimit-Limit-Requests"
+	HEADER_RATELIMIT_REMAINING_REQUESTS = "X-Ratelimit-Remaining-Requests"
+	HEADER_RATELIMIT_RESET_REQUESTS     = "X-Ratelimit-Reset-Requests"
+	HEADER_RATELIMIT_LIMIT_TOKENS       = "X-Ratelimit-Limit-Tokens"
+	HEADER_RATELIMIT_REMAINING_TOKENS   = "X-Ratelimit-Remaining-Tokens"
+	HEADER_RATELIMIT_RESET_TOKENS       = "X-Ratelimit-Reset-Tokens"
+)
@@ -3,46 +3,79 @@ package mycommon
 import (
 	"simple-one-api/pkg/config"
 	"simple-one-api/pkg/mycomdef"
-	"strconv"
+	"simple-one-api/pkg/utils"
 )
 
 // GetACredentials 根据模型名从ModelDetails中选择合适的凭证
 func GetACredentials(s *config.ModelDetails, model string) (map[string]interface{}, string) {
+	return GetACredentialsWithOptions(s, model, nil)
+}
+
+// GetACredentialsWithOptions 与GetACredentials相同，但跳过已经尝试过的凭证，consistent_hash策略下使用opts.HashKey
+func GetACredentialsWithOptions(s *config.ModelDetails, model string, opts *config.RouteOptions) (map[string]interface{}, string) {
+	if opts == nil {
+		opts = &config.RouteOptions{}
+	}
+	excluded := opts.Excluded
+
 	// 检查是否有多个凭据列表可用
 	var credID string
 	if s.CredentialList != nil && len(s.CredentialList) > 0 {
 		key := s.ServiceID + "credentials"
 
-		index := config.GetLBIndex(config.LoadBalancingStrategy, key, len(s.CredentialList))
-		credID = s.ServiceID + "_credentials_" + strconv.Itoa(index)
+		indices := make([]int, 0, len(s.CredentialList))
+		for i := range s.CredentialList {
+			credID := config.GetCredentialID(s, i)
+			if !excluded[credID] && config.IsCircuitAvailable(s, credID) {
+				indices = append(indices, i)
+			}
+		}
+		// 未尝试的凭证都已熔断时，忽略熔断状态
+		if len(indices) == 0 {
+			for i := range s.CredentialList {
+				if !excluded[config.GetCredentialID(s, i)] {
+					indices = append(indices, i)
+				}
+			}
+		}
+		// 全部尝试过时退化为在所有凭证中选择
+		if len(indices) == 0 {
+			for i := range s.CredentialList {
+				indices = append(indices, i)
+			}
+		}
+
+		candidates := make([]config.LBCandidate, len(indices))
+		for i, idx := range indices {
+			weight, _ := utils.GetFloat64FromMap(s.CredentialList[idx], config.KEYNAME_WEIGHT)
+			candidates[i] = config.LBCandidate{ID: config.GetCredentialID(s, idx), Weight: int(weight)}
+			if idx < len(s.CredentialHashNodes) {
+				candidates[i].Node = s.CredentialHashNodes[idx]
+			}
+		}
+
+		index := indices[config.GetLBIndexWithCandidates(config.GetLoadBalancingStrategy(s.ProviderNamespace), key, opts.HashKey, candidates)]
+		credID = config.GetCredentialID(s, index)
 		return s.CredentialList[index], credID
 	}
 	return s.Credentials, credID
 }
 
-func GetCredentialLimit(credentials map[string]interface{}) (limitType string, limitn float64, timeout int) {
-	// 假设'limit'键下是一个JSON表示的map
+// GetCredentialLimit 返回凭证中limit配置的所有限制，没有配置时各项为0
+func GetCredentialLimit(credentials map[string]interface{}) config.Limit {
 	limitData, ok := credentials["limit"].(map[string]interface{})
 	if !ok {
-		return "", 0, 0 // 没有找到或类型不匹配
-	}
-
-	if to, ok := limitData["timeout"].(int); ok {
-		timeout = to
-	}
-	// 按优先级查找限制值：qps, qpm, rpm, concurrency
-	if qps, ok := limitData[mycomdef.KEYNAME_QPS].(float64); ok {
-		return mycomdef.KEYNAME_QPS, qps, timeout
-	}
-	if qpm, ok := limitData[mycomdef.KEYNAME_QPM].(float64); ok {
-		return mycomdef.KEYNAME_QPM, qpm, timeout
-	}
-	if rpm, ok := limitData[mycomdef.KEYNAME_RPM].(float64); ok {
-		return mycomdef.KEYNAME_QPM, rpm, timeout
-	}
-	if concurrency, ok := limitData[mycomdef.KEYNAME_CONCURRENCY].(float64); ok {
-		return mycomdef.KEYNAME_CONCURRENCY, concurrency, timeout
+		return config.Limit{}
 	}
 
-	return "", 0, 0 // 默认返回
+	var limit config.Limit
+	limit.QPS, _ = utils.GetFloat64FromMap(limitData, mycomdef.KEYNAME_QPS)
+	limit.QPM, _ = utils.GetFloat64FromMap(limitData, mycomdef.KEYNAME_QPM)
+	limit.RPM, _ = utils.GetFloat64FromMap(limitData, mycomdef.KEYNAME_RPM)
+	limit.Concurrency, _ = utils.GetFloat64FromMap(limitData, mycomdef.KEYNAME_CONCURRENCY)
+	limit.TPM, _ = utils.GetFloat64FromMap(limitData, mycomdef.KEYNAME_TPM)
+	limit.TPD, _ = utils.GetFloat64FromMap(limitData, mycomdef.KEYNAME_TPD)
+	timeout, _ := utils.GetFloat64FromMap(limitData, "timeout")
+	limit.Timeout = int(timeout)
+	return limit
 }
@@ -3,6 +3,7 @@ package mycommon
 import (
 	"errors"
 	"fmt"
+	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"io"
 	"net/http"
@@ -33,3 +34,17 @@ func CheckStatusCode(resp *http.Response) error {
 	}
 	return nil
 }
+
+// SendOpenAIErrorResponse 返回OpenAI格式的错误响应
+func SendOpenAIErrorResponse(c *gin.Context, status int, message string, errType string, code string) {
+	errDetail := gin.H{
+		"message": message,
+		"type":    errType,
+		"param":   nil,
+		"code":    nil,
+	}
+	if code != "" {
+		errDetail["code"] = code
+	}
+	c.JSON(status, gin.H{"error": errDetail})
+}
@@ -0,0 +1,36 @@
+package mycommon
+
+import (
+	"github.com/sashabaranov/go-openai"
+	"simple-one-api/pkg/config"
+)
+
+// HasImageContent 判断消息中是否包含图片
+func HasImageContent(oaiReqMessage []openai.ChatCompletionMessage) bool {
+	for _, msg := range oaiReqMessage {
+		for _, part := range msg.MultiContent {
+			if part.Type == openai.ChatMessagePartTypeImageURL && part.ImageURL != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetRequestFeatures 解析请求中用到的需要服务支持的能力
+func GetRequestFeatures(oaiReq *openai.ChatCompletionRequest) *config.RequestFeatures {
+	features := &config.RequestFeatures{
+		Vision:        HasImageContent(oaiReq.Messages),
+		Tools:         len(oaiReq.Tools) > 0 || len(oaiReq.Functions) > 0,
+		Reasoning:     oaiReq.ReasoningEffort != "",
+		ContextTokens: EstimateChatCompletionTokens(oaiReq),
+	}
+
+	if oaiReq.ResponseFormat != nil {
+		switch oaiReq.ResponseFormat.Type {
+		case openai.ChatCompletionResponseFormatTypeJSONObject, openai.ChatCompletionResponseFormatTypeJSONSchema:
+			features.JSONSchema = true
+		}
+	}
+	return features
+}
@@ -0,0 +1,145 @@
+package mycommon
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"net/netip"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mylog"
+	"simple-one-api/pkg/utils"
+	"strings"
+	"sync"
+)
+
+type clientIPKey struct{}
+
+// ipPrefixCache 解析后的IP/CIDR，解析失败的缓存为无效的Prefix
+var ipPrefixCache sync.Map
+
+func parseIPPrefix(s string) netip.Prefix {
+	if v, ok := ipPrefixCache.Load(s); ok {
+		return v.(netip.Prefix)
+	}
+
+	var prefix netip.Prefix
+	if strings.Contains(s, "/") {
+		if p, err := netip.ParsePrefix(s); err == nil {
+			prefix = p.Masked()
+		}
+	} else if addr, err := netip.ParseAddr(s); err == nil {
+		addr = addr.Unmap()
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	if !prefix.IsValid() {
+		mylog.Logger.Error("invalid ip or cidr, ignored", zap.String("value", s))
+	}
+	ipPrefixCache.Store(s, prefix)
+	return prefix
+}
+
+// matchIPList 判断IP是否在IP/CIDR列表中
+func matchIPList(list []string, ip netip.Addr) bool {
+	for _, s := range list {
+		if prefix := parseIPPrefix(strings.TrimSpace(s)); prefix.IsValid() && prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsIPAllowed 按IP过滤配置判断是否允许，deny优先，allow不为空时只允许列表中的地址
+func IsIPAllowed(filter *config.IPFilterConf, ip netip.Addr) bool {
+	if len(filter.Allow) == 0 && len(filter.Deny) == 0 {
+		return true
+	}
+	if !ip.IsValid() {
+		return false
+	}
+	ip = ip.Unmap()
+	if matchIPList(filter.Deny, ip) {
+		return false
+	}
+	return len(filter.Allow) == 0 || matchIPList(filter.Allow, ip)
+}
+
+func parseAddr(s string) netip.Addr {
+	addr, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// GetClientIP 返回请求的来源IP，只有直接连接的地址在trusted_proxies中时才使用X-Forwarded-For和X-Real-IP：
+// X-Forwarded-For从右向左跳过可信代理，第一个不可信的地址即为客户端地址
+func GetClientIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remoteIP := parseAddr(host)
+
+	var trustedProxies []string
+	if config.GSOAConf != nil {
+		trustedProxies = config.GSOAConf.TrustedProxies
+	}
+	if !remoteIP.IsValid() || !matchIPList(trustedProxies, remoteIP) {
+		return remoteIP
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		clientIP := remoteIP
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := parseAddr(hops[i])
+			if !ip.IsValid() {
+				break
+			}
+			clientIP = ip
+			if !matchIPList(trustedProxies, ip) {
+				break
+			}
+		}
+		return clientIP
+	}
+
+	if ip := parseAddr(r.Header.Get("X-Real-IP")); ip.IsValid() {
+		return ip
+	}
+	return remoteIP
+}
+
+// ClientIPFromContext 返回IPFilterMiddleware保存在请求context中的来源IP
+func ClientIPFromContext(ctx context.Context) netip.Addr {
+	ip, _ := ctx.Value(clientIPKey{}).(netip.Addr)
+	return ip
+}
+
+func ipForbiddenMessage(ip netip.Addr) string {
+	return "Access denied for IP address " + ip.String() + "."
+}
+
+// SendIPForbiddenResponse 返回OpenAI格式的403错误
+func SendIPForbiddenResponse(c *gin.Context, ip netip.Addr) {
+	SendOpenAIErrorResponse(c, http.StatusForbidden, ipForbiddenMessage(ip), "invalid_request_error", "ip_not_allowed")
+}
+
+// IPFilterMiddleware 在路由前解析来源IP并检查全局的ip_filter，来源IP保存到请求context中，供API key的ip_filter使用
+func IPFilterMiddleware(c *gin.Context) {
+	ip := GetClientIP(c.Request)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), clientIPKey{}, ip))
+
+	if config.GSOAConf != nil && !IsIPAllowed(&config.GSOAConf.IPFilter, ip) {
+		mylog.Logger.Warn("ip denied by global ip_filter",
+			zap.String("apikey", utils.GetAPIKeyFromRequest(c)),
+			zap.String("ip", ip.String()),
+			zap.String("path", c.Request.URL.Path))
+		SendIPForbiddenResponse(c, ip)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
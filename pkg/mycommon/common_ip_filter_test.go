@@ -0,0 +1,71 @@
+package mycommon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mylog"
+	"testing"
+)
+
+func TestIsIPAllowed(t *testing.T) {
+	mylog.InitLog("prod")
+
+	filter := &config.IPFilterConf{
+		Allow: []string{"10.0.0.0/8", "192.168.1.10", "2001:db8::/32"},
+		Deny:  []string{"10.1.0.0/16"},
+	}
+	for ip, expected := range map[string]bool{
+		"10.2.3.4":         true,
+		"10.1.2.3":         false,
+		"192.168.1.10":     true,
+		"192.168.1.11":     false,
+		"::ffff:10.2.3.4":  true,
+		"2001:db8::1":      true,
+		"2001:db9::1":      false,
+		"not-an-ip":        false,
+		"172.16.0.1":       false,
+		"::ffff:10.1.0.10": false,
+	} {
+		if IsIPAllowed(filter, parseAddr(ip)) != expected {
+			t.Fatalf("%s: expected allowed=%v", ip, expected)
+		}
+	}
+	if !IsIPAllowed(&config.IPFilterConf{}, netip.Addr{}) {
+		t.Fatalf("empty filter should allow all")
+	}
+}
+
+func TestGetClientIP(t *testing.T) {
+	mylog.InitLog("prod")
+	config.GSOAConf = &config.Configuration{TrustedProxies: []string{"127.0.0.1", "10.0.0.0/8"}}
+	defer func() { config.GSOAConf = nil }()
+
+	cases := []struct {
+		remote   string
+		xff      string
+		realIP   string
+		expected string
+	}{
+		{"203.0.113.5:1234", "1.2.3.4", "", "203.0.113.5"},
+		{"127.0.0.1:1234", "1.2.3.4", "", "1.2.3.4"},
+		{"127.0.0.1:1234", "6.6.6.6, 1.2.3.4, 10.0.0.2", "", "1.2.3.4"},
+		{"127.0.0.1:1234", "", "5.6.7.8", "5.6.7.8"},
+		{"127.0.0.1:1234", "garbage", "", "127.0.0.1"},
+		{"[::1]:1234", "1.2.3.4", "", "::1"},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			r.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if tc.realIP != "" {
+			r.Header.Set("X-Real-IP", tc.realIP)
+		}
+		if ip := GetClientIP(r); ip.String() != tc.expected {
+			t.Fatalf("%s %q: expected %s, got %s", tc.remote, tc.xff, tc.expected, ip)
+		}
+	}
+}
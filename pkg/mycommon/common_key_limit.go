@@ -0,0 +1,107 @@
+package mycommon
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mylimiter"
+	"simple-one-api/pkg/mylog"
+	"simple-one-api/pkg/myquota"
+	"sync"
+)
+
+// KeyGrant 鉴权通过后持有的API key限流许可和tpm/tpd预留
+type KeyGrant struct {
+	keyConfig *config.APIKeyConfig
+	model     string
+	release   func()
+	tokens    *mylimiter.TokenReservation
+	once      sync.Once
+}
+
+// HasTokenLimit 判断是否预留了tpm/tpd，需要统计实际用量结算
+func (g *KeyGrant) HasTokenLimit() bool {
+	return g.tokens != nil
+}
+
+// Done 请求结束时调用：按usage中累计的实际用量结算预留的token并计入API key的token预算，然后释放限流；
+// usage为nil时归还全部预留。可以重复调用，只有第一次生效
+func (g *KeyGrant) Done(usage *ChatUsage) {
+	g.once.Do(func() {
+		var promptTokens, completionTokens int64
+		if usage != nil {
+			promptTokens, completionTokens = usage.Tokens()
+		}
+		g.tokens.Settle(promptTokens + completionTokens)
+		myquota.RecordUsage(g.keyConfig, g.model, promptTokens, completionTokens)
+		g.release()
+	})
+}
+
+// AcquireAPIKeyLimit 在路由前检查API key的qps/rpm/并发限制，通过时返回释放函数，请求结束后必须调用
+func AcquireAPIKeyLimit(keyConfig *config.APIKeyConfig, model string) (func(), error) {
+	if keyConfig == nil {
+		return func() {}, nil
+	}
+
+	key, limit := config.GetAPIKeyLimit(keyConfig, model)
+	rpm := limit.RPM
+	if rpm <= 0 {
+		rpm = limit.QPM
+	}
+	if limit.QPS <= 0 && rpm <= 0 && limit.Concurrency <= 0 {
+		return func() {}, nil
+	}
+
+	release, err := mylimiter.GetKeyLimiter(key, limit.QPS, rpm, limit.Concurrency).TryAcquire()
+	if err != nil {
+		mylog.Logger.Warn("API key rate limit exceeded",
+			zap.String("limiter_key", key),
+			zap.String("model", model),
+			zap.Error(err))
+		return nil, err
+	}
+	return release, nil
+}
+
+// ReserveAPIKeyTokens 按API key的tpm/tpd预留请求估算的token数，不足时返回*mylimiter.RateLimitError；
+// 没有配置时返回nil，请求结束后需要调用Settle按实际用量结算
+func ReserveAPIKeyTokens(keyConfig *config.APIKeyConfig, model string, tokens int64) (*mylimiter.TokenReservation, error) {
+	if keyConfig == nil {
+		return nil, nil
+	}
+
+	key, limit := config.GetAPIKeyLimit(keyConfig, model)
+	lim := mylimiter.GetAPIKeyTokenLimiter(key, limit.TPM, limit.TPD)
+	if lim == nil {
+		return nil, nil
+	}
+
+	reservation, err := mylimiter.TryReserveTokens(tokens, lim)
+	if err != nil {
+		mylog.Logger.Warn("API key token limit exceeded",
+			zap.String("limiter_key", key),
+			zap.String("model", model),
+			zap.Int64("tokens", tokens),
+			zap.Error(err))
+		return nil, err
+	}
+	return reservation, nil
+}
+
+// SendRateLimitResponse 返回OpenAI格式的429错误，并通过Retry-After告知客户端需要等待的秒数
+func SendRateLimitResponse(c *gin.Context, err error) {
+	var rlErr *mylimiter.RateLimitError
+	if !errors.As(err, &rlErr) {
+		SendOpenAIErrorResponse(c, http.StatusTooManyRequests, err.Error(), "requests", "rate_limit_exceeded")
+		return
+	}
+
+	retryAfter := setRetryAfter(c, rlErr.RetryAfter)
+
+	msg := fmt.Sprintf("Rate limit reached for API key on %s. Please try again in %ds.", rlErr.Limit, retryAfter)
+	SendOpenAIErrorResponse(c, http.StatusTooManyRequests, msg, "requests", "rate_limit_exceeded")
+}
@@ -2,31 +2,32 @@ package mycommon
 
 import (
 	"simple-one-api/pkg/config"
-	"simple-one-api/pkg/mycomdef"
+	"simple-one-api/pkg/mylimiter"
+	"time"
 )
 
-// 通用的限流器详情获取函数
-func getLimitDetails(limit config.Limit) (string, float64, int) {
-	switch {
-	case limit.QPS > 0:
-		return mycomdef.KEYNAME_QPS, limit.QPS, limit.Timeout
-	case limit.QPM > 0:
-		return mycomdef.KEYNAME_QPM, limit.QPM, limit.Timeout
-	case limit.RPM > 0:
-		return mycomdef.KEYNAME_QPM, limit.RPM, limit.Timeout
-	case limit.Concurrency > 0:
-		return mycomdef.KEYNAME_CONCURRENCY, limit.Concurrency, limit.Timeout
-	default:
-		return "", 0, 0 // 默认返回
+// GetLimits 将配置的限制转换为限流器的限制，所有配置的限制同时生效；qpm和rpm同时配置时使用较小的值
+func GetLimits(limit config.Limit) mylimiter.Limits {
+	qpm := limit.QPM
+	if limit.RPM > 0 && (qpm <= 0 || limit.RPM < qpm) {
+		qpm = limit.RPM
+	}
+	return mylimiter.Limits{
+		QPS:         limit.QPS,
+		QPM:         qpm,
+		Concurrency: limit.Concurrency,
+		TPM:         limit.TPM,
+		TPD:         limit.TPD,
 	}
 }
 
-// 获取服务模型的限流详情
-func GetServiceModelDetailsLimit(s *config.ModelDetails) (string, float64, int) {
-	return getLimitDetails(s.Limit)
-}
-
-// 获取服务限流器的限流详情
-func GetServiceLimiterDetailsLimit(l *config.Limit) (string, float64, int) {
-	return getLimitDetails(*l)
+// GetAdaptiveConf 将服务配置的adaptive_concurrency转换为自适应并发的参数
+func GetAdaptiveConf(c config.AdaptiveConcurrencyConf) mylimiter.AdaptiveConf {
+	return mylimiter.AdaptiveConf{
+		Min:            c.Min,
+		Max:            c.Max,
+		Initial:        c.Initial,
+		DecreaseFactor: c.DecreaseFactor,
+		MaxPause:       time.Duration(c.MaxPause) * time.Second,
+	}
 }
@@ -0,0 +1,42 @@
+package mycommon
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mylimiter"
+	"simple-one-api/pkg/mylog"
+)
+
+// PriorityHeader 客户端指定请求优先级的header，只能降低不能高于API key配置的优先级
+const PriorityHeader = "X-Priority"
+
+// GetRequestClass 计算请求在限流队列中的类别：优先级取API key配置的priority(默认normal)，
+// header指定的更低时使用header的；同一API key的请求按queue_weight与其他key公平分配
+func GetRequestClass(keyConfig *config.APIKeyConfig, apiKey string, header string) mylimiter.RequestClass {
+	class := mylimiter.RequestClass{Priority: mylimiter.PriorityNormal, Key: apiKey, Weight: 1}
+	if keyConfig != nil {
+		if keyConfig.Priority != "" {
+			p, ok := mylimiter.ParsePriority(keyConfig.Priority)
+			if !ok {
+				mylog.Logger.Warn("invalid api key priority", zap.String("priority", keyConfig.Priority))
+			}
+			class.Priority = p
+		}
+		if keyConfig.QueueWeight > 0 {
+			class.Weight = keyConfig.QueueWeight
+		}
+	}
+	if header != "" {
+		if p, ok := mylimiter.ParsePriority(header); ok && p < class.Priority {
+			class.Priority = p
+		}
+	}
+	return class
+}
+
+// setRequestClass 把请求的类别保存到请求的上下文中，等待服务和凭证的限流时按类别排队
+func setRequestClass(c *gin.Context, keyConfig *config.APIKeyConfig, apiKey string) {
+	class := GetRequestClass(keyConfig, apiKey, c.GetHeader(PriorityHeader))
+	c.Request = c.Request.WithContext(mylimiter.WithRequestClass(c.Request.Context(), class))
+}
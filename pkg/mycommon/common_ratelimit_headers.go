@@ -0,0 +1,115 @@
+package mycommon
+
+import (
+	"github.com/gin-gonic/gin"
+	"math"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mycomdef"
+	"simple-one-api/pkg/mylimiter"
+	"strconv"
+	"time"
+)
+
+// 保存在gin.Context中的限流状态
+const (
+	ctxKeyAPIKeyRateLimit = "soa_apikey_ratelimit"
+	ctxKeyRateLimit       = "soa_ratelimit"
+)
+
+// rateLimitHeaderStatus 请求数和token数两类限制中剩余最少的状态
+type rateLimitHeaderStatus struct {
+	requests mylimiter.RateLimitStatus
+	tokens   mylimiter.RateLimitStatus
+}
+
+func (s rateLimitHeaderStatus) tighter(other rateLimitHeaderStatus) rateLimitHeaderStatus {
+	return rateLimitHeaderStatus{
+		requests: s.requests.Tighter(other.requests),
+		tokens:   s.tokens.Tighter(other.tokens),
+	}
+}
+
+// SetAPIKeyRateLimitHeaders 按API key的rpm和tpm设置x-ratelimit-*响应头，之后SetRateLimitHeaders与服务的限制合并
+func SetAPIKeyRateLimitHeaders(c *gin.Context, keyConfig *config.APIKeyConfig, model string) {
+	if keyConfig == nil {
+		return
+	}
+
+	key, limit := config.GetAPIKeyLimit(keyConfig, model)
+	rpm := limit.RPM
+	if rpm <= 0 {
+		rpm = limit.QPM
+	}
+	ctx := c.Request.Context()
+	var st rateLimitHeaderStatus
+	if rpm > 0 {
+		st.requests, _ = mylimiter.GetKeyLimiter(key, limit.QPS, rpm, limit.Concurrency).RequestStatus(ctx)
+	}
+	st.tokens, _ = mylimiter.GetAPIKeyTokenLimiter(key, limit.TPM, limit.TPD).Status(ctx)
+
+	c.Set(ctxKeyAPIKeyRateLimit, st)
+	writeRateLimitHeaders(c, st)
+}
+
+// SetRateLimitHeaders 合并API key和服务、凭证等限流器的状态，按剩余最少的限制设置x-ratelimit-*响应头；
+// 切换服务重试时使用新服务的限流器重新设置
+func SetRateLimitHeaders(c *gin.Context, limiters ...*mylimiter.Limiter) {
+	st, _ := c.Value(ctxKeyAPIKeyRateLimit).(rateLimitHeaderStatus)
+	ctx := c.Request.Context()
+	for _, l := range limiters {
+		var ls rateLimitHeaderStatus
+		ls.requests, _ = l.RequestStatus(ctx)
+		ls.tokens, _ = l.TokenStatus(ctx)
+		st = st.tighter(ls)
+	}
+	writeRateLimitHeaders(c, st)
+}
+
+// writeRateLimitHeaders 设置响应头，只设置配置了限制的一类
+func writeRateLimitHeaders(c *gin.Context, st rateLimitHeaderStatus) {
+	c.Set(ctxKeyRateLimit, st)
+	if st.requests.Limit > 0 {
+		c.Header(mycomdef.HEADER_RATELIMIT_LIMIT_REQUESTS, strconv.FormatInt(st.requests.Limit, 10))
+		c.Header(mycomdef.HEADER_RATELIMIT_REMAINING_REQUESTS, strconv.FormatInt(st.requests.Remaining, 10))
+		c.Header(mycomdef.HEADER_RATELIMIT_RESET_REQUESTS, formatRateLimitReset(st.requests.Reset))
+	}
+	if st.tokens.Limit > 0 {
+		c.Header(mycomdef.HEADER_RATELIMIT_LIMIT_TOKENS, strconv.FormatInt(st.tokens.Limit, 10))
+		c.Header(mycomdef.HEADER_RATELIMIT_REMAINING_TOKENS, strconv.FormatInt(st.tokens.Remaining, 10))
+		c.Header(mycomdef.HEADER_RATELIMIT_RESET_TOKENS, formatRateLimitReset(st.tokens.Reset))
+	}
+}
+
+// formatRateLimitReset 与OpenAI相同的格式，例如"1s"、"6m0s"、"20ms"
+func formatRateLimitReset(d time.Duration) string {
+	if d <= 0 {
+		return "0s"
+	}
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(10 * time.Millisecond).String()
+}
+
+// setRetryAfter 设置Retry-After，不足1秒按1秒，返回设置的秒数
+func setRetryAfter(c *gin.Context, d time.Duration) int {
+	retryAfter := int(math.Ceil(d.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	return retryAfter
+}
+
+// SetRateLimitRetryAfter 服务的限流等待超时返回429时，按已经用尽的限制恢复的时间设置Retry-After，
+// 没有用尽的请求数或token数限制(如并发数已满)时为1秒
+func SetRateLimitRetryAfter(c *gin.Context) {
+	st, _ := c.Value(ctxKeyRateLimit).(rateLimitHeaderStatus)
+	var wait time.Duration
+	for _, s := range []mylimiter.RateLimitStatus{st.requests, st.tokens} {
+		if s.Limit > 0 && s.Remaining <= 0 && s.Reset > wait {
+			wait = s.Reset
+		}
+	}
+	setRetryAfter(c, wait)
+}
@@ -0,0 +1,57 @@
+package mycommon
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mycomdef"
+	"simple-one-api/pkg/mylimiter"
+	"simple-one-api/pkg/mylog"
+	"testing"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+	mylog.InitLog("prod")
+	gin.SetMode(gin.TestMode)
+
+	keyConfig := &config.APIKeyConfig{APIKey: "sk-headers", Limit: config.Limit{RPM: 10, TPM: 1000}}
+	release, err := AcquireAPIKeyLimit(keyConfig, "gpt-4o")
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	release()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	SetAPIKeyRateLimitHeaders(c, keyConfig, "gpt-4o")
+	if h := w.Header(); h.Get(mycomdef.HEADER_RATELIMIT_LIMIT_REQUESTS) != "10" || h.Get(mycomdef.HEADER_RATELIMIT_REMAINING_REQUESTS) != "9" ||
+		h.Get(mycomdef.HEADER_RATELIMIT_LIMIT_TOKENS) != "1000" || h.Get(mycomdef.HEADER_RATELIMIT_REMAINING_TOKENS) != "1000" {
+		t.Fatalf("unexpected api key headers %v", h)
+	}
+
+	// 服务的限制剩余更少时使用服务的
+	service := mylimiter.NewLimiter(mylimiter.Limits{QPM: 2})
+	permit, err := mylimiter.Acquire(context.Background(), 0, service)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	permit.Release()
+	SetRateLimitHeaders(c, service)
+	if h := w.Header(); h.Get(mycomdef.HEADER_RATELIMIT_LIMIT_REQUESTS) != "2" || h.Get(mycomdef.HEADER_RATELIMIT_REMAINING_REQUESTS) != "1" ||
+		h.Get(mycomdef.HEADER_RATELIMIT_LIMIT_TOKENS) != "1000" {
+		t.Fatalf("unexpected merged headers %v", h)
+	}
+
+	if permit, err = mylimiter.Acquire(context.Background(), 0, service); err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	permit.Release()
+	SetRateLimitHeaders(c, service)
+	SetRateLimitRetryAfter(c)
+	if h := w.Header(); h.Get(mycomdef.HEADER_RATELIMIT_REMAINING_REQUESTS) != "0" || h.Get("Retry-After") != "60" {
+		t.Fatalf("unexpected exhausted headers %v", h)
+	}
+}
@@ -0,0 +1,153 @@
+package mycommon
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/myauth"
+	"simple-one-api/pkg/mylimiter"
+	"simple-one-api/pkg/mylog"
+	"simple-one-api/pkg/myquota"
+)
+
+// RequestAuthError 鉴权流程中拒绝请求的原因，包含返回给客户端的HTTP状态码和OpenAI格式的错误信息
+type RequestAuthError struct {
+	Status  int
+	Message string
+	Type    string
+	Code    string
+	Err     error
+}
+
+func (e *RequestAuthError) Error() string {
+	return e.Message
+}
+
+func (e *RequestAuthError) Unwrap() error {
+	return e.Err
+}
+
+func newInvalidKeyError(msg string) *RequestAuthError {
+	return &RequestAuthError{Status: http.StatusUnauthorized, Message: msg, Type: "invalid_request_error", Code: "invalid_api_key"}
+}
+
+// AuthorizeModel 所有入口共用的鉴权流程：全局api_key、鉴权实现(包括模型白名单)、API key的ip_filter、限流、
+// 按估算的token数预留tpm/tpd以及token预算；通过时返回鉴权结果和KeyGrant，请求结束后必须调用KeyGrant.Done；
+// 拒绝时返回*RequestAuthError
+func AuthorizeModel(ctx context.Context, apiKey string, model string, estimatedTokens int64) (*myauth.AuthResult, *KeyGrant, error) {
+	if err := checkGlobalAPIKey(apiKey); err != nil {
+		return nil, nil, err
+	}
+
+	authResult, err := myauth.Authorize(ctx, apiKey, model)
+	if err = checkAuthResult(ctx, apiKey, model, authResult, err); err != nil {
+		return nil, nil, err
+	}
+
+	// 在路由前检查API key的限流
+	release, err := AcquireAPIKeyLimit(authResult.KeyConfig, model)
+	if err != nil {
+		return nil, nil, &RequestAuthError{Status: http.StatusTooManyRequests, Message: err.Error(), Type: "requests", Code: "rate_limit_exceeded", Err: err}
+	}
+	grant := &KeyGrant{keyConfig: authResult.KeyConfig, model: model, release: release}
+
+	// 预留tpm/tpd并检查API key的token预算，失败或panic时释放已经获取的限流
+	passed := false
+	defer func() {
+		if !passed {
+			grant.Done(nil)
+		}
+	}()
+	if grant.tokens, err = ReserveAPIKeyTokens(authResult.KeyConfig, model, estimatedTokens); err != nil {
+		return nil, nil, &RequestAuthError{Status: http.StatusTooManyRequests, Message: err.Error(), Type: "tokens", Code: "rate_limit_exceeded", Err: err}
+	}
+	if err = myquota.CheckQuota(authResult.KeyConfig, model); err != nil {
+		return nil, nil, &RequestAuthError{Status: http.StatusTooManyRequests, Message: err.Error(), Type: "insufficient_quota", Code: "insufficient_quota", Err: err}
+	}
+	passed = true
+	return authResult, grant, nil
+}
+
+// checkGlobalAPIKey 检查全局api_key；jwt、http鉴权方式自己校验请求中的凭证，不与全局api_key比较
+func checkGlobalAPIKey(apiKey string) error {
+	if config.APIKey == "" || config.APIKey == apiKey || myauth.ValidatesCredential() {
+		return nil
+	}
+	mylog.Logger.Error("key is not valid", zap.String("apikey", apiKey))
+	return newInvalidKeyError("key is not valid")
+}
+
+// checkAuthResult 检查鉴权实现的结果以及API key的ip_filter，来源IP由IPFilterMiddleware解析
+func checkAuthResult(ctx context.Context, apiKey string, model string, authResult *myauth.AuthResult, err error) error {
+	if err != nil {
+		mylog.Logger.Error("Authorize error", zap.Error(err))
+		return newInvalidKeyError("check token error")
+	}
+	if !authResult.Allowed {
+		mylog.Logger.Error("key not valid", zap.String("apikey", apiKey), zap.String("model", model), zap.String("msg", authResult.Message))
+		return newInvalidKeyError(authResult.Message)
+	}
+
+	if authResult.KeyConfig != nil {
+		ip := ClientIPFromContext(ctx)
+		if !IsIPAllowed(&authResult.KeyConfig.IPFilter, ip) {
+			mylog.Logger.Warn("ip denied by api key ip_filter", zap.String("apikey", apiKey), zap.String("ip", ip.String()))
+			return &RequestAuthError{Status: http.StatusForbidden, Message: ipForbiddenMessage(ip), Type: "invalid_request_error", Code: "ip_not_allowed"}
+		}
+	}
+	return nil
+}
+
+// AuthenticateRequest 只校验API key(全局api_key、鉴权实现和API key的ip_filter)，不检查模型、限流和预算，
+// 用于WebSocket在升级连接前拒绝无效的key；拒绝时返回OpenAI格式的错误响应并返回false
+func AuthenticateRequest(c *gin.Context, apiKey string) bool {
+	if err := checkGlobalAPIKey(apiKey); err != nil {
+		SendRequestAuthError(c, err)
+		return false
+	}
+
+	authResult, err := myauth.Authenticate(c.Request.Context(), apiKey)
+	if err = checkAuthResult(c.Request.Context(), apiKey, "", authResult, err); err != nil {
+		SendRequestAuthError(c, err)
+		return false
+	}
+	return true
+}
+
+// AuthorizeRequest 对HTTP请求执行AuthorizeModel，拒绝时返回OpenAI格式的错误响应并返回false；
+// 通过时把请求的优先级和排队权重保存到c.Request的上下文中；通过和限流时都按API key的限制设置x-ratelimit-*响应头
+func AuthorizeRequest(c *gin.Context, apiKey string, model string, estimatedTokens int64) (*myauth.AuthResult, *KeyGrant, bool) {
+	authResult, grant, err := AuthorizeModel(c.Request.Context(), apiKey, model, estimatedTokens)
+	if err != nil {
+		var rlErr *mylimiter.RateLimitError
+		if errors.As(err, &rlErr) {
+			if keyConfig, exists := myauth.LookupKeyConfig(apiKey); exists {
+				SetAPIKeyRateLimitHeaders(c, keyConfig, model)
+			}
+		}
+		SendRequestAuthError(c, err)
+		return nil, nil, false
+	}
+	setRequestClass(c, authResult.KeyConfig, apiKey)
+	SetAPIKeyRateLimitHeaders(c, authResult.KeyConfig, model)
+	return authResult, grant, true
+}
+
+// SendRequestAuthError 返回鉴权流程的错误，限流错误会带上Retry-After
+func SendRequestAuthError(c *gin.Context, err error) {
+	var rlErr *mylimiter.RateLimitError
+	if errors.As(err, &rlErr) {
+		SendRateLimitResponse(c, rlErr)
+		return
+	}
+
+	var authErr *RequestAuthError
+	if errors.As(err, &authErr) {
+		SendOpenAIErrorResponse(c, authErr.Status, authErr.Message, authErr.Type, authErr.Code)
+		return
+	}
+	SendOpenAIErrorResponse(c, http.StatusInternalServerError, err.Error(), "server_error", "")
+}
@@ -0,0 +1,165 @@
+package mycommon
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mylimiter"
+	"simple-one-api/pkg/mylog"
+	"testing"
+	"time"
+)
+
+func TestAuthorizeModel(t *testing.T) {
+	mylog.InitLog("prod")
+
+	confFile := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(confFile, []byte(`{
+		"api_keys": [
+			{"api_key": "sk-a", "supported_models": {"openai": ["gpt-4o"]}, "limit": {"concurrency": 1}},
+			{"api_key": "sk-ip", "supported_models": {"openai": ["*"]}, "ip_filter": {"allow": ["10.0.0.0/8"]}},
+			{"api_key": "sk-tpm", "supported_models": {"openai": ["*"]}, "limit": {"tpm": 100}}
+		]
+	}`), 0644)
+	if err := config.InitConfig(confFile); err != nil {
+		t.Fatalf("InitConfig error: %v", err)
+	}
+	defer func() { config.GSOAConf = nil }()
+
+	var authErr *RequestAuthError
+	if _, _, err := AuthorizeModel(context.Background(), "sk-bad", "gpt-4o", 0); !errors.As(err, &authErr) || authErr.Status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for invalid key, got %v", err)
+	}
+	if _, _, err := AuthorizeModel(context.Background(), "sk-a", "glm-4", 0); !errors.As(err, &authErr) || authErr.Status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for model not in allowlist, got %v", err)
+	}
+
+	_, grant, err := AuthorizeModel(context.Background(), "sk-a", "gpt-4o", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var rlErr *mylimiter.RateLimitError
+	if _, _, err = AuthorizeModel(context.Background(), "sk-a", "gpt-4o", 0); !errors.As(err, &rlErr) {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+	grant.Done(nil)
+	if _, grant, err = AuthorizeModel(context.Background(), "sk-a", "gpt-4o", 0); err != nil {
+		t.Fatalf("expected allowed after release, got %v", err)
+	}
+	grant.Done(nil)
+
+	ctx := context.WithValue(context.Background(), clientIPKey{}, netip.MustParseAddr("192.168.0.1"))
+	if _, _, err = AuthorizeModel(ctx, "sk-ip", "gpt-4o", 0); !errors.As(err, &authErr) || authErr.Status != http.StatusForbidden {
+		t.Fatalf("expected 403 for ip not in allowlist, got %v", err)
+	}
+	ctx = context.WithValue(context.Background(), clientIPKey{}, netip.MustParseAddr("10.1.2.3"))
+	if _, grant, err = AuthorizeModel(ctx, "sk-ip", "gpt-4o", 0); err != nil {
+		t.Fatalf("expected allowed ip, got %v", err)
+	}
+	grant.Done(nil)
+
+	// 所有入口都按估算的token数预留API key的tpm，超过时拒绝
+	if _, grant, err = AuthorizeModel(context.Background(), "sk-tpm", "gpt-4o", 80); err != nil {
+		t.Fatalf("expected tokens reserved, got %v", err)
+	}
+	if _, _, err = AuthorizeModel(context.Background(), "sk-tpm", "gpt-4o", 80); !errors.As(err, &authErr) || authErr.Type != "tokens" {
+		t.Fatalf("expected tpm exceeded, got %v", err)
+	}
+	grant.Done(nil)
+}
+
+func TestAuthenticateRequest(t *testing.T) {
+	mylog.InitLog("prod")
+	gin.SetMode(gin.TestMode)
+
+	confFile := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(confFile, []byte(`{
+		"api_keys": [{"api_key": "sk-a", "supported_models": {"openai": ["gpt-4o"]}}]
+	}`), 0644)
+	if err := config.InitConfig(confFile); err != nil {
+		t.Fatalf("InitConfig error: %v", err)
+	}
+	defer func() { config.GSOAConf = nil }()
+
+	// 只校验key，不检查模型
+	for key, expected := range map[string]int{"sk-bad": http.StatusUnauthorized, "": http.StatusUnauthorized, "sk-a": http.StatusOK} {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodGet, "/multimodelcall", nil)
+		ok := AuthenticateRequest(c, key)
+		if ok != (expected == http.StatusOK) || rec.Code != expected {
+			t.Fatalf("key %q: expected %d, got %v %d", key, expected, ok, rec.Code)
+		}
+	}
+}
+
+func TestAuthorizeJWTWithGlobalAPIKey(t *testing.T) {
+	mylog.InitLog("prod")
+	gin.SetMode(gin.TestMode)
+
+	confFile := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(confFile, []byte(`{
+		"api_key": "sk-global",
+		"auth": {"type": "jwt", "jwt": {"hmac_secret": "local-test-secret"}}
+	}`), 0644)
+	if err := config.InitConfig(confFile); err != nil {
+		t.Fatalf("InitConfig error: %v", err)
+	}
+	defer func() {
+		config.GSOAConf = nil
+		config.APIKey = ""
+	}()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}).
+		SignedString([]byte("local-test-secret"))
+	if err != nil {
+		t.Fatalf("sign token error: %v", err)
+	}
+
+	// jwt鉴权方式自己校验token，不与全局api_key比较
+	authResult, grant, err := AuthorizeModel(context.Background(), token, "gpt-4o", 0)
+	if err != nil || authResult.KeyConfig.APIKey != "jwt:alice" {
+		t.Fatalf("expected jwt allowed with global api_key set, got %v", err)
+	}
+	grant.Done(nil)
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/multimodelcall", nil)
+	if !AuthenticateRequest(c, token) {
+		t.Fatalf("expected jwt authenticated, got %d", rec.Code)
+	}
+
+	// 无效的token仍然被jwt鉴权拒绝
+	var authErr *RequestAuthError
+	if _, _, err = AuthorizeModel(context.Background(), "sk-global", "gpt-4o", 0); !errors.As(err, &authErr) || authErr.Status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for non-jwt key, got %v", err)
+	}
+}
+
+func TestGetRequestClass(t *testing.T) {
+	mylog.InitLog("prod")
+
+	keyConfig := &config.APIKeyConfig{Priority: "normal", QueueWeight: 2}
+	for header, expected := range map[string]int{
+		"":        mylimiter.PriorityNormal,
+		"low":     mylimiter.PriorityLow,
+		"high":    mylimiter.PriorityNormal,
+		"invalid": mylimiter.PriorityNormal,
+	} {
+		class := GetRequestClass(keyConfig, "sk-a", header)
+		if class.Priority != expected || class.Weight != 2 || class.Key != "sk-a" {
+			t.Fatalf("header %q: unexpected class %+v", header, class)
+		}
+	}
+	if class := GetRequestClass(nil, "sk-a", "high"); class.Priority != mylimiter.PriorityNormal || class.Weight != 1 {
+		t.Fatalf("unexpected default class %+v", class)
+	}
+}
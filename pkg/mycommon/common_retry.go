@@ -0,0 +1,156 @@
+package mycommon
+
+import (
+	"context"
+	"errors"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"net"
+	"regexp"
+	"simple-one-api/pkg/config"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	UpstreamErrKindStatus     = "status"
+	UpstreamErrKindTimeout    = "timeout"
+	UpstreamErrKindConnection = "connection"
+	UpstreamErrKindUnknown    = "unknown"
+)
+
+// 各家SDK返回的错误格式不统一，例如 "status code: 429"、"http status code: 502"、"HTTP error: 503 Service Unavailable"、"status 500: ..."
+var upstreamStatusCodeRegexp = regexp.MustCompile(`(?i)(?:status code|status|http error)[:=\s]+(\d{3})\b`)
+
+var connectionErrKeywords = []string{
+	"connection refused",
+	"connection reset",
+	"no such host",
+	"broken pipe",
+	"unexpected eof",
+	"tls handshake",
+	"server closed",
+}
+
+// GetUpstreamStatusCode 尝试从上游错误中解析HTTP状态码，解析不到时返回0
+func GetUpstreamStatusCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatusCode > 0 {
+		return apiErr.HTTPStatusCode
+	}
+
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) && reqErr.HTTPStatusCode > 0 {
+		return reqErr.HTTPStatusCode
+	}
+
+	if m := upstreamStatusCodeRegexp.FindStringSubmatch(err.Error()); len(m) == 2 {
+		code, _ := strconv.Atoi(m[1])
+		if code >= 100 && code <= 599 {
+			return code
+		}
+	}
+	return 0
+}
+
+// ClassifyUpstreamError 将上游错误归类为状态码错误、超时、连接错误或未知错误
+func ClassifyUpstreamError(err error) (string, int) {
+	if code := GetUpstreamStatusCode(err); code > 0 {
+		return UpstreamErrKindStatus, code
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return UpstreamErrKindTimeout, 0
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return UpstreamErrKindConnection, 0
+	}
+
+	// 很多SDK使用%v包装错误，只能通过错误信息判断
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "timeout") || strings.Contains(msg, "deadline exceeded") {
+		return UpstreamErrKindTimeout, 0
+	}
+	for _, kw := range connectionErrKeywords {
+		if strings.Contains(msg, kw) {
+			return UpstreamErrKindConnection, 0
+		}
+	}
+
+	return UpstreamErrKindUnknown, 0
+}
+
+// 上游没有返回429，但错误信息表明被限流，例如 "rate limit exceeded"、"Throttling.User"、"qps limit"
+var throttleErrKeywords = []string{
+	"rate limit",
+	"ratelimit",
+	"rate_limit",
+	"too many requests",
+	"throttl",
+	"qps limit",
+}
+
+// IsUpstreamThrottled 判断上游错误是否为限流错误：状态码429，或者错误信息中包含限流关键字
+func IsUpstreamThrottled(err error) bool {
+	if err == nil {
+		return false
+	}
+	if GetUpstreamStatusCode(err) == 429 {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, kw := range throttleErrKeywords {
+		if strings.Contains(msg, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRetryableError 根据retryOn配置判断错误是否可以切换到下一个服务/凭证重试
+func IsRetryableError(err error, retryOn []string) bool {
+	return MatchUpstreamError(err, retryOn)
+}
+
+// MatchUpstreamError 判断错误是否属于kinds中的某一类
+// kinds 支持 connection、timeout、4xx、5xx 以及具体的状态码如 429
+func MatchUpstreamError(err error, kinds []string) bool {
+	if err == nil {
+		return false
+	}
+
+	kind, code := ClassifyUpstreamError(err)
+	for _, r := range kinds {
+		switch strings.ToLower(strings.TrimSpace(r)) {
+		case config.RETRY_ON_CONNECTION:
+			if kind == UpstreamErrKindConnection {
+				return true
+			}
+		case config.RETRY_ON_TIMEOUT:
+			if kind == UpstreamErrKindTimeout {
+				return true
+			}
+		case config.RETRY_ON_4XX:
+			if code >= 400 && code < 500 {
+				return true
+			}
+		case config.RETRY_ON_5XX:
+			if code >= 500 && code < 600 {
+				return true
+			}
+		default:
+			if n, convErr := strconv.Atoi(r); convErr == nil && n == code {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package mycommon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyUpstreamError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		kind string
+		code int
+	}{
+		{"api error", &openai.APIError{HTTPStatusCode: 429}, UpstreamErrKindStatus, 429},
+		{"wrapped request error", fmt.Errorf("ChatCompletionStream error: %w", &openai.RequestError{HTTPStatusCode: 502, Err: errors.New("bad gateway")}), UpstreamErrKindStatus, 502},
+		{"transport status", errors.New("HTTP error: 503, body: overloaded"), UpstreamErrKindStatus, 503},
+		{"sdk status", errors.New("error, status code: 401, message: invalid key"), UpstreamErrKindStatus, 401},
+		{"deadline", fmt.Errorf("request failed: %w", context.DeadlineExceeded), UpstreamErrKindTimeout, 0},
+		{"net timeout", &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, UpstreamErrKindTimeout, 0},
+		{"timeout keyword", errors.New("Client.Timeout exceeded while awaiting headers"), UpstreamErrKindTimeout, 0},
+		{"dial refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, UpstreamErrKindConnection, 0},
+		{"reset", fmt.Errorf("read: %w", syscall.ECONNRESET), UpstreamErrKindConnection, 0},
+		{"unexpected eof", fmt.Errorf("stream: %w", io.ErrUnexpectedEOF), UpstreamErrKindConnection, 0},
+		{"connection keyword", errors.New("dial tcp: lookup api.example.com: no such host"), UpstreamErrKindConnection, 0},
+		{"canceled", context.Canceled, UpstreamErrKindUnknown, 0},
+		{"unknown", errors.New("invalid character 'x' looking for beginning of value"), UpstreamErrKindUnknown, 0},
+	}
+	for _, tc := range cases {
+		kind, code := ClassifyUpstreamError(tc.err)
+		if kind != tc.kind || code != tc.code {
+			t.Errorf("%s: expected %s/%d, got %s/%d", tc.name, tc.kind, tc.code, kind, code)
+		}
+	}
+}
+
+func TestMatchUpstreamError(t *testing.T) {
+	allKinds := []string{"connection", "timeout", "4xx", "5xx"}
+	cases := []struct {
+		name     string
+		err      error
+		kinds    []string
+		expected bool
+	}{
+		{"429 default", &openai.APIError{HTTPStatusCode: 429}, []string{"connection", "timeout", "429", "5xx"}, true},
+		{"400 default", &openai.APIError{HTTPStatusCode: 400}, []string{"connection", "timeout", "429", "5xx"}, false},
+		{"400 4xx", &openai.APIError{HTTPStatusCode: 400}, []string{"4xx"}, true},
+		{"503 5xx", errors.New("HTTP error: 503, body: overloaded"), []string{"5xx"}, true},
+		{"503 not network", errors.New("HTTP error: 503, body: overloaded"), []string{"connection", "timeout"}, false},
+		{"exact code", errors.New("status code: 502"), []string{"502"}, true},
+		{"other code", errors.New("status code: 502"), []string{"503"}, false},
+		{"timeout", context.DeadlineExceeded, []string{"timeout"}, true},
+		{"timeout not connection", context.DeadlineExceeded, []string{"connection"}, false},
+		{"connection", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, []string{"CONNECTION"}, true},
+		{"connection keyword", errors.New("read: connection reset by peer"), []string{"connection"}, true},
+		// 客户端断开或对冲落败时请求被取消，不属于任何一类，不重试也不计入熔断
+		{"canceled", context.Canceled, allKinds, false},
+		{"wrapped canceled", fmt.Errorf("ChatCompletionStream error: %w", context.Canceled), allKinds, false},
+		{"nil", nil, allKinds, false},
+	}
+	for _, tc := range cases {
+		if got := MatchUpstreamError(tc.err, tc.kinds); got != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package mycommon
+
+import (
+	"encoding/json"
+	"github.com/sashabaranov/go-openai"
+	"unicode"
+)
+
+// 离线估算token数，不依赖具体模型的分词器，结果偏保守，用于在路由前判断上下文窗口是否放得下请求
+const (
+	tokensPerMessage   = 4   // 每条消息的role、分隔符等固定开销
+	tokensPerReply     = 3   // 回复的起始标记
+	tokensPerImageLow  = 85  // detail为low的图片
+	tokensPerImageHigh = 765 // detail为high或auto的图片，按1024x1024估算
+)
+
+// EstimateTextTokens 估算一段文本的token数：CJK等非ASCII字符每个按1个token计算，ASCII字符每4个按1个token计算
+func EstimateTextTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+
+	tokens := 0
+	asciiRun := 0
+	for _, r := range text {
+		if r < unicode.MaxASCII {
+			asciiRun++
+			continue
+		}
+		tokens += (asciiRun + 3) / 4
+		asciiRun = 0
+		tokens++
+	}
+	return tokens + (asciiRun+3)/4
+}
+
+// estimateJSONTokens 估算结构化数据序列化为JSON后的token数
+func estimateJSONTokens(v interface{}) int {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return EstimateTextTokens(string(data))
+}
+
+// EstimateMessagesTokens 估算消息列表的token数
+func EstimateMessagesTokens(messages []openai.ChatCompletionMessage) int {
+	tokens := 0
+	for _, msg := range messages {
+		tokens += tokensPerMessage
+		tokens += EstimateTextTokens(msg.Role)
+		tokens += EstimateTextTokens(msg.Name)
+		tokens += EstimateTextTokens(msg.Content)
+		for _, part := range msg.MultiContent {
+			switch part.Type {
+			case openai.ChatMessagePartTypeText:
+				tokens += EstimateTextTokens(part.Text)
+			case openai.ChatMessagePartTypeImageURL:
+				if part.ImageURL != nil && part.ImageURL.Detail == openai.ImageURLDetailLow {
+					tokens += tokensPerImageLow
+				} else {
+					tokens += tokensPerImageHigh
+				}
+			}
+		}
+		if msg.FunctionCall != nil {
+			tokens += estimateJSONTokens(msg.FunctionCall)
+		}
+		if len(msg.ToolCalls) > 0 {
+			tokens += estimateJSONTokens(msg.ToolCalls)
+		}
+		tokens += EstimateTextTokens(msg.ToolCallID)
+	}
+	return tokens + tokensPerReply
+}
+
+// EstimatePromptTokens 估算请求输入部分的token数：消息和工具定义
+func EstimatePromptTokens(oaiReq *openai.ChatCompletionRequest) int {
+	tokens := EstimateMessagesTokens(oaiReq.Messages)
+	if len(oaiReq.Tools) > 0 {
+		tokens += estimateJSONTokens(oaiReq.Tools)
+	}
+	if len(oaiReq.Functions) > 0 {
+		tokens += estimateJSONTokens(oaiReq.Functions)
+	}
+	return tokens
+}
+
+// EstimateChatCompletionTokens 估算请求占用的上下文长度：输入部分以及为输出预留的max_tokens
+func EstimateChatCompletionTokens(oaiReq *openai.ChatCompletionRequest) int {
+	tokens := EstimatePromptTokens(oaiReq)
+	if oaiReq.MaxCompletionTokens > 0 {
+		tokens += oaiReq.MaxCompletionTokens
+	} else {
+		tokens += oaiReq.MaxTokens
+	}
+	return tokens
+}
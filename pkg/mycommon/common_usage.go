@@ -0,0 +1,40 @@
+package mycommon
+
+import (
+	"github.com/sashabaranov/go-openai"
+	"sync"
+)
+
+// ChatUsage 累计一次请求中一个或多个聊天补全调用的token用量，可以并发调用Add
+type ChatUsage struct {
+	mu               sync.Mutex
+	promptTokens     int64
+	completionTokens int64
+}
+
+// Add 累加一次调用的用量，上游没有返回usage时按请求和生成的内容估算
+func (u *ChatUsage) Add(oaiReq *openai.ChatCompletionRequest, usage *openai.Usage, completion string) {
+	var prompt, comp int64
+	if usage != nil && usage.TotalTokens > 0 {
+		prompt, comp = int64(usage.PromptTokens), int64(usage.CompletionTokens)
+	} else {
+		prompt, comp = int64(EstimatePromptTokens(oaiReq)), int64(EstimateTextTokens(completion))
+	}
+
+	u.AddTokens(prompt, comp)
+}
+
+// Tokens 返回累计的输入和输出token数
+func (u *ChatUsage) Tokens() (int64, int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.promptTokens, u.completionTokens
+}
+
+// AddTokens 累加已知的token用量，如embeddings接口返回的usage
+func (u *ChatUsage) AddTokens(promptTokens int64, completionTokens int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.promptTokens += promptTokens
+	u.completionTokens += completionTokens
+}
@@ -0,0 +1,274 @@
+package mykeys
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mystore"
+	"simple-one-api/pkg/utils"
+	"sort"
+	"time"
+)
+
+// keysBucket 存储通过管理接口创建的API key，键为API key的哈希
+const keysBucket = "api_keys"
+
+// keySecretBytes 生成的API key的随机字节数
+const keySecretBytes = 24
+
+var ErrKeyNotFound = errors.New("api key not found")
+
+// IsEnabled 配置了admin_key时才开启API key管理，未开启时不访问本地存储
+func IsEnabled() bool {
+	return config.GSOAConf != nil && config.GSOAConf.AdminKey != ""
+}
+
+// ManagedKey 通过管理接口创建的API key，只保存key的哈希，明文只在创建时返回一次
+type ManagedKey struct {
+	ID              string                      `json:"id"`
+	KeyHash         string                      `json:"-"`
+	KeyPrefix       string                      `json:"key_prefix"`
+	SupportedModels map[string][]string         `json:"supported_models"`
+	Namespace       string                      `json:"namespace"`
+	Limit           config.Limit                `json:"limit"`
+	ModelLimits     map[string]config.Limit     `json:"model_limits,omitempty"`
+	Quota           config.QuotaConf            `json:"quota"`
+	ModelQuotas     map[string]config.QuotaConf `json:"model_quotas,omitempty"`
+	IPFilter        config.IPFilterConf         `json:"ip_filter"`
+	Priority        string                      `json:"priority,omitempty"`
+	QueueWeight     float64                     `json:"queue_weight,omitempty"`
+	Disabled        bool                        `json:"disabled"`
+	ExpiresAt       *time.Time                  `json:"expires_at,omitempty"`
+	Owner           string                      `json:"owner,omitempty"`
+	Team            string                      `json:"team,omitempty"`
+	Metadata        map[string]string           `json:"metadata,omitempty"`
+	CreatedAt       time.Time                   `json:"created_at"`
+	UpdatedAt       time.Time                   `json:"updated_at"`
+}
+
+// KeyUpdate 创建或修改API key的参数，指针和map为nil的字段保持不变
+type KeyUpdate struct {
+	SupportedModels map[string][]string         `json:"supported_models"`
+	Namespace       *string                     `json:"namespace"`
+	Limit           *config.Limit               `json:"limit"`
+	ModelLimits     map[string]config.Limit     `json:"model_limits"`
+	Quota           *config.QuotaConf           `json:"quota"`
+	ModelQuotas     map[string]config.QuotaConf `json:"model_quotas"`
+	IPFilter        *config.IPFilterConf        `json:"ip_filter"`
+	Priority        *string                     `json:"priority"`
+	QueueWeight     *float64                    `json:"queue_weight"`
+	Disabled        *bool                       `json:"disabled"`
+	ExpiresAt       *time.Time                  `json:"expires_at"`
+	Owner           *string                     `json:"owner"`
+	Team            *string                     `json:"team"`
+	Metadata        map[string]string           `json:"metadata"`
+}
+
+func (k *ManagedKey) apply(u *KeyUpdate) {
+	if u.SupportedModels != nil {
+		k.SupportedModels = u.SupportedModels
+	}
+	if u.Namespace != nil {
+		k.Namespace = *u.Namespace
+	}
+	if u.Limit != nil {
+		k.Limit = *u.Limit
+	}
+	if u.ModelLimits != nil {
+		k.ModelLimits = u.ModelLimits
+	}
+	if u.Quota != nil {
+		k.Quota = *u.Quota
+	}
+	if u.ModelQuotas != nil {
+		k.ModelQuotas = u.ModelQuotas
+	}
+	if u.IPFilter != nil {
+		k.IPFilter = *u.IPFilter
+	}
+	if u.Priority != nil {
+		k.Priority = *u.Priority
+	}
+	if u.QueueWeight != nil {
+		k.QueueWeight = *u.QueueWeight
+	}
+	if u.Disabled != nil {
+		k.Disabled = *u.Disabled
+	}
+	if u.ExpiresAt != nil {
+		if u.ExpiresAt.IsZero() {
+			k.ExpiresAt = nil
+		} else {
+			k.ExpiresAt = u.ExpiresAt
+		}
+	}
+	if u.Owner != nil {
+		k.Owner = *u.Owner
+	}
+	if u.Team != nil {
+		k.Team = *u.Team
+	}
+	if u.Metadata != nil {
+		k.Metadata = u.Metadata
+	}
+}
+
+// IsActive key未被禁用且未过期
+func (k *ManagedKey) IsActive(now time.Time) bool {
+	return !k.Disabled && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// ToAPIKeyConfig 转换为鉴权、限流和额度使用的配置，apiKey为请求中的明文key
+func (k *ManagedKey) ToAPIKeyConfig(apiKey string) *config.APIKeyConfig {
+	return &config.APIKeyConfig{
+		APIKey:          apiKey,
+		SupportedModels: k.SupportedModels,
+		Namespace:       k.Namespace,
+		Limit:           k.Limit,
+		ModelLimits:     k.ModelLimits,
+		Quota:           k.Quota,
+		ModelQuotas:     k.ModelQuotas,
+		IPFilter:        k.IPFilter,
+		Priority:        k.Priority,
+		QueueWeight:     k.QueueWeight,
+	}
+}
+
+// generateSecret 生成随机的API key
+func generateSecret() (string, error) {
+	b := make([]byte, keySecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "sk-" + hex.EncodeToString(b), nil
+}
+
+// CreateKey 生成新的API key并保存，返回明文key，之后无法再次获取
+func CreateKey(u *KeyUpdate) (string, *ManagedKey, error) {
+	secret, err := generateSecret()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	k := &ManagedKey{
+		ID:        uuid.New().String(),
+		KeyHash:   utils.HashAPIKey(secret),
+		KeyPrefix: secret[:7],
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	k.apply(u)
+
+	if err = mystore.PutJSON(keysBucket, k.KeyHash, k); err != nil {
+		return "", nil, err
+	}
+	return secret, k, nil
+}
+
+// LookupKey 按明文key查询，不存在时返回false
+func LookupKey(apiKey string) (*ManagedKey, bool, error) {
+	var k ManagedKey
+	hash := utils.HashAPIKey(apiKey)
+	found, err := mystore.GetJSON(keysBucket, hash, &k)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	k.KeyHash = hash
+	return &k, true, nil
+}
+
+// ListKeys 返回所有API key，按创建时间排序
+func ListKeys() ([]*ManagedKey, error) {
+	var keys []*ManagedKey
+	err := mystore.View(keysBucket, func(b *bolt.Bucket) error {
+		return b.ForEach(func(hash, data []byte) error {
+			var k ManagedKey
+			if err := json.Unmarshal(data, &k); err != nil {
+				return err
+			}
+			k.KeyHash = string(hash)
+			keys = append(keys, &k)
+			return nil
+		})
+	})
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, err
+}
+
+// HasKeys 判断是否通过管理接口创建过API key
+func HasKeys() bool {
+	hasKeys := false
+	mystore.View(keysBucket, func(b *bolt.Bucket) error {
+		k, _ := b.Cursor().First()
+		hasKeys = k != nil
+		return nil
+	})
+	return hasKeys
+}
+
+// findByID 按ID查找key，返回哈希和记录
+func findByID(b *bolt.Bucket, id string) (string, *ManagedKey, error) {
+	c := b.Cursor()
+	for hash, data := c.First(); hash != nil; hash, data = c.Next() {
+		var k ManagedKey
+		if err := json.Unmarshal(data, &k); err != nil {
+			return "", nil, err
+		}
+		if k.ID == id {
+			k.KeyHash = string(hash)
+			return string(hash), &k, nil
+		}
+	}
+	return "", nil, ErrKeyNotFound
+}
+
+// GetKey 按ID查询API key
+func GetKey(id string) (*ManagedKey, error) {
+	var key *ManagedKey
+	err := mystore.View(keysBucket, func(b *bolt.Bucket) error {
+		_, k, err := findByID(b, id)
+		key = k
+		return err
+	})
+	if err == nil && key == nil {
+		return nil, ErrKeyNotFound
+	}
+	return key, err
+}
+
+// UpdateKey 按ID修改API key的模型、限流、额度、过期时间等
+func UpdateKey(id string, u *KeyUpdate) (*ManagedKey, error) {
+	var key *ManagedKey
+	err := mystore.Update(keysBucket, func(b *bolt.Bucket) error {
+		hash, k, err := findByID(b, id)
+		if err != nil {
+			return err
+		}
+		k.apply(u)
+		k.UpdatedAt = time.Now()
+
+		data, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+		key = k
+		return b.Put([]byte(hash), data)
+	})
+	return key, err
+}
+
+// DeleteKey 按ID删除API key
+func DeleteKey(id string) error {
+	return mystore.Update(keysBucket, func(b *bolt.Bucket) error {
+		hash, _, err := findByID(b, id)
+		if err != nil {
+			return err
+		}
+		return b.Delete([]byte(hash))
+	})
+}
@@ -0,0 +1,63 @@
+package mykeys
+
+import (
+	"bytes"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mylog"
+	"simple-one-api/pkg/mystore"
+	"testing"
+	"time"
+)
+
+func TestManagedKeys(t *testing.T) {
+	mylog.InitLog("prod")
+	config.GSOAConf = &config.Configuration{AdminKey: "admin", StorePath: filepath.Join(t.TempDir(), "keys.db")}
+	defer func() { config.GSOAConf = nil }()
+
+	if HasKeys() {
+		t.Fatalf("expected no keys")
+	}
+
+	team := "team-a"
+	secret, key, err := CreateKey(&KeyUpdate{Team: &team, SupportedModels: map[string][]string{"openai": {"gpt-4o"}}})
+	if err != nil {
+		t.Fatalf("CreateKey error: %v", err)
+	}
+
+	mystore.View(keysBucket, func(b *bolt.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			if bytes.Contains(k, []byte(secret)) || bytes.Contains(v, []byte(secret)) {
+				t.Fatalf("plaintext key should not be stored")
+			}
+			return nil
+		})
+	})
+
+	found, ok, err := LookupKey(secret)
+	if err != nil || !ok || found.ID != key.ID || found.Team != team {
+		t.Fatalf("LookupKey failed: %v, %v, %v", found, ok, err)
+	}
+	if _, ok, _ = LookupKey("sk-unknown"); ok {
+		t.Fatalf("unexpected key found")
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	updated, err := UpdateKey(key.ID, &KeyUpdate{ExpiresAt: &expired})
+	if err != nil || updated.IsActive(time.Now()) || updated.Team != team {
+		t.Fatalf("expected expired key, got %v, %v", updated, err)
+	}
+	updated, _ = UpdateKey(key.ID, &KeyUpdate{ExpiresAt: &time.Time{}})
+	if !updated.IsActive(time.Now()) {
+		t.Fatalf("expected expiry cleared")
+	}
+
+	if err = DeleteKey(key.ID); err != nil {
+		t.Fatalf("DeleteKey error: %v", err)
+	}
+	if _, err = GetKey(key.ID); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
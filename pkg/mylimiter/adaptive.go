@@ -0,0 +1,207 @@
+package mylimiter
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"math"
+	"net/http"
+	"simple-one-api/pkg/mylog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAdaptiveDecreaseFactor = 0.5
+	defaultAdaptiveMaxPause       = 60 * time.Second
+	// adaptiveDecreaseInterval 两次减小并发数的最短间隔，同一批在途请求的429只减小一次
+	adaptiveDecreaseInterval = time.Second
+)
+
+// ErrUpstreamPaused 上游返回了Retry-After，暂停期间在请求的timeout内无法恢复
+var ErrUpstreamPaused = errors.New("upstream paused by retry-after")
+
+// AdaptiveConf 自适应并发的参数，并发数在Min和Max之间调整，从Initial开始(默认为Max)；
+// 上游限流时乘以DecreaseFactor，Retry-After等header要求的暂停时间不超过MaxPause
+type AdaptiveConf struct {
+	Min            int
+	Max            int
+	Initial        int
+	DecreaseFactor float64
+	MaxPause       time.Duration
+}
+
+// normalize 补全默认值，保证1 <= Min <= Initial <= Max
+func (c AdaptiveConf) normalize() AdaptiveConf {
+	if c.Min < 1 {
+		c.Min = 1
+	}
+	if c.Max < c.Min {
+		c.Max = c.Min
+	}
+	if c.Initial <= 0 || c.Initial > c.Max {
+		c.Initial = c.Max
+	}
+	if c.Initial < c.Min {
+		c.Initial = c.Min
+	}
+	if c.DecreaseFactor <= 0 || c.DecreaseFactor >= 1 {
+		c.DecreaseFactor = defaultAdaptiveDecreaseFactor
+	}
+	if c.MaxPause <= 0 {
+		c.MaxPause = defaultAdaptiveMaxPause
+	}
+	return c
+}
+
+// AdaptiveConcurrency 按AIMD自动调整一个服务或凭证的并发数：上游返回429等限流错误时成倍减小，
+// 并按Retry-After/x-ratelimit-reset-*暂停；调用成功时每次增加1/当前并发数，即每轮满并发的成功调用增加1。
+// 状态只在进程内生效
+type AdaptiveConcurrency struct {
+	mu           sync.Mutex
+	key          string
+	conf         AdaptiveConf
+	limit        float64
+	lastDecrease time.Time
+	pausedUntil  time.Time
+}
+
+var (
+	adaptiveMap   = make(map[string]*AdaptiveConcurrency)
+	adaptiveMutex sync.Mutex
+)
+
+// GetAdaptiveConcurrency 返回key对应的自适应并发，配置变化时按新的范围调整当前并发数
+func GetAdaptiveConcurrency(key string, conf AdaptiveConf) *AdaptiveConcurrency {
+	conf = conf.normalize()
+
+	adaptiveMutex.Lock()
+	a, exists := adaptiveMap[key]
+	if !exists {
+		a = &AdaptiveConcurrency{key: key, conf: conf, limit: float64(conf.Initial)}
+		adaptiveMap[key] = a
+	}
+	adaptiveMutex.Unlock()
+
+	if exists {
+		a.mu.Lock()
+		if a.conf != conf {
+			a.conf = conf
+			a.limit = math.Max(float64(conf.Min), math.Min(float64(conf.Max), a.limit))
+		}
+		a.mu.Unlock()
+	}
+	return a
+}
+
+// Limit 返回当前允许的并发数
+func (a *AdaptiveConcurrency) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int(a.limit)
+}
+
+// PausedFor 返回距离暂停结束还需要等待的时间，没有暂停时返回0
+func (a *AdaptiveConcurrency) PausedFor() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if d := time.Until(a.pausedUntil); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// OnSuccess 上游调用成功，缓慢增加并发数
+func (a *AdaptiveConcurrency) OnSuccess() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.limit = math.Min(float64(a.conf.Max), a.limit+1/a.limit)
+}
+
+// OnThrottled 上游返回限流错误，减小并发数；retryAfter大于0时暂停到期之前不再发出请求
+func (a *AdaptiveConcurrency) OnThrottled(retryAfter time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if retryAfter > a.conf.MaxPause {
+		retryAfter = a.conf.MaxPause
+	}
+	if until := now.Add(retryAfter); retryAfter > 0 && until.After(a.pausedUntil) {
+		a.pausedUntil = until
+	}
+	if now.Sub(a.lastDecrease) < adaptiveDecreaseInterval {
+		return
+	}
+	a.lastDecrease = now
+	a.limit = math.Max(float64(a.conf.Min), math.Floor(a.limit*a.conf.DecreaseFactor))
+
+	mylog.Logger.Warn("upstream throttled, concurrency decreased",
+		zap.String("key", a.key),
+		zap.Int("concurrency", int(a.limit)),
+		zap.Duration("retry_after", retryAfter))
+}
+
+// ParseRetryAfter 从上游限流响应的header中解析需要等待的时间：优先使用Retry-After(秒数或HTTP日期)、retry-after-ms；
+// 都没有时使用已经用完的限额(对应的x-ratelimit-remaining-*为0)的x-ratelimit-reset-*(如"6m0s"、"20ms"、秒数或unix时间戳)，
+// 其他限额的重置时间与本次限流无关，不等待
+func ParseRetryAfter(header http.Header, now time.Time) time.Duration {
+	var wait time.Duration
+	found := false
+	longer := func(d time.Duration) {
+		found = true
+		if d > wait {
+			wait = d
+		}
+	}
+
+	if v := header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil {
+			longer(time.Duration(secs * float64(time.Second)))
+		} else if t, err := http.ParseTime(v); err == nil {
+			longer(t.Sub(now))
+		}
+	}
+	if v := header.Get("Retry-After-Ms"); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil {
+			longer(time.Duration(ms * float64(time.Millisecond)))
+		}
+	}
+	if found {
+		return wait
+	}
+
+	for name, values := range header {
+		lower := strings.ToLower(name)
+		if !strings.HasPrefix(lower, "x-ratelimit-reset") || len(values) == 0 {
+			continue
+		}
+		// x-ratelimit-reset-tokens对应x-ratelimit-remaining-tokens，x-ratelimit-reset对应x-ratelimit-remaining
+		remaining := header.Get("x-ratelimit-remaining" + strings.TrimPrefix(lower, "x-ratelimit-reset"))
+		if n, err := strconv.ParseFloat(strings.TrimSpace(remaining), 64); err != nil || n > 0 {
+			continue
+		}
+		longer(parseResetValue(values[0], now))
+	}
+	return wait
+}
+
+// parseResetValue 解析x-ratelimit-reset-*的值，可以是时长、秒数、unix时间戳或RFC3339时间
+func parseResetValue(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if d, err := time.ParseDuration(v); err == nil {
+		return d
+	}
+	if n, err := strconv.ParseFloat(v, 64); err == nil {
+		// 大于10^9的按unix时间戳处理
+		if n > 1e9 {
+			return time.Unix(0, int64(n*float64(time.Second))).Sub(now)
+		}
+		return time.Duration(n * float64(time.Second))
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.Sub(now)
+	}
+	return 0
+}
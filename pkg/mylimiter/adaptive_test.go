@@ -0,0 +1,100 @@
+package mylimiter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"simple-one-api/pkg/mylog"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	mylog.InitLog("prod")
+	defer SetStore(NewMemoryStore())
+	SetStore(NewMemoryStore())
+
+	a := GetAdaptiveConcurrency("test-adaptive", AdaptiveConf{Min: 2, Max: 8})
+	if a.Limit() != 8 {
+		t.Fatalf("expected initial limit 8, got %d", a.Limit())
+	}
+
+	// 成倍减小，同一秒内的多个429只减小一次，不低于min
+	a.OnThrottled(0)
+	a.OnThrottled(0)
+	if a.Limit() != 4 {
+		t.Fatalf("expected limit 4, got %d", a.Limit())
+	}
+	a.lastDecrease = time.Time{}
+	a.OnThrottled(0)
+	a.lastDecrease = time.Time{}
+	a.OnThrottled(0)
+	if a.Limit() != 2 {
+		t.Fatalf("expected limit bounded by min, got %d", a.Limit())
+	}
+
+	// 成功时缓慢增加，不超过max
+	for i := 0; i < 3; i++ {
+		a.OnSuccess()
+	}
+	if a.Limit() != 3 {
+		t.Fatalf("expected limit 3 after successes, got %d", a.Limit())
+	}
+	for i := 0; i < 100; i++ {
+		a.OnSuccess()
+	}
+	if a.Limit() != 8 {
+		t.Fatalf("expected limit bounded by max, got %d", a.Limit())
+	}
+
+	// 当前并发数限制生效
+	lim := GetAdaptiveLimiter("test-adaptive", Limits{}, GetAdaptiveConcurrency("test-adaptive", AdaptiveConf{Min: 1, Max: 1}))
+	permit, err := Acquire(context.Background(), 0, lim)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = Acquire(ctx, 0, lim); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected concurrency wait to time out, got %v", err)
+	}
+	permit.Release()
+
+	// 暂停时间超过timeout时直接失败
+	lim.adaptive.OnThrottled(10 * time.Second)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err = Acquire(ctx, 0, lim); !errors.Is(err, ErrUpstreamPaused) {
+		t.Fatalf("expected paused error, got %v", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cases := []struct {
+		header   http.Header
+		expected time.Duration
+	}{
+		{http.Header{"Retry-After": {"3"}}, 3 * time.Second},
+		{http.Header{"Retry-After": {now.Add(5 * time.Second).UTC().Format(http.TimeFormat)}}, 5 * time.Second},
+		{http.Header{"Retry-After-Ms": {"250"}}, 250 * time.Millisecond},
+		// Retry-After优先于x-ratelimit-reset-*
+		{http.Header{"Retry-After": {"3"}, "X-Ratelimit-Remaining-Requests": {"0"}, "X-Ratelimit-Reset-Requests": {"1m30s"}}, 3 * time.Second},
+		// 只使用已经用完的限额的重置时间
+		{http.Header{"X-Ratelimit-Reset-Requests": {"1m30s"}, "X-Ratelimit-Remaining-Requests": {"10"},
+			"X-Ratelimit-Reset-Tokens": {"20ms"}, "X-Ratelimit-Remaining-Tokens": {"0"}}, 20 * time.Millisecond},
+		{http.Header{"X-Ratelimit-Reset-Requests": {"1m30s"}, "X-Ratelimit-Remaining-Requests": {"0"},
+			"X-Ratelimit-Reset-Tokens": {"20ms"}, "X-Ratelimit-Remaining-Tokens": {"0"}}, 90 * time.Second},
+		{http.Header{"X-Ratelimit-Reset": {"1700000007"}, "X-Ratelimit-Remaining": {"0"}}, 7 * time.Second},
+		{http.Header{"X-Ratelimit-Reset-Tokens": {"2"}, "X-Ratelimit-Remaining-Tokens": {"0"}}, 2 * time.Second},
+		// 没有对应的remaining或者限额没有用完时不等待
+		{http.Header{"X-Ratelimit-Reset-Requests": {"1m30s"}, "X-Ratelimit-Reset-Tokens": {"20ms"}}, 0},
+		{http.Header{"X-Ratelimit-Reset-Tokens": {"2"}, "X-Ratelimit-Remaining-Tokens": {"5"}}, 0},
+		{http.Header{}, 0},
+	}
+	for _, tc := range cases {
+		if got := ParseRetryAfter(tc.header, now); got != tc.expected {
+			t.Fatalf("%v: expected %v, got %v", tc.header, tc.expected, got)
+		}
+	}
+}
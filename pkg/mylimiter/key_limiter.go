@@ -0,0 +1,129 @@
+package mylimiter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/google/uuid"
+	"simple-one-api/pkg/mycomdef"
+	"sync"
+	"time"
+)
+
+// KeyLimiter 按API key限流，qps、rpm和并发数同时生效；超过限制时不排队等待，直接返回建议的重试等待时间
+type KeyLimiter struct {
+	key         string
+	qps         float64
+	rpm         int
+	concurrency int
+}
+
+var (
+	keyLimiterMap   = make(map[string]*KeyLimiter)
+	keyLimiterMutex sync.RWMutex
+)
+
+// concurrencyRetryAfter 并发数超限时建议的重试等待时间
+const concurrencyRetryAfter = time.Second
+
+// RateLimitError 超过限制时返回的错误，Limit为超过的限制类型，RetryAfter为建议的重试等待时间
+type RateLimitError struct {
+	Limit      string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded on %s, retry after %v", e.Limit, e.RetryAfter)
+}
+
+// NewKeyLimiter 创建独立的限流器，不与其他限流器共享限流状态
+func NewKeyLimiter(qps float64, rpm float64, concurrency float64) *KeyLimiter {
+	return newKeyLimiter("keylimiter:"+uuid.NewString(), qps, rpm, concurrency)
+}
+
+// newKeyLimiter key为限流状态在Store中的键
+func newKeyLimiter(key string, qps float64, rpm float64, concurrency float64) *KeyLimiter {
+	return &KeyLimiter{key: key, qps: qps, rpm: int(rpm), concurrency: int(concurrency)}
+}
+
+// storeKeyOf API key不以明文出现在Store的键中
+func storeKeyOf(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "apikey:" + hex.EncodeToString(sum[:16])
+}
+
+// GetKeyLimiter 根据键和限制值获取或创建限流器，限制值变化(如配置热加载)后使用新的限流器
+func GetKeyLimiter(key string, qps float64, rpm float64, concurrency float64) *KeyLimiter {
+	mapKey := fmt.Sprintf("%s|%g|%g|%g", key, qps, rpm, concurrency)
+
+	keyLimiterMutex.RLock()
+	lim, exists := keyLimiterMap[mapKey]
+	keyLimiterMutex.RUnlock()
+	if exists {
+		return lim
+	}
+
+	keyLimiterMutex.Lock()
+	defer keyLimiterMutex.Unlock()
+	if lim, exists = keyLimiterMap[mapKey]; !exists {
+		lim = newKeyLimiter(storeKeyOf(key), qps, rpm, concurrency)
+		keyLimiterMap[mapKey] = lim
+	}
+	return lim
+}
+
+// TryAcquire 尝试通过所有限制，成功时返回释放并发许可的函数，失败时返回*RateLimitError；失败或panic时释放已经获取的许可
+func (l *KeyLimiter) TryAcquire() (func(), error) {
+	ctx := context.Background()
+
+	release := func() {}
+	acquired := false
+	defer func() {
+		if !acquired {
+			release()
+		}
+	}()
+
+	if l.concurrency > 0 {
+		slotRelease, ok := acquireSlot(ctx, l.key+":concurrency", l.concurrency)
+		if !ok {
+			return nil, &RateLimitError{Limit: mycomdef.KEYNAME_CONCURRENCY, RetryAfter: concurrencyRetryAfter}
+		}
+		release = slotRelease
+	}
+
+	// 先检查rpm和qps是否都还有余量，都有余量时再依次消耗；一项拒绝时不消耗另一项，
+	// 否则被rpm拒绝的请求会耗尽qps的令牌，被qps拒绝的请求也会占用rpm的次数
+	rpmKey, qpsKey := l.key+":rpm", l.key+":qps"
+	rpmExhausted := false
+	if l.rpm > 0 {
+		st, ok := slidingWindowStatus(ctx, rpmKey, l.rpm)
+		rpmExhausted = ok && st.Remaining <= 0
+	}
+	// rpm已经用完时由下面的allowSlidingWindow拒绝并返回需要等待的时间
+	if l.qps > 0 && !rpmExhausted {
+		if ok, wait := tokenBucketStatus(ctx, qpsKey, l.qps); !ok {
+			return nil, &RateLimitError{Limit: mycomdef.KEYNAME_QPS, RetryAfter: wait}
+		}
+	}
+
+	if l.rpm > 0 {
+		if ok, wait := allowSlidingWindow(ctx, rpmKey, l.rpm); !ok {
+			return nil, &RateLimitError{Limit: mycomdef.KEYNAME_RPM, RetryAfter: wait}
+		}
+	}
+
+	if l.qps > 0 {
+		if ok, wait := allowTokenBucket(ctx, qpsKey, l.qps); !ok {
+			// 检查之后令牌被并发的请求用完，归还已经记录的rpm
+			if l.rpm > 0 {
+				undoSlidingWindow(ctx, rpmKey)
+			}
+			return nil, &RateLimitError{Limit: mycomdef.KEYNAME_QPS, RetryAfter: wait}
+		}
+	}
+
+	acquired = true
+	return release, nil
+}
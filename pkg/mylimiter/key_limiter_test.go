@@ -0,0 +1,121 @@
+package mylimiter
+
+import (
+	"context"
+	"errors"
+	"simple-one-api/pkg/mycomdef"
+	"testing"
+	"time"
+)
+
+func TestKeyLimiterConcurrency(t *testing.T) {
+	lim := NewKeyLimiter(0, 0, 1)
+
+	release, err := lim.TryAcquire()
+	if err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+
+	_, err = lim.TryAcquire()
+	var rlErr *RateLimitError
+	if !errors.As(err, &rlErr) || rlErr.Limit != mycomdef.KEYNAME_CONCURRENCY || rlErr.RetryAfter <= 0 {
+		t.Fatalf("expected concurrency limit error, got %v", err)
+	}
+
+	release()
+	release()
+	if _, err = lim.TryAcquire(); err != nil {
+		t.Fatalf("acquire after release failed: %v", err)
+	}
+}
+
+func TestKeyLimiterRPM(t *testing.T) {
+	lim := NewKeyLimiter(0, 2, 0)
+	for i := 0; i < 2; i++ {
+		if _, err := lim.TryAcquire(); err != nil {
+			t.Fatalf("acquire %d failed: %v", i, err)
+		}
+	}
+
+	_, err := lim.TryAcquire()
+	var rlErr *RateLimitError
+	if !errors.As(err, &rlErr) || rlErr.Limit != mycomdef.KEYNAME_RPM || rlErr.RetryAfter <= 0 {
+		t.Fatalf("expected rpm limit error, got %v", err)
+	}
+}
+
+func TestKeyLimiterRPMRejectKeepsQPS(t *testing.T) {
+	lim := NewKeyLimiter(2, 1, 0)
+	if _, err := lim.TryAcquire(); err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+
+	// 被rpm拒绝的请求不消耗qps的令牌，一直返回rpm的错误
+	for i := 0; i < 3; i++ {
+		_, err := lim.TryAcquire()
+		var rlErr *RateLimitError
+		if !errors.As(err, &rlErr) || rlErr.Limit != mycomdef.KEYNAME_RPM {
+			t.Fatalf("attempt %d: expected rpm limit error, got %v", i, err)
+		}
+	}
+	if ok, _ := allowTokenBucket(context.Background(), lim.key+":qps", lim.qps); !ok {
+		t.Fatal("qps token consumed by rpm rejection")
+	}
+}
+
+func TestKeyLimiterQPSRejectKeepsRPM(t *testing.T) {
+	lim := NewKeyLimiter(1, 1, 0)
+	if _, err := lim.TryAcquire(); err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+
+	var rlErr *RateLimitError
+	if _, err := lim.TryAcquire(); !errors.As(err, &rlErr) || rlErr.Limit != mycomdef.KEYNAME_RPM {
+		t.Fatalf("expected rpm limit error, got %v", err)
+	}
+
+	// 模拟rpm的窗口过期恢复，此时qps的令牌还没有恢复
+	ms := GetStore().(*MemoryStore)
+	ms.windows[lim.key+":rpm"].requests = nil
+
+	// 被qps拒绝的请求不占用rpm的次数
+	if _, err := lim.TryAcquire(); !errors.As(err, &rlErr) || rlErr.Limit != mycomdef.KEYNAME_QPS || rlErr.RetryAfter <= 0 {
+		t.Fatalf("expected qps limit error, got %v", err)
+	}
+	if st, ok := lim.RequestStatus(context.Background()); !ok || st.Remaining != 1 {
+		t.Fatalf("rpm consumed by qps rejection, status %+v", st)
+	}
+}
+
+// racyStore 检查时返回过期的状态：rpm已经用完而随后窗口过期恢复，qps有令牌而随后被并发的请求用完
+type racyStore struct {
+	*MemoryStore
+}
+
+func (s racyStore) SlidingWindowStatus(context.Context, string, int, time.Duration) (int, time.Duration, error) {
+	return 0, time.Minute, nil
+}
+
+func (s racyStore) TokenBucketStatus(context.Context, string, float64, int) (bool, time.Duration, error) {
+	return true, 0, nil
+}
+
+func TestKeyLimiterQPSRaceUndoesRPM(t *testing.T) {
+	ms := NewMemoryStore()
+	old := GetStore()
+	SetStore(racyStore{ms})
+	defer SetStore(old)
+
+	lim := NewKeyLimiter(1, 2, 0)
+	if _, err := lim.TryAcquire(); err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+
+	var rlErr *RateLimitError
+	if _, err := lim.TryAcquire(); !errors.As(err, &rlErr) || rlErr.Limit != mycomdef.KEYNAME_QPS {
+		t.Fatalf("expected qps limit error, got %v", err)
+	}
+	if remaining, _, _ := ms.SlidingWindowStatus(context.Background(), lim.key+":rpm", lim.rpm, time.Minute); remaining != 1 {
+		t.Fatalf("rpm not returned after qps rejection, remaining %d", remaining)
+	}
+}
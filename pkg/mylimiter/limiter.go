@@ -2,18 +2,33 @@ package mylimiter
 
 import (
 	"context"
-	"time"
-
-	"golang.org/x/sync/semaphore"
-	"golang.org/x/time/rate"
-	"simple-one-api/pkg/mycomdef"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"simple-one-api/pkg/mylog"
 	"sync"
+	"time"
 )
 
+// Limits 一个服务、凭证或embedding配置的所有限制，为0的项不限制
+type Limits struct {
+	QPS         float64
+	QPM         float64
+	Concurrency float64
+	TPM         float64
+	TPD         float64
+}
+
+// IsZero 判断是否没有任何限制
+func (l Limits) IsZero() bool {
+	return l.QPS <= 0 && l.QPM <= 0 && l.Concurrency <= 0 && l.TPM <= 0 && l.TPD <= 0
+}
+
+// Limiter 服务、凭证或embedding的限流器，配置的所有限制同时生效；限流状态保存在Store中，多实例部署时可以共享
 type Limiter struct {
-	QPSLimiter         *rate.Limiter
-	QPMLimiter         *SlidingWindowLimiter
-	ConcurrencyLimiter *semaphore.Weighted
+	key      string
+	limits   Limits
+	tokens   *TokenLimiter
+	adaptive *AdaptiveConcurrency
 }
 
 type SlidingWindowLimiter struct {
@@ -23,9 +38,11 @@ type SlidingWindowLimiter struct {
 	requests    []time.Time
 }
 
-var (
-	limiterMap = make(map[string]*Limiter)
-	mapMutex   sync.RWMutex
+const (
+	// minWaitInterval 等待限流时两次检查之间的最短间隔
+	minWaitInterval = 10 * time.Millisecond
+	// slotPollInterval 等待并发许可时的检查间隔
+	slotPollInterval = 50 * time.Millisecond
 )
 
 func NewSlidingWindowLimiter(qpm int) *SlidingWindowLimiter {
@@ -57,6 +74,20 @@ func (l *SlidingWindowLimiter) Allow() bool {
 	return false
 }
 
+// TryAllow 与Allow相同，不允许时同时返回到窗口内最早的请求过期还需要等待的时间
+func (l *SlidingWindowLimiter) TryAllow() (bool, time.Duration) {
+	if l.Allow() {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.requests) == 0 {
+		return false, l.interval
+	}
+	return false, time.Until(l.requests[0].Add(l.interval))
+}
+
 func (l *SlidingWindowLimiter) Wait(ctx context.Context) error {
 	waitTime := 10 * time.Millisecond // 初始等待时间
 
@@ -90,65 +121,249 @@ func (l *SlidingWindowLimiter) Wait(ctx context.Context) error {
 	}
 }
 
-// NewLimiter 创建一个新的限流器，根据指定的类型和限制值进行配置
-func NewLimiter(limitType string, limitn float64) *Limiter {
-	lim := &Limiter{}
-	switch limitType {
-	case mycomdef.KEYNAME_QPS:
-		lim.QPSLimiter = rate.NewLimiter(rate.Limit(limitn), int(limitn))
-	case mycomdef.KEYNAME_QPM, mycomdef.KEYNAME_RPM:
-		lim.QPMLimiter = NewSlidingWindowLimiter(int(limitn))
-	case mycomdef.KEYNAME_CONCURRENCY:
-		lim.ConcurrencyLimiter = semaphore.NewWeighted(int64(limitn))
-	default:
-		// 对无效类型无操作，或者可以抛出错误
+// NewLimiter 创建一个新的限流器，不与其他限流器共享限流状态；没有任何限制时返回nil
+func NewLimiter(limits Limits) *Limiter {
+	return newLimiter("limiter:"+uuid.NewString(), limits)
+}
+
+// GetLimiter 返回key对应的限流器，相同key的限流器共享限流状态；没有任何限制时返回nil
+func GetLimiter(key string, limits Limits) *Limiter {
+	return newLimiter("limiter:"+key, limits)
+}
+
+// GetAdaptiveLimiter 与GetLimiter相同，并发数由adaptive自动调整，同时配置了concurrency时取两者中较小的
+func GetAdaptiveLimiter(key string, limits Limits, adaptive *AdaptiveConcurrency) *Limiter {
+	if adaptive == nil {
+		return GetLimiter(key, limits)
 	}
-	return lim
+	key = "limiter:" + key
+	return &Limiter{key: key, limits: limits, tokens: newTokenLimiter(key, limits.TPM, limits.TPD), adaptive: adaptive}
 }
 
-// Wait 使用QPS限流器等待直到获得令牌
-func (l *Limiter) Wait(ctx context.Context) error {
-	if l.QPSLimiter != nil {
-		return l.QPSLimiter.Wait(ctx)
+// newLimiter key为限流状态在Store中的键
+func newLimiter(key string, limits Limits) *Limiter {
+	if limits.IsZero() {
+		return nil
 	}
-	if l.QPMLimiter != nil {
-		return l.QPMLimiter.Wait(ctx)
+	return &Limiter{key: key, limits: limits, tokens: newTokenLimiter(key, limits.TPM, limits.TPD)}
+}
+
+// burstOf 令牌桶的容量，至少为1
+func burstOf(qps float64) int {
+	if qps < 1 {
+		return 1
 	}
-	return nil
+	return int(qps)
 }
 
-// Acquire 尝试获取并发限制的许可，如果设置了超时则可以被中断
-func (l *Limiter) Acquire(ctx context.Context) error {
-	if l.ConcurrencyLimiter != nil {
-		return l.ConcurrencyLimiter.Acquire(ctx, 1)
+// allowTokenBucket 调用Store的令牌桶，Store出错时放行，避免Redis不可用导致所有请求失败
+func allowTokenBucket(ctx context.Context, key string, qps float64) (bool, time.Duration) {
+	ok, wait, err := GetStore().AllowTokenBucket(ctx, key, qps, burstOf(qps))
+	if err != nil {
+		mylog.Logger.Error("rate limit store error, request allowed", zap.String("key", key), zap.Error(err))
+		return true, 0
+	}
+	return ok, wait
+}
+
+// tokenBucketStatus 查询Store的令牌桶是否有令牌，不消耗令牌；Store出错时放行
+func tokenBucketStatus(ctx context.Context, key string, qps float64) (bool, time.Duration) {
+	ok, wait, err := GetStore().TokenBucketStatus(ctx, key, qps, burstOf(qps))
+	if err != nil {
+		mylog.Logger.Warn("rate limit store status error", zap.String("key", key), zap.Error(err))
+		return true, 0
+	}
+	return ok, wait
+}
+
+// allowSlidingWindow 调用Store的滑动窗口，Store出错时放行
+func allowSlidingWindow(ctx context.Context, key string, limit int) (bool, time.Duration) {
+	ok, wait, err := GetStore().AllowSlidingWindow(ctx, key, limit, time.Minute)
+	if err != nil {
+		mylog.Logger.Error("rate limit store error, request allowed", zap.String("key", key), zap.Error(err))
+		return true, 0
+	}
+	return ok, wait
+}
+
+// undoSlidingWindow 撤销allowSlidingWindow记录的请求
+func undoSlidingWindow(ctx context.Context, key string) {
+	if err := GetStore().UndoSlidingWindow(ctx, key); err != nil {
+		mylog.Logger.Error("rate limit store undo error", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// acquireSlot 从Store获取并发许可，成功时返回释放函数，释放函数可以重复调用；Store出错时放行
+func acquireSlot(ctx context.Context, key string, limit int) (func(), bool) {
+	s := GetStore()
+	slotID, ok, err := s.AcquireSlot(ctx, key, limit)
+	if err != nil {
+		mylog.Logger.Error("rate limit store error, request allowed", zap.String("key", key), zap.Error(err))
+		return func() {}, true
+	}
+	if !ok {
+		return nil, false
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			// 请求的context可能已经结束，释放时不使用
+			if err := s.ReleaseSlot(context.Background(), key, slotID); err != nil {
+				mylog.Logger.Error("release concurrency slot error", zap.String("key", key), zap.Error(err))
+			}
+		})
+	}, true
+}
+
+// waitUntil 反复调用allow直到允许或ctx结束，每次等待allow返回的时间
+func waitUntil(ctx context.Context, allow func() (bool, time.Duration)) error {
+	for {
+		ok, wait := allow()
+		if ok {
+			return nil
+		}
+		if wait < minWaitInterval {
+			wait = minWaitInterval
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
 	}
-	return nil
 }
 
-// Release 释放并发限制的一个许可
-func (l *Limiter) Release() {
-	if l.ConcurrencyLimiter != nil {
-		l.ConcurrencyLimiter.Release(1)
+// Permit 通过限流后获得的许可，请求结束后必须调用Release，nil表示没有限制
+type Permit struct {
+	releases []func()
+	tokens   *TokenReservation
+	once     sync.Once
+}
+
+// HasTokenLimit 判断是否预留了token，需要在请求结束后调用Settle按实际用量结算
+func (p *Permit) HasTokenLimit() bool {
+	return p != nil && p.tokens != nil && len(p.tokens.reserved) > 0
+}
+
+// Settle 按实际用量结算预留的token
+func (p *Permit) Settle(actual int64) {
+	if p == nil {
+		return
 	}
+	p.tokens.Settle(actual)
 }
 
-// GetLimiter 根据键获取或创建对应的限流器，支持线程安全操作
-func GetLimiter(key string, limitType string, limitn float64) *Limiter {
-	mapMutex.RLock()
-	if lim, exists := limiterMap[key]; exists {
-		mapMutex.RUnlock()
-		return lim
+// Release 释放并发许可，没有调用Settle时归还全部预留的token；可以重复调用
+func (p *Permit) Release() {
+	if p == nil {
+		return
 	}
-	mapMutex.RUnlock()
+	p.once.Do(func() {
+		p.tokens.Settle(0)
+		for i := len(p.releases) - 1; i >= 0; i-- {
+			p.releases[i]()
+		}
+	})
+}
+
+// Acquire 在一个等待过程中通过所有限流器的全部限制：对每个限流器依次获取并发许可、通过qps和qpm、预留tokens个token，
+// 等待后面的限制时继续持有已经通过的限制，直到全部通过或ctx结束；失败或panic时释放已经获取的许可并归还预留的token。
+// 每个限流器饱和时请求按ctx中的RequestClass排队，优先级高的先通过，同一优先级在不同API key之间按权重公平分配
+func Acquire(ctx context.Context, tokens int64, limiters ...*Limiter) (*Permit, error) {
+	p := &Permit{}
+	acquired := false
+	defer func() {
+		if !acquired {
+			p.Release()
+		}
+	}()
 
-	mapMutex.Lock()
-	defer mapMutex.Unlock()
-	// 双重检查以防在锁定期间已被创建
-	if lim, exists := limiterMap[key]; exists {
-		return lim
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+		if err := l.acquire(ctx, tokens, p); err != nil {
+			return nil, err
+		}
 	}
 
-	lim := NewLimiter(limitType, limitn)
-	limiterMap[key] = lim
-	return lim
+	acquired = true
+	return p, nil
+}
+
+// concurrencyLimit 当前的并发数限制，0表示不限制
+func (l *Limiter) concurrencyLimit() int {
+	limit := int(l.limits.Concurrency)
+	if l.adaptive != nil {
+		if a := l.adaptive.Limit(); limit <= 0 || a < limit {
+			limit = a
+		}
+	}
+	return limit
+}
+
+// acquire 在限流器的队列中等到轮次后通过它的全部限制，获取的许可和预留的token记录到p中
+func (l *Limiter) acquire(ctx context.Context, tokens int64, p *Permit) error {
+	leave, err := getQueue(l.key).enter(ctx)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	if l.adaptive != nil {
+		// 上游要求的暂停在timeout内结束不了时直接失败，切换到其他服务/凭证
+		if pause := l.adaptive.PausedFor(); pause > 0 {
+			if deadline, ok := ctx.Deadline(); ok && time.Now().Add(pause).After(deadline) {
+				return ErrUpstreamPaused
+			}
+			if err := waitUntil(ctx, func() (bool, time.Duration) {
+				pause := l.adaptive.PausedFor()
+				return pause <= 0, pause
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	if limit := l.concurrencyLimit(); limit > 0 {
+		key := l.key + ":concurrency"
+		if err := waitUntil(ctx, func() (bool, time.Duration) {
+			release, ok := acquireSlot(ctx, key, limit)
+			if ok {
+				p.releases = append(p.releases, release)
+			}
+			return ok, slotPollInterval
+		}); err != nil {
+			return err
+		}
+	}
+	if l.limits.QPS > 0 {
+		if err := waitUntil(ctx, func() (bool, time.Duration) {
+			return allowTokenBucket(ctx, l.key+":qps", l.limits.QPS)
+		}); err != nil {
+			return err
+		}
+	}
+	if l.limits.QPM > 0 {
+		if err := waitUntil(ctx, func() (bool, time.Duration) {
+			return allowSlidingWindow(ctx, l.key+":qpm", int(l.limits.QPM))
+		}); err != nil {
+			return err
+		}
+	}
+	if l.tokens != nil {
+		r, err := WaitTokens(ctx, tokens, l.tokens)
+		if err != nil {
+			return err
+		}
+		if p.tokens == nil {
+			p.tokens = r
+		} else {
+			p.tokens.reserved = append(p.tokens.reserved, r.reserved...)
+		}
+	}
+	return nil
 }
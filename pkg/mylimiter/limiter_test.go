@@ -0,0 +1,80 @@
+package mylimiter
+
+import (
+	"context"
+	"errors"
+	"simple-one-api/pkg/mylog"
+	"testing"
+	"time"
+)
+
+func TestAcquireCombinedLimits(t *testing.T) {
+	mylog.InitLog("prod")
+	defer SetStore(NewMemoryStore())
+	SetStore(NewMemoryStore())
+
+	// qps和concurrency同时生效
+	lim := NewLimiter(Limits{QPS: 100, Concurrency: 1})
+	permit, err := Acquire(context.Background(), 0, lim)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err = Acquire(ctx, 0, lim); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected concurrency wait to time out, got %v", err)
+	}
+
+	permit.Release()
+	permit.Release()
+	if permit, err = Acquire(context.Background(), 0, lim); err != nil {
+		t.Fatalf("acquire after release failed: %v", err)
+	}
+	permit.Release()
+
+	// 后面的限制超时时，已经获取的并发许可和预留的token都被释放
+	service := NewLimiter(Limits{Concurrency: 1, TPM: 1000})
+	credential := NewLimiter(Limits{QPM: 1})
+	if permit, err = Acquire(context.Background(), 100, credential); err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	permit.Release()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = Acquire(ctx, 100, service, credential); err == nil {
+		t.Fatalf("expected qpm wait to time out")
+	}
+	if permit, err = Acquire(context.Background(), 1000, service); err != nil {
+		t.Fatalf("expected released concurrency and tokens, got %v", err)
+	}
+
+	permit.Release()
+
+	// panic时释放已经获取的许可
+	SetStore(&panicStore{MemoryStore: NewMemoryStore()})
+	lim = NewLimiter(Limits{QPS: 1, Concurrency: 1})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected panic")
+			}
+		}()
+		Acquire(context.Background(), 0, lim)
+	}()
+	slotID, ok, _ := GetStore().AcquireSlot(context.Background(), lim.key+":concurrency", 1)
+	if !ok {
+		t.Fatalf("expected concurrency slot released after panic")
+	}
+	GetStore().ReleaseSlot(context.Background(), lim.key+":concurrency", slotID)
+}
+
+// panicStore 检查qps时panic
+type panicStore struct {
+	*MemoryStore
+}
+
+func (s *panicStore) AllowTokenBucket(context.Context, string, float64, int) (bool, time.Duration, error) {
+	panic("store failure")
+}
@@ -0,0 +1,322 @@
+package mylimiter
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 请求优先级，限流器饱和时优先级高的请求先通过
+const (
+	PriorityLow = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+// ErrRequestShed 排队的预计等待时间超过请求的timeout，请求被提前拒绝
+var ErrRequestShed = errors.New("request shed, estimated queue wait exceeds timeout")
+
+// turnEWMAAlpha 每个排队轮次耗时的指数移动平均系数，用于估算排队等待时间
+const turnEWMAAlpha = 0.2
+
+// ParsePriority 解析low、normal、high，无法识别时返回false
+func ParsePriority(s string) (int, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "low":
+		return PriorityLow, true
+	case "normal":
+		return PriorityNormal, true
+	case "high":
+		return PriorityHigh, true
+	}
+	return PriorityNormal, false
+}
+
+// PriorityName 返回优先级的名称
+func PriorityName(p int) string {
+	switch {
+	case p <= PriorityLow:
+		return "low"
+	case p >= PriorityHigh:
+		return "high"
+	}
+	return "normal"
+}
+
+// RequestClass 请求在限流队列中的类别：优先级，以及按Key(一般为API key)加权公平分配时的权重
+type RequestClass struct {
+	Priority int
+	Key      string
+	Weight   float64
+}
+
+type requestClassKey struct{}
+
+// WithRequestClass 把请求的类别保存到ctx中，Acquire排队时使用
+func WithRequestClass(ctx context.Context, class RequestClass) context.Context {
+	return context.WithValue(ctx, requestClassKey{}, class)
+}
+
+// RequestClassFromContext 返回ctx中的请求类别，没有时为normal优先级、权重1
+func RequestClassFromContext(ctx context.Context) RequestClass {
+	class, ok := ctx.Value(requestClassKey{}).(RequestClass)
+	if !ok {
+		class = RequestClass{Priority: PriorityNormal}
+	}
+	if class.Weight <= 0 {
+		class.Weight = 1
+	}
+	return class
+}
+
+// queueWaiter 排队中的请求
+type queueWaiter struct {
+	class    RequestClass
+	start    float64
+	finish   float64
+	seq      uint64
+	enqueued time.Time
+	deadline time.Time
+	ready    chan error
+}
+
+// fairQueue 一个限流器的等待队列。同一时间只有一个请求(持有轮次)在等待限流器的限制，其他请求在队列中排队；
+// 轮次释放时先选优先级高的请求，同一优先级内按加权公平队列(WFQ)的完成标签在不同Key之间按权重分配
+type fairQueue struct {
+	mu        sync.Mutex
+	key       string
+	busy      bool
+	waiters   []*queueWaiter
+	seq       uint64
+	vclock    float64
+	finish    map[string]float64
+	turnStart time.Time
+	avgTurn   time.Duration
+
+	granted   uint64
+	shed      uint64
+	canceled  uint64
+	totalWait time.Duration
+	maxWait   time.Duration
+}
+
+var (
+	queueMap   = make(map[string]*fairQueue)
+	queueMutex sync.Mutex
+)
+
+// getQueue 返回限流器key对应的队列，队列只在进程内生效
+func getQueue(key string) *fairQueue {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	q, exists := queueMap[key]
+	if !exists {
+		q = &fairQueue{key: key, finish: make(map[string]float64)}
+		queueMap[key] = q
+	}
+	return q
+}
+
+// before 判断a是否排在b前面：优先级高的在前，同一优先级按完成标签，最后按到达顺序
+func (a *queueWaiter) before(b *queueWaiter) bool {
+	if a.class.Priority != b.class.Priority {
+		return a.class.Priority > b.class.Priority
+	}
+	if a.finish != b.finish {
+		return a.finish < b.finish
+	}
+	return a.seq < b.seq
+}
+
+// enter 等待轮到ctx中的请求，成功时返回释放轮次的函数，通过限流器的限制后调用
+func (q *fairQueue) enter(ctx context.Context) (func(), error) {
+	class := RequestClassFromContext(ctx)
+	now := time.Now()
+
+	q.mu.Lock()
+	q.seq++
+	w := &queueWaiter{class: class, seq: q.seq, enqueued: now, ready: make(chan error, 1)}
+	w.start = q.finish[class.Key]
+	if w.start < q.vclock {
+		w.start = q.vclock
+	}
+	w.finish = w.start + 1/class.Weight
+	q.finish[class.Key] = w.finish
+	if deadline, ok := ctx.Deadline(); ok {
+		w.deadline = deadline
+	}
+
+	if !q.busy {
+		q.grant(w, now)
+		q.mu.Unlock()
+		return q.leave, nil
+	}
+	q.waiters = append(q.waiters, w)
+	q.shedLocked(now)
+	q.mu.Unlock()
+
+	select {
+	case err := <-w.ready:
+		if err != nil {
+			return nil, err
+		}
+		return q.leave, nil
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	for i, other := range q.waiters {
+		if other == w {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			q.canceled++
+			q.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+	q.mu.Unlock()
+
+	// 已经被分配了轮次或者被拒绝
+	if err := <-w.ready; err != nil {
+		return nil, err
+	}
+	q.leave()
+	return nil, ctx.Err()
+}
+
+// grant 把轮次分配给w，调用时持有锁
+func (q *fairQueue) grant(w *queueWaiter, now time.Time) {
+	q.busy = true
+	q.turnStart = now
+	q.vclock = w.start
+	q.granted++
+	wait := now.Sub(w.enqueued)
+	q.totalWait += wait
+	if wait > q.maxWait {
+		q.maxWait = wait
+	}
+}
+
+// leave 释放轮次，分配给队列中排在最前面的请求
+func (q *fairQueue) leave() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	turn := now.Sub(q.turnStart)
+	if q.avgTurn == 0 {
+		q.avgTurn = turn
+	} else {
+		q.avgTurn += time.Duration(turnEWMAAlpha * float64(turn-q.avgTurn))
+	}
+
+	if len(q.waiters) == 0 {
+		// 队列空闲后重新开始计算完成标签，避免长期累积
+		q.busy = false
+		q.vclock = 0
+		q.finish = make(map[string]float64)
+		return
+	}
+
+	next := 0
+	for i := 1; i < len(q.waiters); i++ {
+		if q.waiters[i].before(q.waiters[next]) {
+			next = i
+		}
+	}
+	w := q.waiters[next]
+	q.waiters = append(q.waiters[:next], q.waiters[next+1:]...)
+	q.grant(w, now)
+	w.ready <- nil
+}
+
+// shedLocked 按队列中的顺序估算每个请求的等待时间，超过请求timeout的直接拒绝；
+// 优先级低的排在后面，所以最先被拒绝。调用时持有锁
+func (q *fairQueue) shedLocked(now time.Time) {
+	if q.avgTurn <= 0 {
+		return
+	}
+	ordered := make([]*queueWaiter, len(q.waiters))
+	copy(ordered, q.waiters)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].before(ordered[j]) })
+
+	kept := q.waiters[:0]
+	ahead := 1 // 持有轮次的请求
+	shed := make(map[*queueWaiter]bool)
+	for _, w := range ordered {
+		if !w.deadline.IsZero() && now.Add(time.Duration(ahead)*q.avgTurn).After(w.deadline) {
+			shed[w] = true
+			continue
+		}
+		ahead++
+	}
+	for _, w := range q.waiters {
+		if shed[w] {
+			q.shed++
+			w.ready <- ErrRequestShed
+			continue
+		}
+		kept = append(kept, w)
+	}
+	q.waiters = kept
+}
+
+// QueueStats 一个限流器队列的监控数据
+type QueueStats struct {
+	Key             string         `json:"key"`
+	Depth           int            `json:"depth"`
+	DepthByPriority map[string]int `json:"depth_by_priority"`
+	OldestWaitMs    int64          `json:"oldest_wait_ms"`
+	AvgWaitMs       float64        `json:"avg_wait_ms"`
+	MaxWaitMs       int64          `json:"max_wait_ms"`
+	AvgTurnMs       float64        `json:"avg_turn_ms"`
+	Granted         uint64         `json:"granted"`
+	Shed            uint64         `json:"shed"`
+	Canceled        uint64         `json:"canceled"`
+}
+
+func (q *fairQueue) stats(now time.Time) QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	st := QueueStats{
+		Key:             q.key,
+		Depth:           len(q.waiters),
+		DepthByPriority: map[string]int{"low": 0, "normal": 0, "high": 0},
+		MaxWaitMs:       q.maxWait.Milliseconds(),
+		AvgTurnMs:       float64(q.avgTurn) / float64(time.Millisecond),
+		Granted:         q.granted,
+		Shed:            q.shed,
+		Canceled:        q.canceled,
+	}
+	for _, w := range q.waiters {
+		st.DepthByPriority[PriorityName(w.class.Priority)]++
+		if wait := now.Sub(w.enqueued).Milliseconds(); wait > st.OldestWaitMs {
+			st.OldestWaitMs = wait
+		}
+	}
+	if q.granted > 0 {
+		st.AvgWaitMs = float64(q.totalWait) / float64(q.granted) / float64(time.Millisecond)
+	}
+	return st
+}
+
+// GetQueueStats 返回所有限流器队列的当前深度和等待时间，按key排序
+func GetQueueStats() []QueueStats {
+	queueMutex.Lock()
+	queues := make([]*fairQueue, 0, len(queueMap))
+	for _, q := range queueMap {
+		queues = append(queues, q)
+	}
+	queueMutex.Unlock()
+
+	now := time.Now()
+	result := make([]QueueStats, 0, len(queues))
+	for _, q := range queues {
+		result = append(result, q.stats(now))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
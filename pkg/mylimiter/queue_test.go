@@ -0,0 +1,130 @@
+package mylimiter
+
+import (
+	"context"
+	"errors"
+	"simple-one-api/pkg/mylog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitQueued 等待队列中有n个请求排队(不包括持有轮次的请求)
+func waitQueued(t *testing.T, q *fairQueue, n int) {
+	for i := 0; i < 200; i++ {
+		q.mu.Lock()
+		depth, busy := len(q.waiters), q.busy
+		q.mu.Unlock()
+		if busy && depth == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d queued requests", n)
+}
+
+// runQueued 依次让classes中的请求排队，全部通过后返回通过的顺序
+func runQueued(t *testing.T, classes []RequestClass) []string {
+	lim := NewLimiter(Limits{Concurrency: 1})
+	q := getQueue(lim.key)
+	permit, err := Acquire(context.Background(), 0, lim)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	for i, class := range classes {
+		wg.Add(1)
+		go func(class RequestClass) {
+			defer wg.Done()
+			p, err := Acquire(WithRequestClass(context.Background(), class), 0, lim)
+			if err != nil {
+				t.Errorf("acquire failed: %v", err)
+				return
+			}
+			mu.Lock()
+			order = append(order, class.Key)
+			mu.Unlock()
+			p.Release()
+		}(class)
+		// 第一个请求持有轮次，之后的请求排队
+		waitQueued(t, q, i)
+	}
+	permit.Release()
+	wg.Wait()
+	return order
+}
+
+func TestFairQueue(t *testing.T) {
+	mylog.InitLog("prod")
+	defer SetStore(NewMemoryStore())
+	SetStore(NewMemoryStore())
+
+	// 优先级高的请求先通过
+	order := runQueued(t, []RequestClass{
+		{Priority: PriorityNormal, Key: "first"},
+		{Priority: PriorityLow, Key: "low"},
+		{Priority: PriorityNormal, Key: "normal"},
+		{Priority: PriorityHigh, Key: "high"},
+	})
+	if got := strings.Join(order, ","); got != "first,high,normal,low" {
+		t.Fatalf("unexpected order %s", got)
+	}
+
+	// 同一优先级按权重在API key之间分配
+	order = runQueued(t, []RequestClass{
+		{Key: "first"},
+		{Key: "a", Weight: 2}, {Key: "a", Weight: 2}, {Key: "a", Weight: 2},
+		{Key: "b"}, {Key: "b"}, {Key: "b"},
+	})
+	if got := strings.Join(order[1:], ""); got != "aababb" {
+		t.Fatalf("unexpected weighted order %s", got)
+	}
+}
+
+func TestFairQueueShed(t *testing.T) {
+	mylog.InitLog("prod")
+	q := &fairQueue{key: "test", finish: make(map[string]float64)}
+	leave, err := q.enter(context.Background())
+	if err != nil {
+		t.Fatalf("enter failed: %v", err)
+	}
+	q.avgTurn = time.Second
+
+	// 预计等待超过timeout的请求直接被拒绝
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err = q.enter(ctx); !errors.Is(err, ErrRequestShed) {
+		t.Fatalf("expected shed, got %v", err)
+	}
+
+	// 优先级高的请求排到前面后，优先级低的请求先被拒绝
+	lowCtx, cancel := context.WithTimeout(WithRequestClass(context.Background(), RequestClass{Priority: PriorityLow}), 1500*time.Millisecond)
+	defer cancel()
+	lowErr := make(chan error, 1)
+	go func() {
+		_, err := q.enter(lowCtx)
+		lowErr <- err
+	}()
+	waitQueued(t, q, 1)
+
+	highCtx, cancel := context.WithTimeout(WithRequestClass(context.Background(), RequestClass{Priority: PriorityHigh}), 1500*time.Millisecond)
+	defer cancel()
+	go func() {
+		if leave, err := q.enter(highCtx); err == nil {
+			leave()
+		}
+	}()
+	if err = <-lowErr; !errors.Is(err, ErrRequestShed) {
+		t.Fatalf("expected low priority request shed, got %v", err)
+	}
+	leave()
+
+	st := q.stats(time.Now())
+	if st.Shed != 2 {
+		t.Fatalf("expected 2 shed, got %+v", st)
+	}
+}
@@ -0,0 +1,282 @@
+package mylimiter
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"simple-one-api/pkg/mylog"
+	"sync"
+	"time"
+)
+
+// DefaultSlotLease 并发许可的默认租约时间，持有许可的实例异常退出后许可最多保留这么久
+const DefaultSlotLease = 60 * time.Second
+
+// 脚本中使用Redis服务器的时间，避免各实例之间的时钟误差
+const redisNowScript = `
+redis.replicate_commands()
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+`
+
+// KEYS[1] 窗口的有序集合；ARGV: limit, window(ms), member
+var slidingWindowScript = redis.NewScript(redisNowScript + `
+local window = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+if redis.call('ZCARD', KEYS[1]) < tonumber(ARGV[1]) then
+	redis.call('ZADD', KEYS[1], now, ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], window)
+	return {1, 0}
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {0, tonumber(oldest[2]) + window - now}
+`)
+
+// KEYS[1] 令牌桶的hash；ARGV: 每秒生成的令牌数, burst
+var tokenBucketScript = redis.NewScript(redisNowScript + `
+local rate = tonumber(ARGV[1]) / 1000
+local burst = tonumber(ARGV[2])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate) + 1000)
+return {allowed, wait}
+`)
+
+// KEYS[1] 令牌桶的hash；ARGV: rate, burst；只查询，不消耗令牌
+var tokenBucketStatusScript = redis.NewScript(redisNowScript + `
+local rate = tonumber(ARGV[1]) / 1000
+local burst = tonumber(ARGV[2])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+if tokens >= 1 then
+	return {1, 0}
+end
+return {0, math.ceil((1 - tokens) / rate)}
+`)
+
+// KEYS[1] 许可的有序集合，score为租约到期时间；ARGV: limit, lease(ms), slotID
+var acquireSlotScript = redis.NewScript(redisNowScript + `
+local lease = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if redis.call('ZCARD', KEYS[1]) < tonumber(ARGV[1]) then
+	redis.call('ZADD', KEYS[1], now + lease, ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], lease)
+	return 1
+end
+return 0
+`)
+
+// KEYS[1] 许可的有序集合；ARGV: lease(ms), slotID
+var renewSlotScript = redis.NewScript(redisNowScript + `
+local lease = tonumber(ARGV[1])
+if not redis.call('ZSCORE', KEYS[1], ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], now + lease, ARGV[2])
+redis.call('PEXPIRE', KEYS[1], lease)
+return 1
+`)
+
+// token桶共用的恢复逻辑；ARGV[2] limit, ARGV[3] window(ms)
+const redisTokenRefillScript = `
+local limit = tonumber(ARGV[2])
+local rate = limit / tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or limit
+local ts = tonumber(state[2]) or now
+tokens = math.min(limit, tokens + math.max(0, now - ts) * rate)
+`
+
+// 保存token数，到恢复满之前不过期
+const redisTokenSaveScript = `
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((limit - tokens) / rate) + 1000)
+`
+
+// KEYS[1] token桶的hash；ARGV: n, limit, window(ms)
+var reserveTokensScript = redis.NewScript(redisNowScript + redisTokenRefillScript + `
+local n = tonumber(ARGV[1])
+if tokens < n then
+	return {0, math.ceil((n - tokens) / rate)}
+end
+tokens = tokens - n
+` + redisTokenSaveScript + `
+return {1, 0}
+`)
+
+// KEYS[1] token桶的hash；ARGV: delta, limit, window(ms)
+var settleTokensScript = redis.NewScript(redisNowScript + redisTokenRefillScript + `
+tokens = math.min(limit, tokens - tonumber(ARGV[1]))
+` + redisTokenSaveScript + `
+return 1
+`)
+
+// KEYS[1] 窗口的有序集合；ARGV: limit, window(ms)；返回剩余请求数和最新的请求过期还需要的时间
+var slidingWindowStatusScript = redis.NewScript(redisNowScript + `
+local window = tonumber(ARGV[2])
+local count = redis.call('ZCOUNT', KEYS[1], now - window + 1, '+inf')
+if count == 0 then
+	return {tonumber(ARGV[1]), 0}
+end
+local newest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+return {math.max(0, tonumber(ARGV[1]) - count), math.max(0, tonumber(newest[2]) + window - now)}
+`)
+
+// KEYS[1] token桶的hash；ARGV: 未使用, limit, window(ms)；返回剩余token数和恢复满还需要的时间
+var tokensStatusScript = redis.NewScript(redisNowScript + redisTokenRefillScript + `
+return {math.floor(math.max(0, tokens)), math.ceil((limit - tokens) / rate)}
+`)
+
+// RedisStore 基于Redis的限流存储，多个实例共享限制；每种限制都由一个Lua脚本原子地完成检查和记录。
+// 并发许可带有租约，持有期间定时续约，实例异常退出后租约到期自动释放
+type RedisStore struct {
+	client   redis.UniversalClient
+	prefix   string
+	lease    time.Duration
+	renewals sync.Map
+}
+
+func NewRedisStore(client redis.UniversalClient, prefix string, lease time.Duration) *RedisStore {
+	if lease <= 0 {
+		lease = DefaultSlotLease
+	}
+	return &RedisStore{client: client, prefix: prefix, lease: lease}
+}
+
+// parseScriptPair 解析脚本返回的两个整数
+func parseScriptPair(res interface{}) (int64, int64) {
+	values, _ := res.([]interface{})
+	if len(values) != 2 {
+		return 0, 0
+	}
+	a, _ := values[0].(int64)
+	b, _ := values[1].(int64)
+	return a, b
+}
+
+func parseScriptResult(res interface{}) (bool, time.Duration) {
+	allowed, wait := parseScriptPair(res)
+	return allowed == 1, time.Duration(wait) * time.Millisecond
+}
+
+func (s *RedisStore) AllowSlidingWindow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	res, err := slidingWindowScript.Run(ctx, s.client, []string{s.prefix + key},
+		limit, window.Milliseconds(), uuid.NewString()).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	ok, wait := parseScriptResult(res)
+	return ok, wait, nil
+}
+
+func (s *RedisStore) AllowTokenBucket(ctx context.Context, key string, r float64, burst int) (bool, time.Duration, error) {
+	res, err := tokenBucketScript.Run(ctx, s.client, []string{s.prefix + key}, r, burst).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	ok, wait := parseScriptResult(res)
+	return ok, wait, nil
+}
+
+func (s *RedisStore) TokenBucketStatus(ctx context.Context, key string, r float64, burst int) (bool, time.Duration, error) {
+	res, err := tokenBucketStatusScript.Run(ctx, s.client, []string{s.prefix + key}, r, burst).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	ok, wait := parseScriptResult(res)
+	return ok, wait, nil
+}
+
+// UndoSlidingWindow 窗口内的请求只按数量计算，删除最新的一个即可
+func (s *RedisStore) UndoSlidingWindow(ctx context.Context, key string) error {
+	return s.client.ZPopMax(ctx, s.prefix+key, 1).Err()
+}
+
+func (s *RedisStore) AcquireSlot(ctx context.Context, key string, limit int) (string, bool, error) {
+	slotID := uuid.NewString()
+	ok, err := acquireSlotScript.Run(ctx, s.client, []string{s.prefix + key},
+		limit, s.lease.Milliseconds(), slotID).Bool()
+	if err != nil || !ok {
+		return "", false, err
+	}
+
+	renewCtx, cancel := context.WithCancel(context.Background())
+	s.renewals.Store(slotID, cancel)
+	go s.renewSlot(renewCtx, key, slotID)
+	return slotID, true, nil
+}
+
+// renewSlot 在许可释放前定时续约，长时间的流式请求不会因为租约到期而被其他请求占用
+func (s *RedisStore) renewSlot(ctx context.Context, key string, slotID string) {
+	ticker := time.NewTicker(s.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ok, err := renewSlotScript.Run(ctx, s.client, []string{s.prefix + key}, s.lease.Milliseconds(), slotID).Bool()
+			if err != nil {
+				if ctx.Err() == nil {
+					mylog.Logger.Warn("renew concurrency slot error", zap.String("key", key), zap.Error(err))
+				}
+				continue
+			}
+			if !ok {
+				mylog.Logger.Warn("concurrency slot lease lost", zap.String("key", key), zap.String("slot_id", slotID))
+				return
+			}
+		}
+	}
+}
+
+func (s *RedisStore) ReleaseSlot(ctx context.Context, key string, slotID string) error {
+	if cancel, ok := s.renewals.LoadAndDelete(slotID); ok {
+		cancel.(context.CancelFunc)()
+	}
+	return s.client.ZRem(ctx, s.prefix+key, slotID).Err()
+}
+
+func (s *RedisStore) ReserveTokens(ctx context.Context, key string, n int64, limit int64, window time.Duration) (bool, time.Duration, error) {
+	res, err := reserveTokensScript.Run(ctx, s.client, []string{s.prefix + key}, n, limit, window.Milliseconds()).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	ok, wait := parseScriptResult(res)
+	return ok, wait, nil
+}
+
+func (s *RedisStore) SettleTokens(ctx context.Context, key string, delta int64, limit int64, window time.Duration) error {
+	return settleTokensScript.Run(ctx, s.client, []string{s.prefix + key}, delta, limit, window.Milliseconds()).Err()
+}
+
+func (s *RedisStore) SlidingWindowStatus(ctx context.Context, key string, limit int, window time.Duration) (int, time.Duration, error) {
+	res, err := slidingWindowStatusScript.Run(ctx, s.client, []string{s.prefix + key}, limit, window.Milliseconds()).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	remaining, reset := parseScriptPair(res)
+	return int(remaining), time.Duration(reset) * time.Millisecond, nil
+}
+
+func (s *RedisStore) TokensStatus(ctx context.Context, key string, limit int64, window time.Duration) (int64, time.Duration, error) {
+	res, err := tokensStatusScript.Run(ctx, s.client, []string{s.prefix + key}, 0, limit, window.Milliseconds()).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	remaining, reset := parseScriptPair(res)
+	return remaining, time.Duration(reset) * time.Millisecond, nil
+}
@@ -0,0 +1,115 @@
+package mylimiter
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"simple-one-api/pkg/mycomdef"
+	"simple-one-api/pkg/mylog"
+	"testing"
+	"time"
+)
+
+// newTestRedisStores 返回连接同一个miniredis的两个存储，模拟两个实例
+func newTestRedisStores(t *testing.T) (*miniredis.Miniredis, *RedisStore, *RedisStore) {
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Unix(1700000000, 0))
+	newStore := func() *RedisStore {
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { client.Close() })
+		return NewRedisStore(client, "test:", time.Minute)
+	}
+	return mr, newStore(), newStore()
+}
+
+func TestRedisStore(t *testing.T) {
+	mylog.InitLog("prod")
+	mr, a, b := newTestRedisStores(t)
+	ctx := context.Background()
+
+	// 滑动窗口在两个实例之间共享
+	for _, s := range []*RedisStore{a, b} {
+		if ok, _, err := s.AllowSlidingWindow(ctx, "rpm", 2, time.Minute); err != nil || !ok {
+			t.Fatalf("expected allowed, got %v %v", ok, err)
+		}
+	}
+	if ok, wait, _ := a.AllowSlidingWindow(ctx, "rpm", 2, time.Minute); ok || wait <= 0 || wait > time.Minute {
+		t.Fatalf("expected denied with wait, got %v %v", ok, wait)
+	}
+	if remaining, reset, _ := b.SlidingWindowStatus(ctx, "rpm", 2, time.Minute); remaining != 0 || reset != time.Minute {
+		t.Fatalf("expected no remaining requests, got %v %v", remaining, reset)
+	}
+	mr.SetTime(time.Unix(1700000061, 0))
+	if ok, _, _ := b.AllowSlidingWindow(ctx, "rpm", 2, time.Minute); !ok {
+		t.Fatalf("expected allowed after window")
+	}
+
+	// 令牌桶
+	if ok, _, _ := a.AllowTokenBucket(ctx, "qps", 1, 1); !ok {
+		t.Fatalf("expected first token")
+	}
+	if ok, wait, _ := b.AllowTokenBucket(ctx, "qps", 1, 1); ok || wait != time.Second {
+		t.Fatalf("expected denied for 1s, got %v %v", ok, wait)
+	}
+	mr.SetTime(time.Unix(1700000062, 0))
+	if ok, _, _ := b.AllowTokenBucket(ctx, "qps", 1, 1); !ok {
+		t.Fatalf("expected token after refill")
+	}
+
+	// token桶，实际用量超出预留时从之后恢复的token中扣除
+	if ok, _, _ := a.ReserveTokens(ctx, "tpm", 600, 1000, time.Minute); !ok {
+		t.Fatalf("expected tokens reserved")
+	}
+	if err := b.SettleTokens(ctx, "tpm", 600, 1000, time.Minute); err != nil {
+		t.Fatalf("settle error: %v", err)
+	}
+	if ok, wait, _ := b.ReserveTokens(ctx, "tpm", 100, 1000, time.Minute); ok || wait != 18*time.Second {
+		t.Fatalf("expected denied for 18s, got %v %v", ok, wait)
+	}
+	if remaining, reset, _ := a.TokensStatus(ctx, "tpm", 1000, time.Minute); remaining != 0 || reset != 72*time.Second {
+		t.Fatalf("expected no remaining tokens, got %v %v", remaining, reset)
+	}
+
+	// 并发许可
+	slotID, ok, err := a.AcquireSlot(ctx, "concurrency", 1)
+	if err != nil || !ok {
+		t.Fatalf("expected slot, got %v %v", ok, err)
+	}
+	if _, ok, _ = b.AcquireSlot(ctx, "concurrency", 1); ok {
+		t.Fatalf("expected slot denied on other instance")
+	}
+	if err = a.ReleaseSlot(ctx, "concurrency", slotID); err != nil {
+		t.Fatalf("release error: %v", err)
+	}
+	if _, ok, _ = b.AcquireSlot(ctx, "concurrency", 1); !ok {
+		t.Fatalf("expected slot after release")
+	}
+	// 持有许可的实例退出后，租约到期自动释放
+	mr.SetTime(time.Unix(1700000123, 0))
+	if _, ok, _ = a.AcquireSlot(ctx, "concurrency", 1); !ok {
+		t.Fatalf("expected slot after lease expired")
+	}
+}
+
+func TestKeyLimiterWithRedisStore(t *testing.T) {
+	mylog.InitLog("prod")
+	_, a, b := newTestRedisStores(t)
+	defer SetStore(NewMemoryStore())
+
+	SetStore(a)
+	release, err := newKeyLimiter(storeKeyOf("sk-a"), 0, 0, 1).TryAcquire()
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+
+	SetStore(b)
+	var rlErr *RateLimitError
+	if _, err = newKeyLimiter(storeKeyOf("sk-a"), 0, 0, 1).TryAcquire(); !errors.As(err, &rlErr) || rlErr.Limit != mycomdef.KEYNAME_CONCURRENCY {
+		t.Fatalf("expected concurrency limit error, got %v", err)
+	}
+	release()
+	if _, err = newKeyLimiter(storeKeyOf("sk-a"), 0, 0, 1).TryAcquire(); err != nil {
+		t.Fatalf("acquire after release failed: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package mylimiter
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"simple-one-api/pkg/mylog"
+	"time"
+)
+
+// RateLimitStatus 一项限制的当前状态，用于返回OpenAI格式的x-ratelimit-*响应头；Reset为恢复到初始状态还需要的时间
+type RateLimitStatus struct {
+	Limit     int64
+	Remaining int64
+	Reset     time.Duration
+}
+
+// Tighter 返回两者中剩余比例更小的状态，没有限制(Limit为0)的一方不参与比较
+func (s RateLimitStatus) Tighter(other RateLimitStatus) RateLimitStatus {
+	if s.Limit <= 0 {
+		return other
+	}
+	if other.Limit <= 0 {
+		return s
+	}
+	if other.Remaining*s.Limit < s.Remaining*other.Limit ||
+		(other.Remaining*s.Limit == s.Remaining*other.Limit && other.Reset > s.Reset) {
+		return other
+	}
+	return s
+}
+
+// slidingWindowStatus 查询滑动窗口的状态，Store出错时返回false
+func slidingWindowStatus(ctx context.Context, key string, limit int) (RateLimitStatus, bool) {
+	remaining, reset, err := GetStore().SlidingWindowStatus(ctx, key, limit, time.Minute)
+	if err != nil {
+		mylog.Logger.Warn("rate limit store status error", zap.String("key", key), zap.Error(err))
+		return RateLimitStatus{}, false
+	}
+	return RateLimitStatus{Limit: int64(limit), Remaining: int64(remaining), Reset: reset}, true
+}
+
+// RequestStatus 返回API key的rpm状态，没有配置rpm时返回false
+func (l *KeyLimiter) RequestStatus(ctx context.Context) (RateLimitStatus, bool) {
+	if l == nil || l.rpm <= 0 {
+		return RateLimitStatus{}, false
+	}
+	return slidingWindowStatus(ctx, l.key+":rpm", l.rpm)
+}
+
+// RequestStatus 返回限流器的qpm状态，没有配置qpm时返回false
+func (l *Limiter) RequestStatus(ctx context.Context) (RateLimitStatus, bool) {
+	if l == nil || l.limits.QPM <= 0 {
+		return RateLimitStatus{}, false
+	}
+	return slidingWindowStatus(ctx, l.key+":qpm", int(l.limits.QPM))
+}
+
+// TokenStatus 返回限流器的tpm状态，没有配置tpm时返回false
+func (l *Limiter) TokenStatus(ctx context.Context) (RateLimitStatus, bool) {
+	if l == nil {
+		return RateLimitStatus{}, false
+	}
+	return l.tokens.Status(ctx)
+}
+
+// Status 返回tpm的状态，与OpenAI一致tokens相关的响应头只反映每分钟的限制；没有配置tpm时返回false
+func (l *TokenLimiter) Status(ctx context.Context) (RateLimitStatus, bool) {
+	if l == nil {
+		return RateLimitStatus{}, false
+	}
+	for _, tl := range l.limits {
+		if tl.window != time.Minute {
+			continue
+		}
+		remaining, reset, err := GetStore().TokensStatus(ctx, tl.key, tl.limit, tl.window)
+		if err != nil {
+			mylog.Logger.Warn("rate limit store status error", zap.String("key", tl.key), zap.Error(err))
+			return RateLimitStatus{}, false
+		}
+		return RateLimitStatus{Limit: tl.limit, Remaining: remaining, Reset: reset}, true
+	}
+	return RateLimitStatus{}, false
+}
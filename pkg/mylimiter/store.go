@@ -0,0 +1,260 @@
+package mylimiter
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"sync"
+	"time"
+)
+
+// Store 保存限流状态，默认保存在进程内存中；多实例部署时使用RedisStore，所有实例共享同一份限制
+type Store interface {
+	// AllowSlidingWindow 滑动窗口，window内最多limit个请求；不允许时返回到窗口内最早的请求过期还需要等待的时间
+	AllowSlidingWindow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error)
+	// AllowTokenBucket 令牌桶，每秒生成r个令牌，桶的容量为burst；不允许时返回到生成下一个令牌还需要等待的时间
+	AllowTokenBucket(ctx context.Context, key string, r float64, burst int) (bool, time.Duration, error)
+	// TokenBucketStatus 查询令牌桶当前是否有令牌，没有时返回到生成下一个令牌还需要等待的时间，不消耗令牌
+	TokenBucketStatus(ctx context.Context, key string, r float64, burst int) (bool, time.Duration, error)
+	// UndoSlidingWindow 撤销滑动窗口最近记录的一个请求，用于请求被其他限制拒绝时归还次数
+	UndoSlidingWindow(ctx context.Context, key string) error
+	// AcquireSlot 获取一个并发许可，成功时返回许可的ID，释放时使用
+	AcquireSlot(ctx context.Context, key string, limit int) (string, bool, error)
+	// ReleaseSlot 释放AcquireSlot获取的许可
+	ReleaseSlot(ctx context.Context, key string, slotID string) error
+	// ReserveTokens token桶，容量为limit，每个window恢复limit个token；预留n个token，不足时不预留并返回还需要等待的时间
+	ReserveTokens(ctx context.Context, key string, n int64, limit int64, window time.Duration) (bool, time.Duration, error)
+	// SettleTokens 按实际用量结算预留的token，delta为实际用量减去预留量，为负时归还；
+	// 实际用量超出时token数可以为负，超出的部分从之后恢复的token中扣除
+	SettleTokens(ctx context.Context, key string, delta int64, limit int64, window time.Duration) error
+	// SlidingWindowStatus 查询滑动窗口剩余的请求数，以及到窗口内的请求全部过期还需要的时间，不记录请求
+	SlidingWindowStatus(ctx context.Context, key string, limit int, window time.Duration) (int, time.Duration, error)
+	// TokensStatus 查询token桶剩余的token数，以及到恢复满还需要的时间，不预留token
+	TokensStatus(ctx context.Context, key string, limit int64, window time.Duration) (int64, time.Duration, error)
+}
+
+var (
+	store      Store = NewMemoryStore()
+	storeMutex sync.RWMutex
+)
+
+// SetStore 设置所有限流器使用的存储
+func SetStore(s Store) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	store = s
+}
+
+// GetStore 返回当前使用的存储
+func GetStore() Store {
+	storeMutex.RLock()
+	defer storeMutex.RUnlock()
+	return store
+}
+
+// MemoryStore 进程内的限流存储，只在单个实例内生效
+type MemoryStore struct {
+	mu      sync.Mutex
+	windows map[string]*SlidingWindowLimiter
+	buckets map[string]*rate.Limiter
+	slots   map[string]int
+	tokens  map[string]*tokenBucket
+}
+
+// tokenBucket MemoryStore中的token桶
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill 按经过的时间恢复token，不超过limit
+func (b *tokenBucket) refill(now time.Time, limit int64, window time.Duration) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += float64(limit) * float64(elapsed) / float64(window)
+	}
+	if b.tokens > float64(limit) {
+		b.tokens = float64(limit)
+	}
+	b.last = now
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		windows: make(map[string]*SlidingWindowLimiter),
+		buckets: make(map[string]*rate.Limiter),
+		slots:   make(map[string]int),
+		tokens:  make(map[string]*tokenBucket),
+	}
+}
+
+func (s *MemoryStore) AllowSlidingWindow(_ context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	s.mu.Lock()
+	l, exists := s.windows[key]
+	if !exists {
+		l = &SlidingWindowLimiter{interval: window}
+		s.windows[key] = l
+	}
+	s.mu.Unlock()
+
+	// 限制值可能因为配置热加载而变化
+	l.mu.Lock()
+	l.maxRequests = limit
+	l.interval = window
+	l.mu.Unlock()
+
+	ok, wait := l.TryAllow()
+	return ok, wait, nil
+}
+
+func (s *MemoryStore) AllowTokenBucket(_ context.Context, key string, r float64, burst int) (bool, time.Duration, error) {
+	s.mu.Lock()
+	l, exists := s.buckets[key]
+	if !exists {
+		l = rate.NewLimiter(rate.Limit(r), burst)
+		s.buckets[key] = l
+	}
+	s.mu.Unlock()
+
+	if l.Limit() != rate.Limit(r) {
+		l.SetLimit(rate.Limit(r))
+	}
+	if l.Burst() != burst {
+		l.SetBurst(burst)
+	}
+
+	reservation := l.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return false, delay, nil
+	}
+	return true, 0, nil
+}
+
+func (s *MemoryStore) TokenBucketStatus(_ context.Context, key string, r float64, _ int) (bool, time.Duration, error) {
+	s.mu.Lock()
+	l, exists := s.buckets[key]
+	s.mu.Unlock()
+	if !exists {
+		return true, 0, nil
+	}
+
+	if tokens := l.Tokens(); tokens < 1 {
+		return false, time.Duration((1 - tokens) / r * float64(time.Second)), nil
+	}
+	return true, 0, nil
+}
+
+func (s *MemoryStore) UndoSlidingWindow(_ context.Context, key string) error {
+	s.mu.Lock()
+	l, exists := s.windows[key]
+	s.mu.Unlock()
+	if !exists {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n := len(l.requests); n > 0 {
+		l.requests = l.requests[:n-1]
+	}
+	return nil
+}
+
+func (s *MemoryStore) AcquireSlot(_ context.Context, key string, limit int) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.slots[key] >= limit {
+		return "", false, nil
+	}
+	s.slots[key]++
+	return "", true, nil
+}
+
+func (s *MemoryStore) ReleaseSlot(_ context.Context, key string, _ string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.slots[key] > 0 {
+		s.slots[key]--
+	}
+	return nil
+}
+
+func (s *MemoryStore) getTokenBucket(key string, limit int64, window time.Duration) *tokenBucket {
+	b, exists := s.tokens[key]
+	if !exists {
+		b = &tokenBucket{tokens: float64(limit), last: time.Now()}
+		s.tokens[key] = b
+	}
+	b.refill(time.Now(), limit, window)
+	return b
+}
+
+func (s *MemoryStore) ReserveTokens(_ context.Context, key string, n int64, limit int64, window time.Duration) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.getTokenBucket(key, limit, window)
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		return true, 0, nil
+	}
+	return false, time.Duration((float64(n) - b.tokens) / float64(limit) * float64(window)), nil
+}
+
+func (s *MemoryStore) SettleTokens(_ context.Context, key string, delta int64, limit int64, window time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.getTokenBucket(key, limit, window)
+	b.tokens -= float64(delta)
+	if b.tokens > float64(limit) {
+		b.tokens = float64(limit)
+	}
+	return nil
+}
+
+func (s *MemoryStore) SlidingWindowStatus(_ context.Context, key string, limit int, window time.Duration) (int, time.Duration, error) {
+	s.mu.Lock()
+	l, exists := s.windows[key]
+	s.mu.Unlock()
+	if !exists {
+		return limit, 0, nil
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count, newest := 0, time.Time{}
+	for _, t := range l.requests {
+		if t.After(now.Add(-window)) {
+			count++
+			newest = t
+		}
+	}
+	if count == 0 {
+		return limit, 0, nil
+	}
+	remaining := limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, newest.Add(window).Sub(now), nil
+}
+
+func (s *MemoryStore) TokensStatus(_ context.Context, key string, limit int64, window time.Duration) (int64, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, exists := s.tokens[key]
+	if !exists {
+		return limit, 0, nil
+	}
+	b.refill(time.Now(), limit, window)
+	remaining, reset := tokensStatusOf(b.tokens, limit, window)
+	return remaining, reset, nil
+}
+
+// tokensStatusOf 剩余的token数(不小于0)和到恢复满还需要的时间
+func tokensStatusOf(tokens float64, limit int64, window time.Duration) (int64, time.Duration) {
+	reset := time.Duration((float64(limit) - tokens) / float64(limit) * float64(window))
+	if tokens < 0 {
+		tokens = 0
+	}
+	return int64(tokens), reset
+}
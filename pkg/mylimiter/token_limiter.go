@@ -0,0 +1,126 @@
+package mylimiter
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"simple-one-api/pkg/mycomdef"
+	"simple-one-api/pkg/mylog"
+	"sync"
+	"time"
+)
+
+// tokenLimit 一项token限制，window内最多limit个token
+type tokenLimit struct {
+	name   string
+	key    string
+	limit  int64
+	window time.Duration
+}
+
+// TokenLimiter tpm/tpd限制，调用前按估算的token数预留，调用后按实际用量结算
+type TokenLimiter struct {
+	limits []tokenLimit
+}
+
+// GetTokenLimiter 创建key对应的token限制，tpm和tpd都不大于0时返回nil
+func GetTokenLimiter(key string, tpm float64, tpd float64) *TokenLimiter {
+	return newTokenLimiter("tokens:"+key, tpm, tpd)
+}
+
+// newTokenLimiter key为限流状态在Store中的键
+func newTokenLimiter(key string, tpm float64, tpd float64) *TokenLimiter {
+	lim := &TokenLimiter{}
+	if tpm > 0 {
+		lim.limits = append(lim.limits, tokenLimit{name: mycomdef.KEYNAME_TPM, key: key + ":tpm", limit: int64(tpm), window: time.Minute})
+	}
+	if tpd > 0 {
+		lim.limits = append(lim.limits, tokenLimit{name: mycomdef.KEYNAME_TPD, key: key + ":tpd", limit: int64(tpd), window: 24 * time.Hour})
+	}
+	if len(lim.limits) == 0 {
+		return nil
+	}
+	return lim
+}
+
+// GetAPIKeyTokenLimiter 与GetTokenLimiter相同，API key不以明文出现在Store的键中
+func GetAPIKeyTokenLimiter(key string, tpm float64, tpd float64) *TokenLimiter {
+	return GetTokenLimiter(storeKeyOf(key), tpm, tpd)
+}
+
+// tokenReserved 一项token限制中已经预留的数量
+type tokenReserved struct {
+	tokenLimit
+	tokens int64
+}
+
+// TokenReservation 预留的token，请求结束后调用Settle结算，nil表示没有预留
+type TokenReservation struct {
+	store    Store
+	reserved []tokenReserved
+	once     sync.Once
+}
+
+// Settle 按实际用量结算，只有第一次调用生效；调用失败没有产生用量时传0归还全部预留
+func (r *TokenReservation) Settle(actual int64) {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		for _, res := range r.reserved {
+			if delta := actual - res.tokens; delta != 0 {
+				if err := r.store.SettleTokens(context.Background(), res.key, delta, res.limit, res.window); err != nil {
+					mylog.Logger.Error("settle tokens error", zap.String("key", res.key), zap.Error(err))
+				}
+			}
+		}
+	})
+}
+
+// tryReserveTokens 在所有限制中预留n个token，任一项不足时归还已经预留的，返回不足的限制和需要等待的时间。
+// 单个请求的预留量不超过限制本身，避免超大请求永远无法通过；Store出错的限制不预留
+func tryReserveTokens(ctx context.Context, n int64, limiters []*TokenLimiter) (*TokenReservation, string, time.Duration) {
+	r := &TokenReservation{store: GetStore()}
+	for _, lim := range limiters {
+		if lim == nil {
+			continue
+		}
+		for _, l := range lim.limits {
+			tokens := n
+			if tokens > l.limit {
+				tokens = l.limit
+			}
+
+			ok, wait, err := r.store.ReserveTokens(ctx, l.key, tokens, l.limit, l.window)
+			if err != nil {
+				mylog.Logger.Error("rate limit store error, request allowed", zap.String("key", l.key), zap.Error(err))
+				continue
+			}
+			if !ok {
+				r.Settle(0)
+				return nil, l.name, wait
+			}
+			r.reserved = append(r.reserved, tokenReserved{tokenLimit: l, tokens: tokens})
+		}
+	}
+	return r, "", 0
+}
+
+// TryReserveTokens 预留n个token，不等待，不足时返回*RateLimitError
+func TryReserveTokens(n int64, limiters ...*TokenLimiter) (*TokenReservation, error) {
+	r, limit, wait := tryReserveTokens(context.Background(), n, limiters)
+	if r == nil {
+		return nil, &RateLimitError{Limit: limit, RetryAfter: wait}
+	}
+	return r, nil
+}
+
+// WaitTokens 等待直到预留n个token或ctx结束
+func WaitTokens(ctx context.Context, n int64, limiters ...*TokenLimiter) (*TokenReservation, error) {
+	var r *TokenReservation
+	err := waitUntil(ctx, func() (bool, time.Duration) {
+		var wait time.Duration
+		r, _, wait = tryReserveTokens(ctx, n, limiters)
+		return r != nil, wait
+	})
+	return r, err
+}
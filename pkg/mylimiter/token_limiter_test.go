@@ -0,0 +1,45 @@
+package mylimiter
+
+import (
+	"errors"
+	"simple-one-api/pkg/mycomdef"
+	"simple-one-api/pkg/mylog"
+	"testing"
+)
+
+func TestTokenReservation(t *testing.T) {
+	mylog.InitLog("prod")
+	defer SetStore(NewMemoryStore())
+	SetStore(NewMemoryStore())
+
+	lim := GetTokenLimiter("svc", 1000, 5000)
+	r, err := TryReserveTokens(800, lim)
+	if err != nil {
+		t.Fatalf("reserve failed: %v", err)
+	}
+
+	var rlErr *RateLimitError
+	if _, err = TryReserveTokens(300, lim); !errors.As(err, &rlErr) || rlErr.Limit != mycomdef.KEYNAME_TPM || rlErr.RetryAfter <= 0 {
+		t.Fatalf("expected tpm limit error, got %v", err)
+	}
+
+	// 实际用量少于预留，归还多余的token
+	r.Settle(100)
+	r.Settle(800)
+	if _, err = TryReserveTokens(850, lim); err != nil {
+		t.Fatalf("reserve after settle failed: %v", err)
+	}
+
+	// tpd在tpm之后检查，不足时tpm的预留被归还
+	daily := GetTokenLimiter("svc-daily", 1000, 1200)
+	if _, err = TryReserveTokens(1000, daily); err != nil {
+		t.Fatalf("reserve failed: %v", err)
+	}
+	if _, err = TryReserveTokens(500, GetTokenLimiter("svc-daily", 0, 1200)); !errors.As(err, &rlErr) || rlErr.Limit != mycomdef.KEYNAME_TPD {
+		t.Fatalf("expected tpd limit error, got %v", err)
+	}
+
+	if GetTokenLimiter("none", 0, 0) != nil {
+		t.Fatalf("expected nil limiter without limits")
+	}
+}
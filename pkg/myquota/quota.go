@@ -0,0 +1,242 @@
+package myquota
+
+import (
+	"encoding/json"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mylog"
+	"simple-one-api/pkg/mystore"
+	"simple-one-api/pkg/utils"
+	"sort"
+	"time"
+)
+
+const (
+	QUOTA_PERIOD_DAILY   = "daily"
+	QUOTA_PERIOD_MONTHLY = "monthly"
+)
+
+// quotaBucket 存储token用量的bucket
+const quotaBucket = "quota_usage"
+
+// scopeAllModels key级别的预算使用的scope
+const scopeAllModels = "*"
+
+// Usage 一个周期内的token用量
+type Usage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+func (u Usage) TotalTokens() int64 {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// QuotaError token预算用完时返回的错误
+type QuotaError struct {
+	Scope  string
+	Period string
+}
+
+func (e *QuotaError) Error() string {
+	if e.Scope == scopeAllModels {
+		return fmt.Sprintf("You exceeded your current %s token quota for this API key.", e.Period)
+	}
+	return fmt.Sprintf("You exceeded your current %s token quota for model %s on this API key.", e.Period, e.Scope)
+}
+
+// QuotaStatus 查询剩余额度接口返回的一项预算，剩余为-1表示该项不限制
+type QuotaStatus struct {
+	Scope                     string `json:"scope"`
+	Period                    string `json:"period"`
+	PeriodID                  string `json:"period_id"`
+	Used                      Usage  `json:"used"`
+	PromptTokensLimit         int64  `json:"prompt_tokens_limit"`
+	CompletionTokensLimit     int64  `json:"completion_tokens_limit"`
+	TotalTokensLimit          int64  `json:"total_tokens_limit"`
+	RemainingPromptTokens     int64  `json:"remaining_prompt_tokens"`
+	RemainingCompletionTokens int64  `json:"remaining_completion_tokens"`
+	RemainingTotalTokens      int64  `json:"remaining_total_tokens"`
+}
+
+// isQuotaEnabled 配置了任意一项预算时才需要统计
+func isQuotaEnabled(q *config.QuotaConf) bool {
+	return q.PromptTokens > 0 || q.CompletionTokens > 0 || q.TotalTokens > 0
+}
+
+// HasQuota 判断API key是否配置了token预算
+func HasQuota(keyConfig *config.APIKeyConfig) bool {
+	if keyConfig == nil {
+		return false
+	}
+	if isQuotaEnabled(&keyConfig.Quota) {
+		return true
+	}
+	for _, q := range keyConfig.ModelQuotas {
+		if isQuotaEnabled(&q) {
+			return true
+		}
+	}
+	return false
+}
+
+// getPeriodID 返回当前周期的标识，按服务器本地时间划分，monthly为2006-01，其他为2006-01-02
+func getPeriodID(period string, now time.Time) string {
+	if period == QUOTA_PERIOD_MONTHLY {
+		return now.Format("2006-01")
+	}
+	return now.Format("2006-01-02")
+}
+
+func getPeriod(q *config.QuotaConf) string {
+	if q.Period == QUOTA_PERIOD_MONTHLY {
+		return QUOTA_PERIOD_MONTHLY
+	}
+	return QUOTA_PERIOD_DAILY
+}
+
+func usageKey(apiKey string, scope string, periodID string) string {
+	return utils.HashAPIKey(apiKey) + "|" + scope + "|" + periodID
+}
+
+// quotaScopes 返回请求需要检查的预算：key级别的预算和模型单独的预算
+func quotaScopes(keyConfig *config.APIKeyConfig, model string) map[string]config.QuotaConf {
+	scopes := make(map[string]config.QuotaConf)
+	if isQuotaEnabled(&keyConfig.Quota) {
+		scopes[scopeAllModels] = keyConfig.Quota
+	}
+	if q, exists := keyConfig.ModelQuotas[model]; exists && isQuotaEnabled(&q) {
+		scopes[model] = q
+	}
+	return scopes
+}
+
+func getUsage(key string) (Usage, error) {
+	var u Usage
+	_, err := mystore.GetJSON(quotaBucket, key, &u)
+	return u, err
+}
+
+func isExhausted(q *config.QuotaConf, u Usage) bool {
+	return (q.PromptTokens > 0 && u.PromptTokens >= q.PromptTokens) ||
+		(q.CompletionTokens > 0 && u.CompletionTokens >= q.CompletionTokens) ||
+		(q.TotalTokens > 0 && u.TotalTokens() >= q.TotalTokens)
+}
+
+// CheckQuota 在路由前检查API key的token预算，用完时返回*QuotaError；存储出错时不拦截请求
+func CheckQuota(keyConfig *config.APIKeyConfig, model string) error {
+	if !HasQuota(keyConfig) {
+		return nil
+	}
+
+	now := time.Now()
+	for scope, q := range quotaScopes(keyConfig, model) {
+		period := getPeriod(&q)
+		u, err := getUsage(usageKey(keyConfig.APIKey, scope, getPeriodID(period, now)))
+		if err != nil {
+			mylog.Logger.Error("CheckQuota get usage error", zap.Error(err))
+			continue
+		}
+		if isExhausted(&q, u) {
+			mylog.Logger.Warn("API key quota exhausted",
+				zap.String("scope", scope),
+				zap.String("period", period),
+				zap.Int64("prompt_tokens", u.PromptTokens),
+				zap.Int64("completion_tokens", u.CompletionTokens))
+			return &QuotaError{Scope: scope, Period: period}
+		}
+	}
+	return nil
+}
+
+// RecordUsage 请求完成后累加API key的token用量
+func RecordUsage(keyConfig *config.APIKeyConfig, model string, promptTokens int64, completionTokens int64) {
+	if !HasQuota(keyConfig) || (promptTokens <= 0 && completionTokens <= 0) {
+		return
+	}
+
+	now := time.Now()
+	scopes := quotaScopes(keyConfig, model)
+	err := mystore.Update(quotaBucket, func(b *bolt.Bucket) error {
+		for scope, q := range scopes {
+			key := []byte(usageKey(keyConfig.APIKey, scope, getPeriodID(getPeriod(&q), now)))
+
+			var u Usage
+			if data := b.Get(key); data != nil {
+				if err := json.Unmarshal(data, &u); err != nil {
+					return err
+				}
+			}
+			u.PromptTokens += promptTokens
+			u.CompletionTokens += completionTokens
+
+			data, err := json.Marshal(u)
+			if err != nil {
+				return err
+			}
+			if err = b.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		mylog.Logger.Error("RecordUsage error", zap.Error(err))
+	}
+}
+
+func remaining(limit int64, used int64) int64 {
+	if limit <= 0 {
+		return -1
+	}
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
+
+// GetQuotaStatus 返回API key所有预算在当前周期的用量和剩余额度
+func GetQuotaStatus(keyConfig *config.APIKeyConfig) ([]QuotaStatus, error) {
+	scopes := make(map[string]config.QuotaConf)
+	if isQuotaEnabled(&keyConfig.Quota) {
+		scopes[scopeAllModels] = keyConfig.Quota
+	}
+	for model, q := range keyConfig.ModelQuotas {
+		if isQuotaEnabled(&q) {
+			scopes[model] = q
+		}
+	}
+
+	names := make([]string, 0, len(scopes))
+	for scope := range scopes {
+		names = append(names, scope)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	statuses := make([]QuotaStatus, 0, len(names))
+	for _, scope := range names {
+		q := scopes[scope]
+		period := getPeriod(&q)
+		periodID := getPeriodID(period, now)
+		u, err := getUsage(usageKey(keyConfig.APIKey, scope, periodID))
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, QuotaStatus{
+			Scope:                     scope,
+			Period:                    period,
+			PeriodID:                  periodID,
+			Used:                      u,
+			PromptTokensLimit:         q.PromptTokens,
+			CompletionTokensLimit:     q.CompletionTokens,
+			TotalTokensLimit:          q.TotalTokens,
+			RemainingPromptTokens:     remaining(q.PromptTokens, u.PromptTokens),
+			RemainingCompletionTokens: remaining(q.CompletionTokens, u.CompletionTokens),
+			RemainingTotalTokens:      remaining(q.TotalTokens, u.TotalTokens()),
+		})
+	}
+	return statuses, nil
+}
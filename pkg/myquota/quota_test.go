@@ -0,0 +1,49 @@
+package myquota
+
+import (
+	"errors"
+	"path/filepath"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mylog"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	mylog.InitLog("prod")
+	config.GSOAConf = &config.Configuration{StorePath: filepath.Join(t.TempDir(), "quota.db")}
+	defer func() { config.GSOAConf = nil }()
+
+	keyConfig := &config.APIKeyConfig{
+		APIKey: "sk-test",
+		Quota:  config.QuotaConf{Period: QUOTA_PERIOD_MONTHLY, TotalTokens: 1000},
+		ModelQuotas: map[string]config.QuotaConf{
+			"gpt-4o": {CompletionTokens: 100},
+		},
+	}
+
+	if err := CheckQuota(keyConfig, "gpt-4o"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	RecordUsage(keyConfig, "gpt-4o", 50, 100)
+	var quotaErr *QuotaError
+	if err := CheckQuota(keyConfig, "gpt-4o"); !errors.As(err, &quotaErr) || quotaErr.Scope != "gpt-4o" {
+		t.Fatalf("expected gpt-4o quota exhausted, got %v", err)
+	}
+	if err := CheckQuota(keyConfig, "glm-4"); err != nil {
+		t.Fatalf("unexpected error for glm-4: %v", err)
+	}
+
+	RecordUsage(keyConfig, "glm-4", 800, 100)
+	if err := CheckQuota(keyConfig, "glm-4"); !errors.As(err, &quotaErr) || quotaErr.Scope != scopeAllModels {
+		t.Fatalf("expected key quota exhausted, got %v", err)
+	}
+
+	statuses, err := GetQuotaStatus(keyConfig)
+	if err != nil || len(statuses) != 2 {
+		t.Fatalf("unexpected statuses: %v, %v", statuses, err)
+	}
+	if statuses[0].Scope != scopeAllModels || statuses[0].Used.TotalTokens() != 1050 || statuses[0].RemainingTotalTokens != 0 {
+		t.Fatalf("unexpected key status: %+v", statuses[0])
+	}
+}
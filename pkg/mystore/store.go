@@ -0,0 +1,137 @@
+package mystore
+
+import (
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mylog"
+	"simple-one-api/pkg/utils"
+	"sync"
+	"time"
+)
+
+// DefaultStorePath 未配置store_path时使用的本地数据库文件
+const DefaultStorePath = "data/simple-one-api.db"
+
+var (
+	db     *bolt.DB
+	dbPath string
+	dbLock = &sync.Mutex{}
+)
+
+// getStorePath 返回配置的数据库文件的绝对路径
+func getStorePath() string {
+	path := DefaultStorePath
+	if config.GSOAConf != nil && config.GSOAConf.StorePath != "" {
+		path = config.GSOAConf.StorePath
+	}
+	if absPath, err := utils.ResolveRelativePathToAbsolute(path); err == nil {
+		path = absPath
+	}
+	return path
+}
+
+// DB 返回本地持久化存储(bbolt)，第一次使用时打开，store_path变化后重新打开
+func DB() (*bolt.DB, error) {
+	path := getStorePath()
+
+	dbLock.Lock()
+	defer dbLock.Unlock()
+	if db != nil && dbPath == path {
+		return db, nil
+	}
+	if db != nil {
+		db.Close()
+		db = nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	newDB, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		mylog.Logger.Error("open store error", zap.String("path", path), zap.Error(err))
+		return nil, err
+	}
+	mylog.Logger.Info("store opened", zap.String("path", path))
+
+	db = newDB
+	dbPath = path
+	return db, nil
+}
+
+// GetJSON 读取bucket中key对应的JSON值，不存在时返回false
+func GetJSON(bucket string, key string, v interface{}) (bool, error) {
+	d, err := DB()
+	if err != nil {
+		return false, err
+	}
+
+	found := false
+	err = d.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		data := b.Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, v)
+	})
+	return found, err
+}
+
+// PutJSON 将v序列化为JSON写入bucket
+func PutJSON(bucket string, key string, v interface{}) error {
+	d, err := DB()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return d.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+// Update 在一个写事务中读取并修改bucket，fn的修改与读取是原子的
+func Update(bucket string, fn func(b *bolt.Bucket) error) error {
+	d, err := DB()
+	if err != nil {
+		return err
+	}
+	return d.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+// View 在一个读事务中遍历bucket，bucket不存在时不调用fn
+func View(bucket string, fn func(b *bolt.Bucket) error) error {
+	d, err := DB()
+	if err != nil {
+		return err
+	}
+	return d.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return fn(b)
+	})
+}
@@ -11,18 +11,50 @@ import (
 	"go.uber.org/zap"
 	"io"
 	"net/http"
+	"net/url"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/mycommon"
 	"simple-one-api/pkg/mylog"
 	"simple-one-api/pkg/simple_client"
+	"simple-one-api/pkg/utils"
+	"strings"
 	"sync"
 )
 
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	// 注意：生产环境下应更严格地检查来源
-	CheckOrigin: func(r *http.Request) bool {
-		return true // 允许所有CORS请求
-	},
+	CheckOrigin:     checkOrigin,
+}
+
+// checkOrigin 总是允许同源和没有Origin头的(非浏览器)连接，其他来源需要在ws_allowed_origins中配置，
+// 支持完整的origin(如https://example.com)、*.example.com形式的子域名和允许所有来源的*
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	if config.GSOAConf != nil {
+		hostname := strings.ToLower(u.Hostname())
+		for _, allowed := range config.GSOAConf.WSAllowedOrigins {
+			if allowed == "*" || strings.EqualFold(allowed, origin) {
+				return true
+			}
+			if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(hostname, strings.ToLower(allowed[1:])) {
+				return true
+			}
+		}
+	}
+	mylog.Logger.Warn("websocket origin not allowed", zap.String("origin", origin), zap.String("host", r.Host))
+	return false
 }
 
 type MMFormData struct {
@@ -42,6 +74,17 @@ type MMResp struct {
 }
 
 func WSMultiModelCallHandler(c *gin.Context) {
+	// 浏览器无法为WebSocket设置Authorization头，可以通过token查询参数传API key
+	apiKey := utils.GetAPIKeyFromRequest(c)
+	if apiKey == "" {
+		apiKey = c.Query("token")
+	}
+
+	// 升级连接前校验API key，无效时直接返回401；模型白名单、限流和预算在收到请求后按模型检查
+	if !mycommon.AuthenticateRequest(c, apiKey) {
+		return
+	}
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		mylog.Logger.Error("Upgrade failed", zap.Error(err))
@@ -67,8 +110,15 @@ func WSMultiModelCallHandler(c *gin.Context) {
 	msgId := uuid.New().String()
 
 	for _, modelName := range requestData.Models {
+		// 每个模型分别执行与chat completions相同的鉴权、模型白名单、限流和预算检查
+		authResult, grant, err := mycommon.AuthorizeModel(c.Request.Context(), apiKey, modelName, int64(mycommon.EstimateChatCompletionTokens(&baseRequest)))
+		if err != nil {
+			writeResp(conn, &mu, MMResp{Model: modelName, Result: err.Error(), MsgId: msgId})
+			continue
+		}
+
 		wg.Add(1)
-		go handleModelRequest(&wg, &mu, conn, modelName, baseRequest, msgId)
+		go handleModelRequest(&wg, &mu, conn, modelName, baseRequest, msgId, authResult.Namespace, grant)
 	}
 
 	wg.Wait()
@@ -111,23 +161,44 @@ func constructBaseRequest(requestData *MMFormData) openai.ChatCompletionRequest
 	return baseRequest
 }
 
-func handleModelRequest(wg *sync.WaitGroup, mu *sync.Mutex, conn *websocket.Conn, modelName string, baseRequest openai.ChatCompletionRequest, msgId string) {
+// writeResp 多个模型并发返回结果，写连接时需要加锁
+func writeResp(conn *websocket.Conn, mu *sync.Mutex, resp MMResp) {
+	mu.Lock()
+	defer mu.Unlock()
+	if err := conn.WriteJSON(resp); err != nil {
+		mylog.Logger.Error("Failed to write JSON response", zap.Error(err))
+	}
+}
+
+func handleModelRequest(wg *sync.WaitGroup, mu *sync.Mutex, conn *websocket.Conn, modelName string, baseRequest openai.ChatCompletionRequest, msgId string, namespace string, grant *mycommon.KeyGrant) {
 	defer wg.Done()
 
+	// 与chat completions一样按实际用量结算tpm/tpd并计入API key的预算
+	usage := &mycommon.ChatUsage{}
+	defer grant.Done(usage)
+
 	modelReq := baseRequest
 	modelReq.Model = modelName
 
-	client := simple_client.NewSimpleClient("")
+	client := simple_client.NewSimpleClientWithNamespace(namespace)
 	chatStream, err := client.CreateChatCompletionStream(context.Background(), modelReq)
 	if err != nil {
 		mylog.Logger.Error("Failed to create chat completion stream", zap.Error(err))
 		return
 	}
 
-	processChatStream(conn, chatStream, msgId, mu, modelName)
+	processChatStream(conn, chatStream, msgId, mu, &modelReq, usage)
 }
 
-func processChatStream(conn *websocket.Conn, chatStream *simple_client.SimpleChatCompletionStream, msgId string, mu *sync.Mutex, modelName string) {
+// processChatStream 把流式结果转发到WebSocket连接，结束时把token用量累加到usage
+func processChatStream(conn *websocket.Conn, chatStream *simple_client.SimpleChatCompletionStream, msgId string, mu *sync.Mutex, modelReq *openai.ChatCompletionRequest, usage *mycommon.ChatUsage) {
+	modelName := modelReq.Model
+	var streamUsage *openai.Usage
+	var completion strings.Builder
+	defer func() {
+		usage.Add(modelReq, streamUsage, completion.String())
+	}()
+
 	for {
 		chatResp, err := chatStream.Recv()
 		if errors.Is(err, io.EOF) {
@@ -160,7 +231,11 @@ func processChatStream(conn *websocket.Conn, chatStream *simple_client.SimpleCha
 		}
 
 		mylog.Logger.Debug("Received chat response", zap.Any("chatResp", chatResp), zap.Int("len(chatResp.Choices)", len(chatResp.Choices)))
+		if chatResp.Usage != nil {
+			streamUsage = chatResp.Usage
+		}
 		if len(chatResp.Choices) > 0 {
+			completion.WriteString(chatResp.Choices[0].Delta.Content)
 
 			resp := MMResp{
 				Result: chatResp.Choices[0].Delta.Content,
@@ -18,6 +18,7 @@ func init() {
 }
 
 type SimpleClient struct {
+	namespace string
 }
 
 func NewSimpleClient(authToken string) *SimpleClient {
@@ -32,6 +33,11 @@ func NewSimpleClientWithConfig() *SimpleClient {
 	}
 }
 
+// NewSimpleClientWithNamespace 创建只使用指定命名空间中服务的客户端
+func NewSimpleClientWithNamespace(namespace string) *SimpleClient {
+	return &SimpleClient{namespace: namespace}
+}
+
 func (c *SimpleClient) CreateChatCompletion(
 	ctx context.Context,
 	request openai.ChatCompletionRequest,
@@ -44,7 +50,7 @@ func (c *SimpleClient) CreateChatCompletion(
 	// 创建Gin的实例和配置路由
 	ginc := gin.New()
 	ginc.POST("/v1/chat/completions", func(ctx *gin.Context) {
-		handler.HandleOpenAIRequest(ctx, &request, "")
+		handler.HandleOpenAIRequest(ctx, &request, c.namespace)
 	})
 
 	// 创建响应记录器
@@ -83,7 +89,7 @@ func (c *SimpleClient) CreateChatCompletionStream(
 		ctx.Next()
 	})
 	ginc.POST("/v1/chat/completions", func(ctx *gin.Context) {
-		handler.HandleOpenAIRequest(ctx, &request, "")
+		handler.HandleOpenAIRequest(ctx, &request, c.namespace)
 	})
 
 	// 模拟发送请求
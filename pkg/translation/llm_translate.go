@@ -9,6 +9,8 @@ import (
 	"go.uber.org/zap"
 	"io"
 	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/myauth"
+	"simple-one-api/pkg/mycommon"
 	"simple-one-api/pkg/mylog"
 	"simple-one-api/pkg/simple_client"
 )
@@ -26,6 +28,15 @@ func createLLMTranslationPromptJson(srcText string, srcLang string, targetLang s
 
 var defaultLLMTransPrompt = "你是一个机器翻译接口，遵循以下输入输出协议，当接收到输入，直接给出输出即可，不要任何多余的回复\n输入：\n```\n将以下文本翻译为目标语言：DE\n文本:\n\n\nHello world!\n```\n\n翻译结果直接输出：\n\nHallo, Welt!\n\n现在我的输入是：\n```\n将以下文本翻译为目标语言：%s\n文本:\n\n\n%s\n```\n输出："
 
+// resolveTranslationModel 翻译使用的模型，未配置translation.model时在API key所属的namespace中随机选择一个模型；
+// 鉴权、限流和预算都针对实际使用的模型
+func resolveTranslationModel(apiKey string) (string, error) {
+	if config.GTranslation != nil && config.GTranslation.Model != "" {
+		return config.GTranslation.Model, nil
+	}
+	return config.GetRandomEnabledModel(myauth.LookupNamespace(apiKey))
+}
+
 func createLLMTranslationPrompt(srcText string, srcLang string, targetLang string) string {
 	prompt := defaultLLMTransPrompt
 	if config.GTranslation.PromptTemplate != "" {
@@ -35,13 +46,23 @@ func createLLMTranslationPrompt(srcText string, srcLang string, targetLang strin
 	return fmt.Sprintf(prompt, targetLang, srcText)
 }
 
-func LLMTranslate(srcText string, srcLang string, targetLang string) (string, error) {
+// estimateTranslationTokens 估算翻译texts占用的token数：每段文本的提示词，以及与原文长度相当的译文
+func estimateTranslationTokens(texts []string, srcLang string, targetLang string) int64 {
+	var tokens int
+	for _, text := range texts {
+		tokens += mycommon.EstimateTextTokens(createLLMTranslationPrompt(text, srcLang, targetLang)) + mycommon.EstimateTextTokens(text)
+	}
+	return int64(tokens)
+}
+
+// LLMTranslate 使用namespace中的model翻译，调用的token用量累加到usage
+func LLMTranslate(namespace string, model string, srcText string, srcLang string, targetLang string, usage *mycommon.ChatUsage) (string, error) {
 
 	prompt := createLLMTranslationPrompt(srcText, srcLang, targetLang)
 
 	var req openai.ChatCompletionRequest
 	req.Stream = false
-	req.Model = "random"
+	req.Model = model
 
 	message := openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
@@ -50,7 +71,7 @@ func LLMTranslate(srcText string, srcLang string, targetLang string) (string, er
 
 	req.Messages = append(req.Messages, message)
 
-	client := simple_client.NewSimpleClient("")
+	client := simple_client.NewSimpleClientWithNamespace(namespace)
 
 	resp, err := client.CreateChatCompletion(context.Background(), req)
 	if err != nil {
@@ -60,6 +81,7 @@ func LLMTranslate(srcText string, srcLang string, targetLang string) (string, er
 
 	if len(resp.Choices) > 0 {
 		mylog.Logger.Info("Received chat response", zap.String("content", resp.Choices[0].Message.Content))
+		usage.Add(&req, &resp.Usage, resp.Choices[0].Message.Content)
 
 		return resp.Choices[0].Message.Content, nil
 	}
@@ -67,13 +89,14 @@ func LLMTranslate(srcText string, srcLang string, targetLang string) (string, er
 	return "", errors.New("no result")
 }
 
-func LLMTranslateStream(srcText string, srcLang string, targetLang string, cb func(string)) (string, error) {
+// LLMTranslateStream 流式翻译，每收到一段结果调用cb，结束时把token用量累加到usage
+func LLMTranslateStream(namespace string, model string, srcText string, srcLang string, targetLang string, cb func(string), usage *mycommon.ChatUsage) (string, error) {
 	var allResult string
 	prompt := createLLMTranslationPrompt(srcText, srcLang, targetLang)
 
 	var req openai.ChatCompletionRequest
 	req.Stream = false
-	req.Model = "random"
+	req.Model = model
 
 	message := openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
@@ -82,7 +105,7 @@ func LLMTranslateStream(srcText string, srcLang string, targetLang string, cb fu
 
 	req.Messages = append(req.Messages, message)
 
-	client := simple_client.NewSimpleClient("")
+	client := simple_client.NewSimpleClientWithNamespace(namespace)
 
 	chatStream, err := client.CreateChatCompletionStream(context.Background(), req)
 	if err != nil {
@@ -90,6 +113,12 @@ func LLMTranslateStream(srcText string, srcLang string, targetLang string, cb fu
 		return "", err
 	}
 
+	// 中途出错时已经生成的内容同样计入用量
+	var streamUsage *openai.Usage
+	defer func() {
+		usage.Add(&req, streamUsage, allResult)
+	}()
+
 	for {
 		var chatResp *openai.ChatCompletionStreamResponse
 		chatResp, err = chatStream.Recv()
@@ -107,6 +136,9 @@ func LLMTranslateStream(srcText string, srcLang string, targetLang string, cb fu
 		}
 
 		mylog.Logger.Info("Received chat response", zap.Any("chatResp", chatResp))
+		if chatResp.Usage != nil {
+			streamUsage = chatResp.Usage
+		}
 		if len(chatResp.Choices) > 0 {
 			cb(chatResp.Choices[0].Delta.Content)
 
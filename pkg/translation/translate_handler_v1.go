@@ -5,6 +5,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"net/http"
+	"simple-one-api/pkg/mycommon"
 	"simple-one-api/pkg/mylog"
 	"simple-one-api/pkg/utils"
 )
@@ -30,14 +31,17 @@ type TranslationV1Response struct {
 
 // translateHandler 处理翻译请求的函数
 func TranslateV1Handler(c *gin.Context) {
-	// 处理 Authorization 验证
-	token := c.GetHeader("Authorization")
-	if token == "" {
-		token = c.Query("token")
-		if token == "" {
-			//c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-			//return
-		}
+	// 处理 Authorization 验证，与chat completions使用相同的鉴权、限流(包括tpm/tpd)和预算检查
+	// v1接口原本就支持通过token查询参数传API key，保留兼容
+	apiKey := utils.GetAPIKeyFromRequest(c)
+	if apiKey == "" {
+		apiKey = c.Query("token")
+	}
+	model, err := resolveTranslationModel(apiKey)
+	if err != nil {
+		mylog.Logger.Error("No model available for translation", zap.Error(err))
+		mycommon.SendOpenAIErrorResponse(c, http.StatusBadRequest, err.Error(), "invalid_request_error", "model_not_found")
+		return
 	}
 
 	// 绑定请求 JSON 数据
@@ -47,6 +51,16 @@ func TranslateV1Handler(c *gin.Context) {
 		return
 	}
 
+	authResult, grant, ok := mycommon.AuthorizeRequest(c, apiKey, model, estimateTranslationTokens([]string{req.Text}, req.SourceLang, req.TargetLang))
+	if !ok {
+		return
+	}
+	namespace := authResult.Namespace
+
+	// 与chat completions一样按实际用量结算tpm/tpd并计入API key的预算
+	usage := &mycommon.ChatUsage{}
+	defer grant.Done(usage)
+
 	if req.Stream {
 		utils.SetEventStreamHeaders(c)
 
@@ -64,7 +78,7 @@ func TranslateV1Handler(c *gin.Context) {
 			c.Writer.(http.Flusher).Flush()
 		}
 
-		_, err := LLMTranslateStream(req.Text, req.SourceLang, req.TargetLang, cb)
+		_, err := LLMTranslateStream(namespace, model, req.Text, req.SourceLang, req.TargetLang, cb, usage)
 		if err != nil {
 			mylog.Logger.Error("Error binding JSON:", zap.Error(err))
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -73,7 +87,7 @@ func TranslateV1Handler(c *gin.Context) {
 
 		return
 	} else {
-		targetText, err := LLMTranslate(req.Text, req.SourceLang, req.TargetLang)
+		targetText, err := LLMTranslate(namespace, model, req.Text, req.SourceLang, req.TargetLang, usage)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
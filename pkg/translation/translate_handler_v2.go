@@ -5,6 +5,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"net/http"
+	"simple-one-api/pkg/mycommon"
 	"simple-one-api/pkg/mylog"
 	"simple-one-api/pkg/utils"
 	"sync"
@@ -26,7 +27,7 @@ type TranslationV2Result struct {
 	Text                   string `json:"text"`
 }
 
-func translateStream(c *gin.Context, transReq *TranslationV2Request) error {
+func translateStream(c *gin.Context, transReq *TranslationV2Request, namespace string, model string, usage *mycommon.ChatUsage) error {
 	utils.SetEventStreamHeaders(c)
 
 	cb := func(dstText string) {
@@ -45,7 +46,7 @@ func translateStream(c *gin.Context, transReq *TranslationV2Request) error {
 		c.Writer.(http.Flusher).Flush()
 	}
 
-	_, err := LLMTranslateStream(transReq.Text[0], transReq.SourceLang, transReq.TargetLang, cb)
+	_, err := LLMTranslateStream(namespace, model, transReq.Text[0], transReq.SourceLang, transReq.TargetLang, cb, usage)
 	if err != nil {
 		mylog.Logger.Error("Error binding JSON:", zap.Error(err))
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -55,6 +56,15 @@ func translateStream(c *gin.Context, transReq *TranslationV2Request) error {
 }
 
 func TranslateV2Handler(c *gin.Context) {
+	// 与chat completions使用相同的鉴权、限流(包括tpm/tpd)和预算检查
+	apiKey := utils.GetAPIKeyFromRequest(c)
+	model, err := resolveTranslationModel(apiKey)
+	if err != nil {
+		mylog.Logger.Error("No model available for translation", zap.Error(err))
+		mycommon.SendOpenAIErrorResponse(c, http.StatusBadRequest, err.Error(), "invalid_request_error", "model_not_found")
+		return
+	}
+
 	var request TranslationV2Request
 	if err := c.ShouldBindJSON(&request); err != nil {
 		mylog.Logger.Error("Error binding JSON:", zap.Error(err))
@@ -62,8 +72,18 @@ func TranslateV2Handler(c *gin.Context) {
 		return
 	}
 
+	authResult, grant, ok := mycommon.AuthorizeRequest(c, apiKey, model, estimateTranslationTokens(request.Text, request.SourceLang, request.TargetLang))
+	if !ok {
+		return
+	}
+	namespace := authResult.Namespace
+
+	// 与chat completions一样按实际用量结算tpm/tpd并计入API key的预算
+	usage := &mycommon.ChatUsage{}
+	defer grant.Done(usage)
+
 	if request.Stream {
-		err := translateStream(c, &request)
+		err := translateStream(c, &request, namespace, model, usage)
 		if err != nil {
 			mylog.Logger.Error("Error translating stream:", zap.Error(err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -85,7 +105,7 @@ func TranslateV2Handler(c *gin.Context) {
 				defer func() { <-sem }() // 释放一个并发槽
 
 				var trv2 TranslationV2Result
-				dstText, err := LLMTranslate(text, "", request.TargetLang)
+				dstText, err := LLMTranslate(namespace, model, text, "", request.TargetLang, usage)
 				if err != nil {
 					mylog.Logger.Error("Error translating stream:", zap.Error(err))
 					return
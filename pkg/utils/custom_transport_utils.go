@@ -20,6 +20,7 @@ func (c *CustomTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	if err != nil {
 		return nil, err
 	}
+	RecordUpstreamHeader(req.Context(), resp)
 
 	// 检查 HTTP 状态码，如果是错误状态码，读取响应体并返回错误
 	if resp.StatusCode >= 400 {
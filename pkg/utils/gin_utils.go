@@ -33,3 +33,14 @@ func GetAPIKeyFromHeader(c *gin.Context) (string, error) {
 	}
 	return parts[1], nil
 }
+
+// GetAPIKeyFromRequest 从Authorization头部(Bearer或DeepL-Auth-Key)中获取API密钥，用于翻译等无法统一使用Bearer头部的入口
+func GetAPIKeyFromRequest(c *gin.Context) string {
+	authHeader := strings.TrimSpace(c.GetHeader("Authorization"))
+	for _, scheme := range []string{"Bearer ", "DeepL-Auth-Key "} {
+		if strings.HasPrefix(authHeader, scheme) {
+			return strings.TrimSpace(strings.TrimPrefix(authHeader, scheme))
+		}
+	}
+	return authHeader
+}
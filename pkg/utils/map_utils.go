@@ -10,3 +10,19 @@ func GetStringFromMap(data map[string]interface{}, key string) (string, bool) {
 	}
 	return "", false
 }
+
+// GetFloat64FromMap 试图从给定的 map 中提取指定键的数值，兼容json解析出的float64和yaml解析出的int
+func GetFloat64FromMap(data map[string]interface{}, key string) (float64, bool) {
+	switch v := data[key].(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
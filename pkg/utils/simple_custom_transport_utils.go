@@ -17,6 +17,7 @@ func (c *SimpleCustomTransport) RoundTrip(req *http.Request) (*http.Response, er
 	if err != nil {
 		return nil, err
 	}
+	RecordUpstreamHeader(req.Context(), resp)
 
 	// 检查 HTTP 状态码，如果是错误状态码，读取最多 1024 个字节的响应体并返回错误
 	if resp.StatusCode >= 400 {
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+type upstreamHeaderKey struct{}
+
+// UpstreamHeader 记录一次上游调用最后收到的响应状态码和header，用于根据Retry-After等header调整限流
+type UpstreamHeader struct {
+	mu         sync.Mutex
+	statusCode int
+	header     http.Header
+}
+
+// WithUpstreamHeader 返回带有UpstreamHeader的ctx，使用该ctx发出的请求经过SimpleCustomTransport时记录响应header；
+// 不使用请求ctx的handler需要在收到响应后自行调用RecordUpstreamHeader
+func WithUpstreamHeader(ctx context.Context) (context.Context, *UpstreamHeader) {
+	h := &UpstreamHeader{}
+	return context.WithValue(ctx, upstreamHeaderKey{}, h), h
+}
+
+// RecordUpstreamHeader 把响应的状态码和header记录到ctx中的UpstreamHeader，没有时忽略
+func RecordUpstreamHeader(ctx context.Context, resp *http.Response) {
+	h, ok := ctx.Value(upstreamHeaderKey{}).(*UpstreamHeader)
+	if !ok || resp == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.statusCode = resp.StatusCode
+	h.header = resp.Header.Clone()
+}
+
+// Get 返回记录的状态码和header，没有收到响应时状态码为0
+func (h *UpstreamHeader) Get() (int, http.Header) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.statusCode, h.header
+}